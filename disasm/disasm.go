@@ -9,10 +9,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 
-	"github.com/go-interpreter/wagon/internal/stack"
 	"github.com/go-interpreter/wagon/wasm"
 	"github.com/go-interpreter/wagon/wasm/leb128"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
@@ -21,21 +21,29 @@ import (
 // Instr describes an instruction, consisting of an operator, with its
 // appropriate immediate value(s).
 type Instr struct {
-	Op ops.Op
+	Op     ops.Op
+	Offset int // The byte offset of the instruction in the function body's bytecode
 
 	// Immediates are arguments to an operator in the bytecode stream itself.
 	// Valid value types are:
 	// - (u)(int/float)(32/64)
 	// - wasm.BlockType
+	// The first immediate of the operators prefixed by ops.MiscPrefix is
+	// their opcode following the prefix, as an uint32.
 	Immediates []interface{}
 	NewStack   *StackInfo // non-nil if the instruction requires the current stack to be unwound.
 	Block      *BlockInfo // non-nil if the instruction starts a new block.
+
+	// Branches describes how the stack is unwound for each target of a
+	// br_table instruction, in the order of its immediates (the default
+	// target last).
+	Branches []StackInfo
 }
 
 // StackInfo stores details about a new stack ended by an instruction.
 type StackInfo struct {
-	StackTopDiff int64 // The difference between the stack depths at the end of the block
-	PreserveTop  bool  // Whether the value on the top of the stack should be preserved while unwinding
+	StackTopDiff int64 // The number of values to discard from the stack, below the preserved ones
+	Preserve     int   // The number of values on the top of the stack that should be preserved while unwinding
 }
 
 // BlockInfo stores details about a block created or ended by an instruction.
@@ -43,8 +51,11 @@ type BlockInfo struct {
 	Start     bool           // If true, this instruction starts a block. Else this instruction ends it.
 	Signature wasm.BlockType // The block signature
 	// The index to the accompanying control operator.
-	// For 'if', this is an index to the 'else' operator
-	// For else/loop/block, the index is to the 'end' operator
+	// For 'if', this is an index to the 'else' operator, or to the 'end'
+	// operator if the block has no 'else'.
+	// For loop/block, the index is to the 'end' operator.
+	// For else/end, the index is to the operator starting the block (or
+	// to the 'else' operator for the 'end' of an if...else block).
 	PairIndex int
 }
 
@@ -60,119 +71,251 @@ func (d *Disassembly) checkMaxDepth(depth int) {
 	}
 }
 
-var ErrStackUnderflow = errors.New("disasm: stack underflow")
+var (
+	ErrStackUnderflow = errors.New("disasm: stack underflow")
+	ErrUnmatchedEnd   = errors.New("disasm: else or end without a matching block")
+)
+
+// frame is a control frame: the function body, or a block created by a
+// control operator.
+type frame struct {
+	index       int  // index of the operator starting the block, -1 for the function body
+	op          byte // the operator starting the block
+	params      int  // number of parameters of the block
+	results     int  // number of results of the block
+	height      int  // stack depth when the block started, below its parameters
+	unreachable bool // whether the rest of the block is unreachable
+}
+
+// arity returns the number of values taken by a branch to the label of
+// the block.
+func (f *frame) arity() int {
+	if f.op == ops.Loop {
+		return f.params
+	}
+	return f.results
+}
+
+// disassembler keeps track of the stack depth and the control frames
+// while disassembling a function.
+type disassembler struct {
+	disas  *Disassembly
+	depth  int
+	frames []frame
+}
+
+func (d *disassembler) top() *frame {
+	return &d.frames[len(d.frames)-1]
+}
+
+// pop pops n values off the stack. Values of the enclosing blocks can't be
+// popped, except in unreachable code, where the stack is polymorphic.
+func (d *disassembler) pop(n int) error {
+	f := d.top()
+	d.depth -= n
+	if d.depth < f.height {
+		if !f.unreachable {
+			return ErrStackUnderflow
+		}
+		d.depth = f.height
+	}
+	return nil
+}
+
+// push pushes n values on the stack.
+func (d *disassembler) push(n int) {
+	d.depth += n
+	d.disas.checkMaxDepth(d.depth)
+}
+
+// setUnreachable marks the rest of the current block as unreachable, after
+// an unconditional branch.
+func (d *disassembler) setUnreachable() {
+	f := d.top()
+	f.unreachable = true
+	d.depth = f.height
+}
+
+// branch returns how the stack is unwound by a branch to the label at the
+// relative depth.
+func (d *disassembler) branch(depth uint32) (StackInfo, error) {
+	if int(depth) >= len(d.frames) {
+		return StackInfo{}, InvalidLabelError(depth)
+	}
+	f := &d.frames[len(d.frames)-1-int(depth)]
+	arity := f.arity()
+	discard := d.depth - arity - f.height
+	if discard < 0 {
+		if !d.top().unreachable {
+			return StackInfo{}, ErrStackUnderflow
+		}
+		discard = 0
+	}
+	return StackInfo{StackTopDiff: int64(discard), Preserve: arity}, nil
+}
+
+// InvalidLabelError is returned when a branch refers to a label outside
+// of the enclosing blocks.
+type InvalidLabelError uint32
+
+func (e InvalidLabelError) Error() string {
+	return fmt.Sprintf("disasm: invalid nesting depth %d", uint32(e))
+}
+
+// readOp returns the operator whose opcode starts with op, reading the
+// opcode following ops.MiscPrefix from r, which it returns as an immediate.
+// It returns a wasm.FeatureDisabledError if the operator belongs to a
+// feature which is not enabled.
+func readOp(op byte, r io.Reader, features wasm.Features) (ops.Op, []interface{}, error) {
+	if op != ops.MiscPrefix {
+		if err := features.Check(ops.Feature(op)); err != nil {
+			return ops.Op{}, nil, err
+		}
+		opStr, err := ops.New(op)
+		return opStr, []interface{}{}, err
+	}
+
+	code, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return ops.Op{}, nil, err
+	}
+	if err := features.Check(ops.MiscFeature(code)); err != nil {
+		return ops.Op{}, nil, err
+	}
+	opStr, err := ops.NewMisc(code)
+	return opStr, []interface{}{code}, err
+}
 
 // Disassemble disassembles the given function. It also takes the function's
 // parent module as an argument for locating any other functions referenced by
-// fn.
-func Disassemble(fn wasm.Function, module *wasm.Module) (*Disassembly, error) {
+// fn. The operators of the proposals beyond the MVP are only accepted if
+// their features are enabled.
+func Disassemble(fn wasm.Function, module *wasm.Module, features wasm.Features) (*Disassembly, error) {
 	code := fn.Body.Code
 	reader := bytes.NewReader(code)
 	disas := &Disassembly{}
 
-	// a stack of current execution stack depth values, so that the depth for each
-	// stack is maintained indepepdently for calculating discard values
-	stackDepths := &stack.Stack{}
-	stackDepths.Push(0)
-	blockIndices := &stack.Stack{} // a stack of indices to operators which start new blocks
+	d := &disassembler{
+		disas: disas,
+		frames: []frame{{
+			index:   -1,
+			results: len(fn.Sig.ReturnTypes),
+		}},
+	}
 	curIndex := 0
-	var lastOpReturn bool
 
 	for {
+		offset := len(code) - reader.Len()
 		op, err := reader.ReadByte()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
-		logger.Printf("stack top is %d", stackDepths.Top())
+		logger.Printf("stack depth is %d", d.depth)
 
-		opStr, err := ops.New(op)
+		opStr, immediates, err := readOp(op, reader, features)
 		if err != nil {
 			return nil, err
 		}
 		instr := Instr{
 			Op:         opStr,
-			Immediates: [](interface{}){},
+			Offset:     offset,
+			Immediates: immediates,
 		}
 
 		logger.Printf("Name is %s", opStr.Name)
 		if !opStr.Polymorphic {
-			top := int(stackDepths.Top())
-			top -= len(opStr.Args)
-			stackDepths.SetTop(uint64(top))
-			if top < -1 {
-				return nil, ErrStackUnderflow
+			if err := d.pop(len(opStr.Args)); err != nil {
+				return nil, err
 			}
 			if opStr.Returns != wasm.ValueType(wasm.BlockTypeEmpty) {
-				top++
-				stackDepths.SetTop(uint64(top))
+				d.push(1)
 			}
-			disas.checkMaxDepth(top)
 		}
 
 		switch op {
+		case ops.Unreachable:
+			d.setUnreachable()
 		case ops.Drop:
-			stackDepths.SetTop(stackDepths.Top() - 1)
+			if err := d.pop(1); err != nil {
+				return nil, err
+			}
 		case ops.Select:
-			stackDepths.SetTop(stackDepths.Top() - 3)
+			if err := d.pop(3); err != nil {
+				return nil, err
+			}
+			d.push(1)
 		case ops.Return:
-			stackDepths.SetTop(stackDepths.Top() - uint64(len(fn.Sig.ReturnTypes)))
-			lastOpReturn = true
+			d.setUnreachable()
 		case ops.End, ops.Else:
-			// The max depth reached while execing the current block
-			curDepth := stackDepths.Top()
-			blockStartIndex := blockIndices.Pop()
-			blockSig := disas.Code[blockStartIndex].Block.Signature
+			if len(d.frames) == 1 {
+				return nil, ErrUnmatchedEnd
+			}
+			f := d.top()
+			start := &disas.Code[f.index]
+			if start.Block.Start {
+				start.Block.PairIndex = curIndex
+			}
 			instr.Block = &BlockInfo{
 				Start:     false,
-				Signature: blockSig,
-				PairIndex: int(blockStartIndex),
+				Signature: start.Block.Signature,
+				PairIndex: f.index,
 			}
 
-			// The max depth reached while execing the last block
-			// If the signature of the current block is not empty,
-			// this will be incremented
-			prevDepthIndex := stackDepths.Len() - 2
-			prevDepth := stackDepths.Get(prevDepthIndex)
-
-			if blockSig != wasm.BlockTypeEmpty {
-				stackDepths.Set(prevDepthIndex, prevDepth+1)
-				disas.checkMaxDepth(int(stackDepths.Get(prevDepthIndex)))
-			}
-
-			if !lastOpReturn {
-				elemsDiscard := int(curDepth) - int(prevDepth)
-				if elemsDiscard < -1 {
+			// unwind the stack to the results of the block.
+			discard := d.depth - f.results - f.height
+			if discard < 0 {
+				if !f.unreachable {
 					return nil, ErrStackUnderflow
 				}
-				instr.NewStack = &StackInfo{
-					StackTopDiff: int64(elemsDiscard),
-					PreserveTop:  blockSig != wasm.BlockTypeEmpty,
-				}
-			} else {
-				instr.NewStack = &StackInfo{}
+				discard = 0
+			}
+			instr.NewStack = &StackInfo{
+				StackTopDiff: int64(discard),
+				Preserve:     f.results,
 			}
 
-			stackDepths.Pop()
 			if op == ops.Else {
-				stackDepths.Push(0)
-				blockIndices.Push(uint64(curIndex))
+				// the else branch starts with the parameters of the block.
+				f.index = curIndex
+				f.unreachable = false
+				d.depth = f.height + f.params
+				break
 			}
+			d.depth = f.height
+			d.frames = d.frames[:len(d.frames)-1]
+			d.push(f.results)
 		case ops.Block, ops.Loop, ops.If:
-			sig, err := leb128.ReadVarint32(reader)
+			bt, err := wasm.ReadBlockType(reader)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := bt.TypeIndex(); ok {
+				if err := features.Check(wasm.FeatureMultiValue); err != nil {
+					return nil, err
+				}
+			}
+			sig, err := module.BlockSignature(bt)
 			if err != nil {
 				return nil, err
 			}
-			logger.Printf("if, depth is %d", stackDepths.Top())
-			stackDepths.Push(stackDepths.Top())
+			if err := d.pop(len(sig.ParamTypes)); err != nil {
+				return nil, err
+			}
+			d.frames = append(d.frames, frame{
+				index:   curIndex,
+				op:      op,
+				params:  len(sig.ParamTypes),
+				results: len(sig.ReturnTypes),
+				height:  d.depth,
+			})
+			d.push(len(sig.ParamTypes))
 			instr.Block = &BlockInfo{
 				Start:     true,
-				Signature: wasm.BlockType(sig),
+				Signature: bt,
 			}
-
-			blockIndices.Push(uint64(curIndex))
-			instr.Immediates = append(instr.Immediates, wasm.BlockType(sig))
+			instr.Immediates = append(instr.Immediates, bt)
 		case ops.Br, ops.BrIf:
 			depth, err := leb128.ReadVarUint32(reader)
 			if err != nil {
@@ -180,38 +323,37 @@ func Disassemble(fn wasm.Function, module *wasm.Module) (*Disassembly, error) {
 			}
 			instr.Immediates = append(instr.Immediates, depth)
 
-			curDepth := stackDepths.Top()
-			elemsDiscard := int64(int(curDepth) - int(stackDepths.Get(stackDepths.Len()-1-int(depth))))
-			if elemsDiscard < 0 {
-				return nil, ErrStackUnderflow
+			info, err := d.branch(depth)
+			if err != nil {
+				return nil, err
 			}
-
-			index := blockIndices.Get(blockIndices.Len() - 1 - int(depth))
-			instr.NewStack = &StackInfo{
-				StackTopDiff: elemsDiscard,
-				PreserveTop:  disas.Code[index].Block.Signature != wasm.BlockTypeEmpty,
+			instr.NewStack = &info
+			if op == ops.Br {
+				d.setUnreachable()
 			}
-
 		case ops.BrTable:
+			if err := d.pop(1); err != nil {
+				return nil, err
+			}
 			targetCount, err := leb128.ReadVarUint32(reader)
 			if err != nil {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, targetCount)
-			for i := uint32(0); i < targetCount; i++ {
+			for i := uint32(0); i <= targetCount; i++ {
 				entry, err := leb128.ReadVarUint32(reader)
 				if err != nil {
 					return nil, err
 				}
 				instr.Immediates = append(instr.Immediates, entry)
-			}
 
-			defaultTarget, err := leb128.ReadVarUint32(reader)
-			if err != nil {
-				return nil, err
+				info, err := d.branch(entry)
+				if err != nil {
+					return nil, err
+				}
+				instr.Branches = append(instr.Branches, info)
 			}
-			instr.Immediates = append(instr.Immediates, defaultTarget)
-			stackDepths.SetTop(stackDepths.Top() - 1)
+			d.setUnreachable()
 		case ops.Call, ops.CallIndirect:
 			index, err := leb128.ReadVarUint32(reader)
 			if err != nil {
@@ -225,12 +367,27 @@ func Disassemble(fn wasm.Function, module *wasm.Module) (*Disassembly, error) {
 				}
 				instr.Immediates = append(instr.Immediates, reserved)
 			}
-			fn := module.GetFunction(int(index))
-			top := int(stackDepths.Top())
-			top -= len(fn.Sig.ParamTypes)
-			top += len(fn.Sig.ReturnTypes)
-			stackDepths.SetTop(uint64(top))
-			disas.checkMaxDepth(top)
+			var sig *wasm.FunctionSig
+			if op == ops.CallIndirect {
+				if module.Types == nil || int(index) >= len(module.Types.Entries) {
+					return nil, wasm.InvalidTypeIndexError(index)
+				}
+				sig = &module.Types.Entries[index]
+				// the index into the table
+				if err := d.pop(1); err != nil {
+					return nil, err
+				}
+			} else {
+				fn := module.GetFunction(int(index))
+				if fn == nil {
+					return nil, wasm.InvalidFunctionIndexError(index)
+				}
+				sig = fn.Sig
+			}
+			if err := d.pop(len(sig.ParamTypes)); err != nil {
+				return nil, err
+			}
+			d.push(len(sig.ReturnTypes))
 		case ops.GetLocal, ops.SetLocal, ops.TeeLocal, ops.GetGlobal, ops.SetGlobal:
 			index, err := leb128.ReadVarUint32(reader)
 			if err != nil {
@@ -238,15 +395,13 @@ func Disassemble(fn wasm.Function, module *wasm.Module) (*Disassembly, error) {
 			}
 			instr.Immediates = append(instr.Immediates, index)
 
-			top := stackDepths.Top()
 			switch op {
 			case ops.GetLocal, ops.GetGlobal:
-				top++
-				stackDepths.SetTop(top)
-				disas.checkMaxDepth(int(top))
+				d.push(1)
 			case ops.SetLocal, ops.SetGlobal:
-				top--
-				stackDepths.SetTop(top)
+				if err := d.pop(1); err != nil {
+					return nil, err
+				}
 			case ops.TeeLocal:
 				// stack remains unchanged for tee_local
 			}
@@ -299,21 +454,6 @@ func Disassemble(fn wasm.Function, module *wasm.Module) (*Disassembly, error) {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, res)
-
-			curDepth := stackDepths.Top()
-			switch op {
-			case ops.CurrentMemory:
-				top := curDepth + 1
-				stackDepths.SetTop(top)
-				disas.checkMaxDepth(int(top))
-			case ops.GrowMemory:
-				top := curDepth - 1
-				stackDepths.SetTop(top)
-			}
-		}
-
-		if op != ops.Return {
-			lastOpReturn = false
 		}
 
 		disas.Code = append(disas.Code, instr)
@@ -12,20 +12,33 @@ func (vm *VM) i32Wrapi64() {
 	vm.pushUint32(uint32(vm.popUint64()))
 }
 
+// trunc returns the float value f truncated towards zero. It traps if f
+// is NaN, or if the result does not lie in the interval [min, max).
+func (vm *VM) trunc(f, min, max float64) float64 {
+	if math.IsNaN(f) {
+		vm.trap(TrapInvalidConversion)
+	}
+	t := math.Trunc(f)
+	if t < min || t >= max {
+		vm.trap(TrapIntegerOverflow)
+	}
+	return t
+}
+
 func (vm *VM) i32TruncSF32() {
-	vm.pushInt32(int32(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushInt32(int32(vm.trunc(float64(vm.popFloat32()), math.MinInt32, math.MaxInt32+1)))
 }
 
 func (vm *VM) i32TruncUF32() {
-	vm.pushUint32(uint32(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushUint32(uint32(vm.trunc(float64(vm.popFloat32()), 0, math.MaxUint32+1)))
 }
 
 func (vm *VM) i32TruncSF64() {
-	vm.pushInt32(int32(math.Trunc(vm.popFloat64())))
+	vm.pushInt32(int32(vm.trunc(vm.popFloat64(), math.MinInt32, math.MaxInt32+1)))
 }
 
 func (vm *VM) i32TruncUF64() {
-	vm.pushUint32(uint32(math.Trunc(vm.popFloat64())))
+	vm.pushUint32(uint32(vm.trunc(vm.popFloat64(), 0, math.MaxUint32+1)))
 }
 
 func (vm *VM) i64ExtendSI32() {
@@ -37,19 +50,19 @@ func (vm *VM) i64ExtendUI32() {
 }
 
 func (vm *VM) i64TruncSF32() {
-	vm.pushInt64(int64(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushInt64(int64(vm.trunc(float64(vm.popFloat32()), math.MinInt64, 1<<63)))
 }
 
 func (vm *VM) i64TruncUF32() {
-	vm.pushUint64(uint64(math.Trunc(float64(vm.popFloat32()))))
+	vm.pushUint64(uint64(vm.trunc(float64(vm.popFloat32()), 0, 1<<64)))
 }
 
 func (vm *VM) i64TruncSF64() {
-	vm.pushInt64(int64(math.Trunc(vm.popFloat64())))
+	vm.pushInt64(int64(vm.trunc(vm.popFloat64(), math.MinInt64, 1<<63)))
 }
 
 func (vm *VM) i64TruncUF64() {
-	vm.pushUint64(uint64(math.Trunc(vm.popFloat64())))
+	vm.pushUint64(uint64(vm.trunc(vm.popFloat64(), 0, 1<<64)))
 }
 
 func (vm *VM) f32ConvertSI32() {
@@ -91,3 +104,83 @@ func (vm *VM) f64ConvertUI64() {
 func (vm *VM) f64PromoteF32() {
 	vm.pushFloat64(float64(vm.popFloat32()))
 }
+
+// truncSatS32, truncSatU32, truncSatS64 and truncSatU64 convert f to an
+// integer, truncating it towards zero and saturating it to the range of
+// the integer type. NaN is converted to 0.
+
+func truncSatS32(f float64) int32 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= math.MinInt32:
+		return math.MinInt32
+	case f >= math.MaxInt32+1:
+		return math.MaxInt32
+	}
+	return int32(f)
+}
+
+func truncSatU32(f float64) uint32 {
+	switch {
+	case !(f > 0):
+		return 0
+	case f >= math.MaxUint32+1:
+		return math.MaxUint32
+	}
+	return uint32(f)
+}
+
+func truncSatS64(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= 1<<63:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+func truncSatU64(f float64) uint64 {
+	switch {
+	case !(f > 0):
+		return 0
+	case f >= 1<<64:
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
+
+func (vm *VM) i32TruncSatSF32() {
+	vm.pushInt32(truncSatS32(float64(vm.popFloat32())))
+}
+
+func (vm *VM) i32TruncSatUF32() {
+	vm.pushUint32(truncSatU32(float64(vm.popFloat32())))
+}
+
+func (vm *VM) i32TruncSatSF64() {
+	vm.pushInt32(truncSatS32(vm.popFloat64()))
+}
+
+func (vm *VM) i32TruncSatUF64() {
+	vm.pushUint32(truncSatU32(vm.popFloat64()))
+}
+
+func (vm *VM) i64TruncSatSF32() {
+	vm.pushInt64(truncSatS64(float64(vm.popFloat32())))
+}
+
+func (vm *VM) i64TruncSatUF32() {
+	vm.pushUint64(truncSatU64(float64(vm.popFloat32())))
+}
+
+func (vm *VM) i64TruncSatSF64() {
+	vm.pushInt64(truncSatS64(vm.popFloat64()))
+}
+
+func (vm *VM) i64TruncSatUF64() {
+	vm.pushUint64(truncSatU64(vm.popFloat64()))
+}
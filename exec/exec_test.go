@@ -5,17 +5,24 @@
 package exec_test
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"math"
 	"os"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-interpreter/wagon/exec"
 	"github.com/go-interpreter/wagon/validate"
 	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"github.com/go-interpreter/wagon/wast"
 )
 
 type testCase struct {
@@ -94,15 +101,15 @@ func runTest(fileName string, testCases []testCase, t testing.TB) {
 	}
 	defer file.Close()
 
-	module, err := wasm.ReadModule(file, nil)
+	module, err := wasm.ReadModule(file, nil, wasm.MVP)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = validate.VerifyModule(module); err != nil {
+	if err = validate.VerifyModule(module, wasm.MVP); err != nil {
 		t.Fatalf("%s: %v", fileName, err)
 	}
 
-	vm, err := exec.NewVM(module)
+	vm, err := exec.NewVM(module, wasm.MVP)
 	if err != nil {
 		t.Fatalf("%s: %v", fileName, err)
 	}
@@ -189,3 +196,1019 @@ func BenchmarkModules(b *testing.B) {
 		})
 	}
 }
+
+func hostModule(add, fmul interface{}) *wasm.Module {
+	m := wasm.NewModule()
+	m.Types.Entries = []wasm.FunctionSig{
+		{Form: 0, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Form: 0, ParamTypes: []wasm.ValueType{wasm.ValueTypeF32, wasm.ValueTypeF32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeF32}},
+	}
+	m.FunctionIndexSpace = []wasm.Function{
+		{Sig: &m.Types.Entries[0], Host: reflect.ValueOf(add)},
+		{Sig: &m.Types.Entries[1], Host: reflect.ValueOf(fmul)},
+	}
+	m.Export.Entries["add"] = wasm.ExportEntry{FieldStr: "add", Kind: wasm.ExternalFunction, Index: 0}
+	m.Export.Entries["fmul"] = wasm.ExportEntry{FieldStr: "fmul", Kind: wasm.ExternalFunction, Index: 1}
+	return m
+}
+
+func readHostModule(t *testing.T, env *wasm.Module) (*wasm.Module, error) {
+	file, err := os.Open("testdata/host.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	return wasm.ReadModule(file, func(name string) (*wasm.Module, error) {
+		if name != "env" {
+			t.Fatalf("unexpected module name %q", name)
+		}
+		return env, nil
+	}, wasm.MVP)
+}
+
+func TestHostFunctions(t *testing.T) {
+	var calls int
+	add := func(a, b int32) int32 {
+		calls++
+		return a + b
+	}
+	fmul := func(a, b float32) float32 {
+		return a * b
+	}
+
+	module, err := readHostModule(t, hostModule(add, fmul))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = validate.VerifyModule(module, wasm.MVP); err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		function string
+		args     []uint64
+		want     interface{}
+	}{
+		{"add", []uint64{2, 3}, uint32(5)},
+		{"call", []uint64{40, 2}, uint32(42)},
+		{"call", []uint64{uint64(uint32(0xffffffff)), 3}, uint32(2)},
+		{"double", []uint64{21}, uint32(42)},
+		{"call_indirect", []uint64{7, 8}, uint32(15)},
+		{"fmul", []uint64{uint64(math.Float32bits(1.5)), uint64(math.Float32bits(-4))}, float32(-6)},
+	} {
+		index := module.Export.Entries[tc.function].Index
+		res, err := vm.ExecCode(int64(index), tc.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.function, err)
+		}
+		if !reflect.DeepEqual(res, tc.want) {
+			t.Errorf("%s: unexpected return value: got=%v(%T), want=%v(%T)", tc.function, res, res, tc.want, tc.want)
+		}
+	}
+
+	if calls != 5 {
+		t.Errorf("host function add was called %d times, want 5", calls)
+	}
+}
+
+func TestHostFunctionSigMismatch(t *testing.T) {
+	env := hostModule(func(a, b int32) int32 { return 0 }, func(a, b float32) float32 { return 0 })
+	env.Types.Entries[0].ParamTypes[1] = wasm.ValueTypeI64
+
+	_, err := readHostModule(t, env)
+	if _, ok := err.(wasm.ImportSigMismatchError); !ok {
+		t.Fatalf("unexpected error: got=%v, want=wasm.ImportSigMismatchError", err)
+	}
+}
+
+func TestHostFunctionInvalidType(t *testing.T) {
+	env := hostModule(func(a int32, b float64) int32 { return 0 }, func(a, b float32) float32 { return 0 })
+
+	module, err := readHostModule(t, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exec.NewVM(module, wasm.MVP)
+	if _, ok := err.(exec.InvalidHostFunctionError); !ok {
+		t.Fatalf("unexpected error: got=%v, want=exec.InvalidHostFunctionError", err)
+	}
+}
+
+func readModule(t *testing.T, name string) *wasm.Module {
+	return readModuleEnv(t, name, nil)
+}
+
+// readModuleEnv reads and verifies the module in testdata/name, resolving
+// its imports to the module env.
+func readModuleEnv(t *testing.T, name string, env *wasm.Module) *wasm.Module {
+	return readModuleFeatures(t, name, env, wasm.MVP)
+}
+
+// readModuleFeatures reads and verifies the module in testdata/name with
+// the given features, resolving its imports to the module env.
+func readModuleFeatures(t *testing.T, name string, env *wasm.Module, features wasm.Features) *wasm.Module {
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	module, err := wasm.ReadModule(file, func(string) (*wasm.Module, error) {
+		if env == nil {
+			t.Fatalf("%s: unexpected import", name)
+		}
+		return env, nil
+	}, features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = validate.VerifyModule(module, features); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return module
+}
+
+func TestTraps(t *testing.T) {
+	for _, tc := range []struct {
+		file     string
+		function string
+		args     []uint64
+		want     exec.Trap
+	}{
+		{"trap.wasm", "unreachable", nil, exec.Trap{Kind: exec.TrapUnreachable, Function: 0, Offset: 1}},
+		{"trap.wasm", "div_s", []uint64{1, 0}, exec.Trap{Kind: exec.TrapIntegerDivideByZero, Function: 1, Offset: 4}},
+		{"trap.wasm", "load", []uint64{65536}, exec.Trap{Kind: exec.TrapOutOfBoundsMemory, Function: 2, Offset: 2}},
+		{"trap.wasm", "nested", nil, exec.Trap{Kind: exec.TrapUnreachable, Function: 0, Offset: 1}},
+		{"callindirect.wasm", "trap_oob", nil, exec.Trap{Kind: exec.TrapUndefinedElement, Function: 5, Offset: 6}},
+		{"callindirect.wasm", "trap_sig_mismatch", nil, exec.Trap{Kind: exec.TrapIndirectCallMismatch, Function: 5, Offset: 6}},
+	} {
+		module := readModule(t, tc.file)
+		vm, err := exec.NewVM(module, wasm.MVP)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+
+		index := module.Export.Entries[tc.function].Index
+		_, err = vm.ExecCode(int64(index), tc.args...)
+		if err != tc.want {
+			t.Errorf("%s, %s: unexpected error: got=%v, want=%v", tc.file, tc.function, err, tc.want)
+		}
+
+		// the VM should be reusable after a trap
+		if tc.file == "trap.wasm" {
+			res, err := vm.ExecCode(int64(module.Export.Entries["add"].Index), 1, 2)
+			if err != nil {
+				t.Fatalf("%s, %s: error after trap: %v", tc.file, tc.function, err)
+			}
+			if res != uint32(3) {
+				t.Errorf("%s, %s: unexpected return value after trap: got=%v, want=3", tc.file, tc.function, res)
+			}
+		}
+	}
+}
+
+func TestGrowMemory(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		opts []exec.VMOption
+		grow []uint32
+		want []int32
+		size int32
+	}{
+		// no maximum: limited by the 4GiB address space
+		{"grow.wasm", nil, []uint32{0, 2, 65534, math.MaxUint32}, []int32{1, 1, -1, -1}, 3},
+		// declared maximum of 4 pages
+		{"grow-max.wasm", nil, []uint32{1, 3, 2, 1, 0}, []int32{1, -1, 2, -1, 4}, 4},
+		{"grow-max.wasm", []exec.VMOption{exec.MaxMemoryPages(8)}, []uint32{4, 3}, []int32{-1, 1}, 4},
+		// embedder limit
+		{"grow.wasm", []exec.VMOption{exec.MaxMemoryPages(2)}, []uint32{2, 1, 1}, []int32{-1, 1, -1}, 2},
+		{"grow-max.wasm", []exec.VMOption{exec.MaxMemoryPages(3)}, []uint32{3, 2}, []int32{-1, 1}, 3},
+	} {
+		module := readModule(t, tc.file)
+		vm, err := exec.NewVM(module, wasm.MVP, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+
+		for i, n := range tc.grow {
+			res, err := vm.ExecCode(int64(module.Export.Entries["grow"].Index), uint64(n))
+			if err != nil {
+				t.Fatalf("%s: grow(%d): %v", tc.file, n, err)
+			}
+			if got := int32(res.(uint32)); got != tc.want[i] {
+				t.Errorf("%s: grow(%d): unexpected result: got=%d, want=%d", tc.file, n, got, tc.want[i])
+			}
+		}
+
+		res, err := vm.ExecCode(int64(module.Export.Entries["size"].Index))
+		if err != nil {
+			t.Fatalf("%s: size(): %v", tc.file, err)
+		}
+		if got := int32(res.(uint32)); got != tc.size {
+			t.Errorf("%s: unexpected memory size: got=%d, want=%d", tc.file, got, tc.size)
+		}
+	}
+}
+
+func TestMemoryLimitExceeded(t *testing.T) {
+	module := readModule(t, "grow.wasm")
+	_, err := exec.NewVM(module, wasm.MVP, exec.MaxMemoryPages(0))
+	if err != exec.ErrMemoryLimitExceeded {
+		t.Fatalf("unexpected error: got=%v, want=%v", err, exec.ErrMemoryLimitExceeded)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	env := wasm.NewModule()
+	env.GlobalIndexSpace = []wasm.GlobalEntry{
+		{Type: &wasm.GlobalVar{Type: wasm.ValueTypeI32}, Init: []byte{0x41, 42, 0x0b}}, // i32.const 42
+	}
+	env.Export.Entries["g"] = wasm.ExportEntry{FieldStr: "g", Kind: wasm.ExternalGlobal, Index: 0}
+
+	module := readModuleEnv(t, "globals.wasm", env)
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []interface{}{
+		uint32(42),
+		uint32(0xfffffffc), // -5, incremented by the start function
+		uint64(0xffffff0000000000),
+		uint32(0x7fa00001), // f32 bits
+		float64(2.5),
+		uint32(42),
+	} {
+		name := "get" + strconv.Itoa(i)
+		res, err := vm.ExecCode(int64(module.Export.Entries[name].Index))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if f, ok := res.(float32); ok {
+			res = math.Float32bits(f)
+		}
+		if res != want {
+			t.Errorf("%s: unexpected value: got=%#v, want=%#v", name, res, want)
+		}
+	}
+}
+
+func TestTeeLocal(t *testing.T) {
+	// tee_local sets the local, and leaves its operand on the stack.
+	m, err := wast.ReadModule(strings.NewReader(`(module
+  (func (export "tee") (param i32) (result i32) (local i32)
+    (i32.add (tee_local 1 (get_local 0)) (get_local 1))))`), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := vm.ExecCode(int64(m.Export.Entries["tee"].Index), 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != uint32(42) {
+		t.Errorf("unexpected result: got=%#v, want=%#v", res, uint32(42))
+	}
+}
+
+func TestBrDiscard(t *testing.T) {
+	// br leaves the value of the block on the stack, in place of the
+	// operands it discards.
+	m, err := wast.ReadModule(strings.NewReader(`(module
+  (func (export "br") (result i32)
+    (i32.add (i32.const 1)
+      (block (result i32) (i32.add (i32.const 4) (br 0 (i32.const 8)))))))`), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := vm.ExecCode(int64(m.Export.Entries["br"].Index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != uint32(9) {
+		t.Errorf("unexpected result: got=%#v, want=%#v", res, uint32(9))
+	}
+}
+
+func TestBrTableIndex(t *testing.T) {
+	// the index of br_table is unsigned: negative values select the
+	// default label.
+	m, err := wast.ReadModule(strings.NewReader(`(module
+  (func (export "br_table") (param i32) (result i32)
+    (block (block (br_table 0 1 (get_local 0))) (return (i32.const 1)))
+    (i32.const 2)))`), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		index int32
+		want  uint32
+	}{
+		{0, 1},
+		{1, 2},
+		{-1, 2},
+		{math.MinInt32, 2},
+	} {
+		res, err := vm.ExecCode(int64(m.Export.Entries["br_table"].Index), uint64(uint32(tc.index)))
+		if err != nil {
+			t.Fatalf("%d: %v", tc.index, err)
+		}
+		if res != tc.want {
+			t.Errorf("%d: unexpected result: got=%#v, want=%#v", tc.index, res, tc.want)
+		}
+	}
+}
+
+func TestGlobalImportMismatch(t *testing.T) {
+	for _, typ := range []wasm.GlobalVar{
+		{Type: wasm.ValueTypeI64},
+		{Type: wasm.ValueTypeI32, Mutable: true},
+	} {
+		typ := typ
+		env := wasm.NewModule()
+		env.GlobalIndexSpace = []wasm.GlobalEntry{
+			{Type: &typ, Init: []byte{0x41, 42, 0x0b}}, // i32.const 42
+		}
+		env.Export.Entries["g"] = wasm.ExportEntry{FieldStr: "g", Kind: wasm.ExternalGlobal, Index: 0}
+
+		file, err := os.Open("testdata/globals.wasm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = wasm.ReadModule(file, func(string) (*wasm.Module, error) {
+			return env, nil
+		}, wasm.AllFeatures)
+		file.Close()
+		if _, ok := err.(wasm.ImportGlobalMismatchError); !ok {
+			t.Errorf("%+v: unexpected error: got=%v, want=wasm.ImportGlobalMismatchError", typ, err)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	module := readModule(t, "trap.wasm")
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add, err := vm.Export("add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := add.Call(int32(-3), int32(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != int32(2) {
+		t.Errorf("add: unexpected result: got=%#v, want=%#v", res, int32(2))
+	}
+
+	for _, tc := range []struct {
+		args []interface{}
+		err  error
+	}{
+		{[]interface{}{int32(1)}, exec.ErrInvalidArgumentCount},
+		{[]interface{}{int32(1), int64(2)}, exec.InvalidArgumentTypeError{Index: 1, Wanted: wasm.ValueTypeI32, Got: int64(2)}},
+		{[]interface{}{uint32(1), int32(2)}, exec.InvalidArgumentTypeError{Index: 0, Wanted: wasm.ValueTypeI32, Got: uint32(1)}},
+	} {
+		if _, err := add.Call(tc.args...); err != tc.err {
+			t.Errorf("add%v: unexpected error: got=%v, want=%v", tc.args, err, tc.err)
+		}
+	}
+
+	unreachable, err := vm.Export("unreachable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unreachable.Call(); err == nil || err.(exec.Trap).Kind != exec.TrapUnreachable {
+		t.Errorf("unreachable: unexpected error: %v", err)
+	}
+
+	if _, err := vm.Export("foo"); err != exec.ExportNotFoundError("foo") {
+		t.Errorf("unexpected error: got=%v, want=%v", err, exec.ExportNotFoundError("foo"))
+	}
+}
+
+func TestExportFloat(t *testing.T) {
+	env := wasm.NewModule()
+	env.GlobalIndexSpace = []wasm.GlobalEntry{
+		{Type: &wasm.GlobalVar{Type: wasm.ValueTypeI32}, Init: []byte{0x41, 0, 0x0b}},
+	}
+	env.Export.Entries["g"] = wasm.ExportEntry{FieldStr: "g", Kind: wasm.ExternalGlobal, Index: 0}
+	module := readModuleEnv(t, "globals.wasm", env)
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]interface{}{
+		"get2": int64(-1 << 40),
+		"get4": float64(2.5),
+	} {
+		fn, err := vm.Export(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := fn.Call()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if res != want {
+			t.Errorf("%s: unexpected result: got=%#v, want=%#v", name, res, want)
+		}
+	}
+}
+
+func TestMultiValue(t *testing.T) {
+	module := readModuleFeatures(t, "multi-value.wasm", nil, wasm.FeatureMultiValue)
+	vm, err := exec.NewVM(module, wasm.FeatureMultiValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want []interface{}
+	}{
+		{"swap", []interface{}{int32(1), int32(2)}, []interface{}{int32(2), int32(1)}},
+		{"swap", []interface{}{int32(0), int32(2)}, []interface{}{int32(0), int32(0)}},
+		{"pair", nil, []interface{}{int64(-1), float32(1.5)}},
+	} {
+		fn, err := vm.Export(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := fn.Call(tc.args...)
+		if err != nil {
+			t.Fatalf("%s%v: %v", tc.name, tc.args, err)
+		}
+		if !reflect.DeepEqual(res, tc.want) {
+			t.Errorf("%s%v: unexpected result: got=%#v, want=%#v", tc.name, tc.args, res, tc.want)
+		}
+	}
+
+	index := module.Export.Entries["pair"].Index
+	res, err := vm.ExecCode(int64(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{uint64(1<<64 - 1), float32(1.5)}; !reflect.DeepEqual(res, want) {
+		t.Errorf("ExecCode: unexpected result: got=%#v, want=%#v", res, want)
+	}
+}
+
+func TestCompiledModule(t *testing.T) {
+	module := readModule(t, "instances.wasm")
+	compiled, err := exec.Compile(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each VM has its own linear memory and globals, which inc increments
+	// by 2 and 1 respectively, returning their sum.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vm, err := compiled.NewVM()
+			if err != nil {
+				errs <- err
+				return
+			}
+			inc, err := vm.Export("inc")
+			if err != nil {
+				errs <- err
+				return
+			}
+			for n := 1; n <= 100; n++ {
+				res, err := inc.Call()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if res != int32(3*n) {
+					errs <- fmt.Errorf("inc: unexpected result: got=%v, want=%v", res, 3*n)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGasMetering(t *testing.T) {
+	module := readModule(t, "instances.wasm")
+	costs := exec.NewGasCosts(1)
+	costs.Ops[ops.I32Load] = 10
+	vm, err := exec.NewVM(module, wasm.MVP, exec.GasMetering(costs, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc, err := vm.Export("inc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// inc executes 14 operators, including 2 loads.
+	const used = 12 + 2*10
+	if _, err := inc.Call(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.GasUsed() != used {
+		t.Errorf("unexpected gas used: got=%d, want=%d", vm.GasUsed(), used)
+	}
+
+	vm.SetGasLimit(used - 1)
+	_, err = inc.Call()
+	if trap, ok := err.(exec.Trap); !ok || trap.Kind != exec.TrapOutOfGas {
+		t.Errorf("unexpected error: got=%v, want trap %v", err, exec.TrapOutOfGas)
+	}
+	if vm.GasUsed() >= used {
+		t.Errorf("unexpected gas used after trap: got=%d, want<%d", vm.GasUsed(), used)
+	}
+
+	vm.SetGasLimit(used)
+	if _, err := inc.Call(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGasMeteringHostCall(t *testing.T) {
+	add := func(a, b int32) int32 { return a + b }
+	fmul := func(a, b float32) float32 { return a * b }
+	module, err := readHostModule(t, hostModule(add, fmul))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	costs := new(exec.GasCosts)
+	costs.Ops[ops.Call] = 1
+	costs.HostCall = 100
+	vm, err := exec.NewVM(module, wasm.MVP, exec.GasMetering(costs, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]uint64{
+		"add":  100, // the host function itself
+		"call": 101,
+	} {
+		index := module.Export.Entries[name].Index
+		if _, err := vm.ExecCode(int64(index), 1, 2); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if vm.GasUsed() != want {
+			t.Errorf("%s: unexpected gas used: got=%d, want=%d", name, vm.GasUsed(), want)
+		}
+	}
+}
+
+func TestExecCodeContext(t *testing.T) {
+	module := readModule(t, "loop-forever.wasm")
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"loop", "loop_if", "loop_table"} {
+		fn, err := vm.Export(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err = fn.CallContext(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: unexpected error: got=%v, want=%v", name, err, context.DeadlineExceeded)
+		}
+	}
+
+	// The VM can be used after a cancellation, and canceled contexts
+	// abort the execution before it starts.
+	answer, err := vm.Export("answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := answer.Call(); err != nil || res != int32(42) {
+		t.Errorf("answer: unexpected result: got=%v, %v, want=42", res, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := answer.CallContext(ctx); err != context.Canceled {
+		t.Errorf("answer: unexpected error: got=%v, want=%v", err, context.Canceled)
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	module := readModule(t, "recurse.wasm")
+	for _, tc := range []struct {
+		opts  []exec.VMOption
+		depth int32 // number of frames of the execution of depth
+		trap  bool
+	}{
+		{nil, 1000, false},
+		{[]exec.VMOption{exec.MaxCallDepth(100)}, 100, false},
+		{[]exec.VMOption{exec.MaxCallDepth(100)}, 101, true},
+		// each frame of depth has 1 local and at most 2 operands
+		{[]exec.VMOption{exec.MaxStackSize(30)}, 10, false},
+		{[]exec.VMOption{exec.MaxStackSize(30)}, 11, true},
+	} {
+		vm, err := exec.NewVM(module, wasm.MVP, tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		depth, err := vm.Export("depth")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := depth.Call(tc.depth - 1)
+		if tc.trap {
+			if trap, ok := err.(exec.Trap); !ok || trap.Kind != exec.TrapStackExhausted {
+				t.Errorf("depth %d: unexpected error: got=%v, want trap %v", tc.depth, err, exec.TrapStackExhausted)
+			}
+			continue
+		}
+		if err != nil || res != tc.depth {
+			t.Errorf("depth %d: unexpected result: got=%v, %v", tc.depth, res, err)
+		}
+	}
+
+	// Infinite recursions are bounded by the default limit.
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forever, err := vm.Export("forever")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := forever.Call(); err == nil || err.(exec.Trap).Kind != exec.TrapStackExhausted {
+		t.Errorf("forever: unexpected error: got=%v, want trap %v", err, exec.TrapStackExhausted)
+	}
+}
+
+func TestHostFunctionMemory(t *testing.T) {
+	var logged []string
+	env := wasm.NewModule()
+	env.Types.Entries = []wasm.FunctionSig{{ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}}}
+	env.FunctionIndexSpace = []wasm.Function{{
+		Sig: &env.Types.Entries[0],
+		Host: reflect.ValueOf(func(vm *exec.VM, ptr int32) {
+			s, err := vm.Memory().ReadCString(uint32(ptr))
+			if err != nil {
+				t.Error(err)
+			}
+			logged = append(logged, s)
+		}),
+	}}
+	env.Export.Entries["log"] = wasm.ExportEntry{FieldStr: "log", Kind: wasm.ExternalFunction, Index: 0}
+
+	module, err := wast.ReadModule(strings.NewReader(`(module
+  (import "env" "log" (func $log (param i32)))
+  (memory 1)
+  (data (i32.const 16) "h\c3\a9llo\00")
+  (func (export "run") (call $log (i32.const 16))))`), func(string) (*wasm.Module, error) {
+		return env, nil
+	}, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(module, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := vm.Export("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run.Call(); err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 1 || logged[0] != "héllo" {
+		t.Errorf("unexpected logged strings: %q", logged)
+	}
+}
+
+func TestImport(t *testing.T) {
+	resolve := func(m *wasm.Module) wasm.ResolveFunc {
+		return func(string) (*wasm.Module, error) { return m, nil }
+	}
+	a, err := wast.ReadModule(strings.NewReader(`(module
+  (memory (export "mem") 1)
+  (table (export "table") 2 anyfunc)
+  (func $seven (result i32) (i32.const 7))
+  (func (export "f") (result i32) (call $seven))
+  (func (export "load") (result i32) (i32.load (i32.const 0)))
+  (func (export "call") (param i32) (result i32) (call_indirect (result i32) (get_local 0)))
+  (data (i32.const 0) "\2a"))`), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := wast.ReadModule(strings.NewReader(`(module
+  (import "a" "f" (func $f (result i32)))
+  (import "a" "load" (func $load (result i32)))
+  (import "a" "mem" (memory 1))
+  (import "a" "table" (table 2 anyfunc))
+  (func $nine (result i32) (i32.const 9))
+  (elem (i32.const 1) $nine)
+  (data (i32.const 4) "\01")
+  (func (export "f") (result i32) (call $f))
+  (func (export "load") (result i32) (call $load))
+  (func (export "store") (param i32) (i32.store (i32.const 0) (get_local 0))))`), resolve(a), wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.TableIndexSpace[0]) != 2 || a.TableIndexSpace[0][1] != wasm.UninitializedElement {
+		t.Errorf("the table of the imported module was modified: %v", a.TableIndexSpace[0])
+	}
+
+	if _, err := exec.NewVM(b, wasm.MVP); err != (exec.UnboundImportError{ModuleName: "a", FieldName: "f"}) {
+		t.Errorf("unexpected error without binding: got=%v, want=%v", err, exec.UnboundImportError{ModuleName: "a", FieldName: "f"})
+	}
+
+	vmA, err := exec.NewVM(a, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmB, err := exec.NewVM(b, wasm.MVP, exec.Import("a", vmA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		vm   *exec.VM
+		name string
+		args []interface{}
+		want interface{}
+	}{
+		{vmB, "f", nil, int32(7)},
+		{vmB, "load", nil, int32(42)},
+		{vmB, "store", []interface{}{int32(5)}, nil},
+		{vmA, "load", nil, int32(5)},
+		{vmA, "call", []interface{}{int32(1)}, int32(9)},
+	} {
+		fn, err := tc.vm.Export(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := fn.Call(tc.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tc.name, tc.args, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s%v: got=%#v, want=%#v", tc.name, tc.args, got, tc.want)
+		}
+	}
+	if data, err := vmA.Memory().Read(4, 1); err != nil || data[0] != 1 {
+		t.Errorf("the data segment of the importing module was not written: %v, %v", data, err)
+	}
+	call, err := vmA.Export("call")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call.Call(int32(0)); err == nil || err.(exec.Trap).Kind != exec.TrapUndefinedElement {
+		t.Errorf("call(0): unexpected error: got=%v, want trap %v", err, exec.TrapUndefinedElement)
+	}
+}
+
+func TestImportInitExpr(t *testing.T) {
+	exporter := func(v int) *wasm.Module {
+		m, err := wast.ReadModule(strings.NewReader(fmt.Sprintf(`(module (global (export "g") i32 (i32.const %d)))`, v)), nil, wasm.MVP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	// The module is resolved against a module exporting 5, but bound to a
+	// VM exporting 9, which is the value its initializers must read.
+	b, err := wast.ReadModule(strings.NewReader(`(module
+  (import "a" "g" (global $g i32))
+  (global (export "h") i32 (get_global $g))
+  (memory 1)
+  (table 16 anyfunc)
+  (func $ten (result i32) (i32.const 10))
+  (func (export "load") (param i32) (result i32) (i32.load8_u (get_local 0)))
+  (func (export "call") (param i32) (result i32) (call_indirect (result i32) (get_local 0)))
+  (elem (get_global $g) $ten)
+  (data (get_global $g) "\2a"))`), func(string) (*wasm.Module, error) {
+		return exporter(5), nil
+	}, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmA, err := exec.NewVM(exporter(9), wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmB, err := exec.NewVM(b, wasm.MVP, exec.Import("a", vmA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := vmB.Global("h"); err != nil || got != int32(9) {
+		t.Errorf("value of the global: got=%v, %v, want=9", got, err)
+	}
+	for _, tc := range []struct {
+		name string
+		arg  int32
+		want int32
+	}{
+		{"load", 9, 42},
+		{"load", 5, 0},
+		{"call", 9, 10},
+	} {
+		fn, err := vmB.Export(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := fn.Call(tc.arg); err != nil || got != tc.want {
+			t.Errorf("%s(%d): got=%v, %v, want=%d", tc.name, tc.arg, got, err, tc.want)
+		}
+	}
+	call, err := vmB.Export("call")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call.Call(int32(5)); err == nil || err.(exec.Trap).Kind != exec.TrapUndefinedElement {
+		t.Errorf("call(5): unexpected error: got=%v, want trap %v", err, exec.TrapUndefinedElement)
+	}
+}
+
+// readLinkedModules returns two modules calling each other: a.f calls
+// b.g through the table of a, and b.g calls a.f, so that the execution of
+// b.g(n) has 2(n+1) frames. a.spin(n) executes a loop of n iterations,
+// which b.spin calls.
+func readLinkedModules(t *testing.T) (a, b *wasm.Module) {
+	a, err := wast.ReadModule(strings.NewReader(`(module
+  (type $t (func (param i32) (result i32)))
+  (table (export "table") 1 anyfunc)
+  (func (export "f") (type $t)
+    (if (result i32) (i32.eqz (get_local 0))
+      (then (i32.const 0))
+      (else (i32.add (i32.const 1)
+        (call_indirect (type $t) (i32.sub (get_local 0) (i32.const 1)) (i32.const 0))))))
+  (func (export "spin") (param i32)
+    (block (loop
+      (br_if 1 (i32.eqz (get_local 0)))
+      (set_local 0 (i32.sub (get_local 0) (i32.const 1)))
+      (br 0)))))`), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = wast.ReadModule(strings.NewReader(`(module
+  (type $t (func (param i32) (result i32)))
+  (import "a" "f" (func $f (type $t)))
+  (import "a" "spin" (func $spin (param i32)))
+  (import "a" "table" (table 1 anyfunc))
+  (func $g (export "g") (type $t) (call $f (get_local 0)))
+  (func (export "spin") (param i32) (call $spin (get_local 0)))
+  (elem (i32.const 0) $g))`), func(string) (*wasm.Module, error) {
+		return a, nil
+	}, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func TestImportGasMetering(t *testing.T) {
+	a, b := readLinkedModules(t)
+	vmA, err := exec.NewVM(a, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmB, err := exec.NewVM(b, wasm.MVP, exec.Import("a", vmA), exec.GasMetering(exec.NewGasCosts(1), 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spin, err := vmB.Export("spin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := spin.Call(int32(0)); err != nil {
+		t.Fatal(err)
+	}
+	base := vmB.GasUsed()
+	if _, err := spin.Call(int32(10)); err != nil {
+		t.Fatal(err)
+	}
+	if used := vmB.GasUsed(); used <= base+10 {
+		t.Errorf("the imported function was not metered: got=%d, want>%d", used, base+10)
+	}
+	_, err = spin.Call(int32(1000))
+	if trap, ok := err.(exec.Trap); !ok || trap.Kind != exec.TrapOutOfGas {
+		t.Errorf("unexpected error: got=%v, want trap %v", err, exec.TrapOutOfGas)
+	}
+	if vmA.GasUsed() != 0 {
+		t.Errorf("the exporting VM used gas: %d", vmA.GasUsed())
+	}
+}
+
+func TestImportCallDepth(t *testing.T) {
+	a, b := readLinkedModules(t)
+	for _, tc := range []struct {
+		opts []exec.VMOption
+		n    int32 // argument of g
+		trap bool
+	}{
+		{[]exec.VMOption{exec.MaxCallDepth(100)}, 49, false},
+		{[]exec.VMOption{exec.MaxCallDepth(100)}, 50, true},
+		// each pair of frames of f and g has 2 locals and at most 4 operands
+		{[]exec.VMOption{exec.MaxStackSize(60)}, 9, false},
+		{[]exec.VMOption{exec.MaxStackSize(60)}, 10, true},
+	} {
+		vmA, err := exec.NewVM(a, wasm.MVP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vmB, err := exec.NewVM(b, wasm.MVP, append(tc.opts, exec.Import("a", vmA))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := vmB.Export("g")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := g.Call(tc.n)
+		if tc.trap {
+			if trap, ok := err.(exec.Trap); !ok || trap.Kind != exec.TrapStackExhausted {
+				t.Errorf("g(%d): unexpected error: got=%v, want trap %v", tc.n, err, exec.TrapStackExhausted)
+			}
+			continue
+		}
+		if err != nil || res != tc.n {
+			t.Errorf("g(%d): unexpected result: got=%v, %v", tc.n, res, err)
+		}
+	}
+}
+
+func TestImportMutableGlobal(t *testing.T) {
+	a, err := wast.ReadModule(strings.NewReader(`(module
+  (global $g (export "g") (mut i32) (i32.const 1))
+  (func (export "inc") (set_global $g (i32.add (get_global $g) (i32.const 1)))))`), nil, wasm.FeatureMutableGlobals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := wast.ReadModule(strings.NewReader(`(module
+  (import "a" "g" (global $g (mut i32)))
+  (func (export "get") (result i32) (get_global $g))
+  (func (export "set") (param i32) (set_global $g (get_local 0))))`), func(string) (*wasm.Module, error) {
+		return a, nil
+	}, wasm.FeatureMutableGlobals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmA, err := exec.NewVM(a, wasm.FeatureMutableGlobals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmB, err := exec.NewVM(b, wasm.FeatureMutableGlobals, exec.Import("a", vmA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(vm *exec.VM, name string, args ...interface{}) interface{} {
+		fn, err := vm.Export(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := fn.Call(args...)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return res
+	}
+	call(vmA, "inc")
+	if got := call(vmB, "get"); got != int32(2) {
+		t.Errorf("value of the imported global: got=%v, want=2", got)
+	}
+	call(vmB, "set", int32(10))
+	if got, err := vmA.Global("g"); err != nil || got != int32(10) {
+		t.Errorf("value of the exported global: got=%v, %v, want=10", got, err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ExportNotFoundError is returned by (*VM).Export and (*VM).Global when
+// the module doesn't export a function (respectively a global) with the
+// given name.
+type ExportNotFoundError string
+
+func (e ExportNotFoundError) Error() string {
+	return fmt.Sprintf("exec: no function or global exported with name %q", string(e))
+}
+
+// InvalidArgumentTypeError is returned by (*ExportedFunction).Call when
+// the type of an argument doesn't match the function's signature.
+type InvalidArgumentTypeError struct {
+	Index  int            // Index of the argument
+	Wanted wasm.ValueType // Type of the parameter in the function's signature
+	Got    interface{}    // Value of the argument
+}
+
+func (e InvalidArgumentTypeError) Error() string {
+	return fmt.Sprintf("exec: invalid type for argument %d: wanted %v, got %T", e.Index, e.Wanted, e.Got)
+}
+
+// ExportedFunction is a function exported by the module of a VM, which
+// can be called with Go values.
+type ExportedFunction struct {
+	vm    *VM
+	index int64
+	Name  string
+	Sig   *wasm.FunctionSig
+}
+
+// Export returns the function exported by the VM's module under name.
+func (vm *VM) Export(name string) (*ExportedFunction, error) {
+	if vm.module.Export == nil {
+		return nil, ExportNotFoundError(name)
+	}
+	entry, ok := vm.module.Export.Entries[name]
+	if !ok || entry.Kind != wasm.ExternalFunction {
+		return nil, ExportNotFoundError(name)
+	}
+	fn := vm.module.GetFunction(int(entry.Index))
+	if fn == nil {
+		return nil, InvalidFunctionIndexError(int64(entry.Index))
+	}
+
+	return &ExportedFunction{
+		vm:    vm,
+		index: int64(entry.Index),
+		Name:  name,
+		Sig:   fn.Sig,
+	}, nil
+}
+
+// Call executes the function with the given arguments, and returns its
+// result. Each i32, i64, f32 and f64 parameter of the function must be
+// passed an int32, int64, float32 and float64 argument respectively,
+// and the result is returned with the same types, or nil if the function
+// returns no value. The results of a function with multiple results are
+// returned in a []interface{}.
+// Like (*VM).ExecCode, Call returns a Trap if the execution traps.
+func (f *ExportedFunction) Call(args ...interface{}) (interface{}, error) {
+	return f.CallContext(context.Background(), args...)
+}
+
+// CallContext is like Call, but aborts the execution when ctx is done, like
+// (*VM).ExecCodeContext.
+func (f *ExportedFunction) CallContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != len(f.Sig.ParamTypes) {
+		return nil, ErrInvalidArgumentCount
+	}
+
+	raw := make([]uint64, len(args))
+	for i, arg := range args {
+		typ := f.Sig.ParamTypes[i]
+		switch v := arg.(type) {
+		case int32:
+			if typ == wasm.ValueTypeI32 {
+				raw[i] = uint64(uint32(v))
+				continue
+			}
+		case int64:
+			if typ == wasm.ValueTypeI64 {
+				raw[i] = uint64(v)
+				continue
+			}
+		case float32:
+			if typ == wasm.ValueTypeF32 {
+				raw[i] = uint64(math.Float32bits(v))
+				continue
+			}
+		case float64:
+			if typ == wasm.ValueTypeF64 {
+				raw[i] = math.Float64bits(v)
+				continue
+			}
+		}
+		return nil, InvalidArgumentTypeError{Index: i, Wanted: typ, Got: arg}
+	}
+
+	res, err := f.vm.ExecCodeContext(ctx, f.index, raw...)
+	if err != nil {
+		return nil, err
+	}
+
+	if rtrns, ok := res.([]interface{}); ok {
+		for i, v := range rtrns {
+			rtrns[i] = signed(v)
+		}
+		return rtrns, nil
+	}
+	return signed(res), nil
+}
+
+// signed converts the integer result v of ExecCode to its signed type.
+func signed(v interface{}) interface{} {
+	switch v := v.(type) {
+	case uint32:
+		return int32(v)
+	case uint64:
+		return int64(v)
+	}
+	return v
+}
+
+// Global returns the current value of the global exported by the VM's
+// module under name, as an int32, int64, float32 or float64.
+func (vm *VM) Global(name string) (interface{}, error) {
+	if vm.module.Export == nil {
+		return nil, ExportNotFoundError(name)
+	}
+	entry, ok := vm.module.Export.Entries[name]
+	if !ok || entry.Kind != wasm.ExternalGlobal {
+		return nil, ExportNotFoundError(name)
+	}
+	global := vm.module.GetGlobal(int(entry.Index))
+	if global == nil {
+		return nil, wasm.InvalidGlobalIndexError(entry.Index)
+	}
+
+	v := *vm.globals[entry.Index]
+	switch global.Type.Type {
+	case wasm.ValueTypeI32:
+		return int32(v), nil
+	case wasm.ValueTypeI64:
+		return int64(v), nil
+	case wasm.ValueTypeF32:
+		return math.Float32frombits(uint32(v)), nil
+	case wasm.ValueTypeF64:
+		return math.Float64frombits(v), nil
+	}
+	return nil, InvalidReturnTypeError(global.Type.Type)
+}
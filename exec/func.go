@@ -10,6 +10,7 @@ import (
 	"reflect"
 
 	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/wasm"
 )
 
 type function interface {
@@ -19,33 +20,97 @@ type function interface {
 type compiledFunction struct {
 	code           []byte
 	branchTables   []*compile.BranchTable
-	maxDepth       int  // maximum stack depth reached while executing the function body
-	totalLocalVars int  // number of local variables used by the function
-	args           int  // number of arguments the function accepts
-	returns        bool // whether the function returns a value
+	offsets        compile.OffsetMap
+	maxDepth       int // maximum stack depth reached while executing the function body
+	totalLocalVars int // number of local variables used by the function
+	args           int // number of arguments the function accepts
+	returns        int // number of values the function returns
 }
 
 type goFunction struct {
-	val reflect.Value
-	typ reflect.Type
+	val    reflect.Value
+	typ    reflect.Type
+	withVM bool // whether the first parameter of the function is the calling VM
+}
+
+var vmType = reflect.TypeOf((*VM)(nil))
+
+// vmFunction is a function of the VM vm, which executes it. It is used for
+// the functions imported from other VMs (see Import), and for the elements
+// of tables, which may be shared by several VMs. A vmFunction with a nil vm
+// is an uninitialized table element.
+type vmFunction struct {
+	vm    *VM
+	index int64 // index of the function in the function index space of vm
+}
+
+func (fn vmFunction) call(vm *VM, index int64) {
+	if fn.vm == vm {
+		vm.funcs[fn.index].call(vm, fn.index)
+		return
+	}
+
+	// The arguments and results are moved between the stacks of the VMs.
+	// The callee executes with the gas meter and the call stack limits of
+	// vm, whose execution it continues. Its state is restored afterwards,
+	// as it may itself be executing code calling vm.
+	callee := fn.vm
+	n := len(vm.ctx.stack) - len(callee.module.FunctionIndexSpace[fn.index].Sig.ParamTypes)
+	prevCtx, prevDone := callee.ctx, callee.done
+	prevDepth, prevSize := callee.callDepth, callee.stackSize
+	prevMaxDepth, prevMaxSize := callee.maxCallDepth, callee.maxStackSize
+	prevGas, prevLimit, prevUsed := callee.gas, callee.gasLimit, callee.gasUsed
+	defer func() {
+		vm.gasUsed = callee.gasUsed
+		callee.ctx, callee.done = prevCtx, prevDone
+		callee.callDepth, callee.stackSize = prevDepth, prevSize
+		callee.maxCallDepth, callee.maxStackSize = prevMaxDepth, prevMaxSize
+		callee.gas, callee.gasLimit, callee.gasUsed = prevGas, prevLimit, prevUsed
+	}()
+
+	callee.callDepth, callee.stackSize = vm.callDepth, vm.stackSize
+	callee.maxCallDepth, callee.maxStackSize = vm.maxCallDepth, vm.maxStackSize
+	callee.gas, callee.gasLimit, callee.gasUsed = vm.gas, vm.gasLimit, vm.gasUsed
+	callee.ctx = execContext{
+		stack:   append([]uint64(nil), vm.ctx.stack[n:]...),
+		curFunc: fn.index,
+	}
+	callee.done = vm.done
+	vm.ctx.stack = vm.ctx.stack[:n]
+	callee.funcs[fn.index].call(callee, fn.index)
+	vm.ctx.stack = append(vm.ctx.stack, callee.ctx.stack...)
 }
 
 func (fn goFunction) call(vm *VM, index int64) {
+	if vm.gas != nil {
+		vm.useGas(vm.gas.HostCall)
+	}
 	numIn := fn.typ.NumIn()
 	args := make([]reflect.Value, numIn)
+	first := 0
+	if fn.withVM {
+		args[0] = reflect.ValueOf(vm)
+		first = 1
+	}
 
-	for i := numIn - 1; i >= 0; i-- {
+	for i := numIn - 1; i >= first; i-- {
 		val := reflect.New(fn.typ.In(i)).Elem()
 		raw := vm.popUint64()
 		kind := fn.typ.In(i).Kind()
 
 		switch kind {
-		case reflect.Float64, reflect.Float32:
+		case reflect.Float64:
 			val.SetFloat(math.Float64frombits(raw))
-		case reflect.Uint32, reflect.Uint64:
-			val.SetUint(raw)
-		case reflect.Int32, reflect.Int64:
+		case reflect.Float32:
+			val.SetFloat(float64(math.Float32frombits(uint32(raw))))
+		case reflect.Uint32:
+			val.SetUint(uint64(uint32(raw)))
+		case reflect.Uint64:
 			val.SetUint(raw)
+		case reflect.Int32:
+			val.SetInt(int64(int32(raw)))
+		case reflect.Int64:
+			val.SetInt(int64(raw))
 		default:
 			panic(fmt.Sprintf("exec: args %d invalid kind=%v", i, kind))
 		}
@@ -57,11 +122,17 @@ func (fn goFunction) call(vm *VM, index int64) {
 	for i, out := range rtrns {
 		kind := out.Kind()
 		switch kind {
-		case reflect.Float64, reflect.Float32:
+		case reflect.Float64:
 			vm.pushFloat64(out.Float())
-		case reflect.Uint32, reflect.Uint64:
+		case reflect.Float32:
+			vm.pushFloat32(float32(out.Float()))
+		case reflect.Uint32:
+			vm.pushUint32(uint32(out.Uint()))
+		case reflect.Uint64:
 			vm.pushUint64(out.Uint())
-		case reflect.Int32, reflect.Int64:
+		case reflect.Int32:
+			vm.pushInt32(int32(out.Int()))
+		case reflect.Int64:
 			vm.pushInt64(out.Int())
 		default:
 			panic(fmt.Sprintf("exec: return value %d invalid kind=%v", i, kind))
@@ -69,8 +140,89 @@ func (fn goFunction) call(vm *VM, index int64) {
 	}
 }
 
+// InvalidHostFunctionError is returned by NewVM when the Go type of a host
+// function does not match the signature of the function it implements.
+type InvalidHostFunctionError struct {
+	Index int              // Index into the function index space
+	Sig   wasm.FunctionSig // The signature of the function
+	Type  reflect.Type     // The type of the Go function
+}
+
+func (e InvalidHostFunctionError) Error() string {
+	return fmt.Sprintf("exec: host function %d has type %v, which does not match signature %v", e.Index, e.Type, e.Sig)
+}
+
+// kindMatches reports whether values of Go kind k can be used to represent
+// values of the WebAssembly type t.
+func kindMatches(k reflect.Kind, t wasm.ValueType) bool {
+	switch t {
+	case wasm.ValueTypeI32:
+		return k == reflect.Int32 || k == reflect.Uint32
+	case wasm.ValueTypeI64:
+		return k == reflect.Int64 || k == reflect.Uint64
+	case wasm.ValueTypeF32:
+		return k == reflect.Float32
+	case wasm.ValueTypeF64:
+		return k == reflect.Float64
+	}
+	return false
+}
+
+// newGoFunction checks that the host function fn is compatible with its
+// signature, and returns a goFunction for it.
+func newGoFunction(index int, fn wasm.Function) (goFunction, error) {
+	typ := fn.Host.Type()
+	err := InvalidHostFunctionError{Index: index, Sig: *fn.Sig, Type: typ}
+
+	if typ.Kind() != reflect.Func || typ.IsVariadic() {
+		return goFunction{}, err
+	}
+	first := 0
+	withVM := typ.NumIn() > 0 && typ.In(0) == vmType
+	if withVM {
+		first = 1
+	}
+	if typ.NumIn()-first != len(fn.Sig.ParamTypes) || typ.NumOut() != len(fn.Sig.ReturnTypes) {
+		return goFunction{}, err
+	}
+	for i, t := range fn.Sig.ParamTypes {
+		if !kindMatches(typ.In(first+i).Kind(), t) {
+			return goFunction{}, err
+		}
+	}
+	for i, t := range fn.Sig.ReturnTypes {
+		if !kindMatches(typ.Out(i).Kind(), t) {
+			return goFunction{}, err
+		}
+	}
+
+	return goFunction{val: fn.Host, typ: typ, withVM: withVM}, nil
+}
+
+// enterFrame accounts for a new frame executing the function compiled, and
+// traps if it exhausts the call stack.
+func (vm *VM) enterFrame(compiled compiledFunction) {
+	size := compiled.maxDepth + compiled.totalLocalVars
+	if vm.callDepth >= vm.maxCallDepth {
+		vm.trap(TrapStackExhausted)
+	}
+	if vm.maxStackSize != 0 && size > vm.maxStackSize-vm.stackSize {
+		vm.trap(TrapStackExhausted)
+	}
+	vm.callDepth++
+	vm.stackSize += size
+}
+
+// leaveFrame accounts for the return of the function compiled.
+func (vm *VM) leaveFrame(compiled compiledFunction) {
+	vm.callDepth--
+	vm.stackSize -= compiled.maxDepth + compiled.totalLocalVars
+}
+
 func (compiled compiledFunction) call(vm *VM, index int64) {
-	newStack := make([]uint64, compiled.maxDepth)
+	vm.checkDone()
+	vm.enterFrame(compiled)
+	newStack := make([]uint64, 0, compiled.maxDepth)
 	locals := make([]uint64, compiled.totalLocalVars)
 
 	for i := compiled.args - 1; i >= 0; i-- {
@@ -80,7 +232,7 @@ func (compiled compiledFunction) call(vm *VM, index int64) {
 	//save execution context
 	prevCtxt := vm.ctx
 
-	vm.ctx = context{
+	vm.ctx = execContext{
 		stack:   newStack,
 		locals:  locals,
 		code:    compiled.code,
@@ -88,12 +240,13 @@ func (compiled compiledFunction) call(vm *VM, index int64) {
 		curFunc: index,
 	}
 
-	rtrn := vm.execCode(compiled)
+	rtrns := vm.execCode(compiled)
 
 	//restore execution context
 	vm.ctx = prevCtxt
+	vm.leaveFrame(compiled)
 
-	if compiled.returns {
+	for _, rtrn := range rtrns {
 		vm.pushUint64(rtrn)
 	}
 }
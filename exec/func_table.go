@@ -27,6 +27,8 @@ func (vm *VM) newFuncTable() {
 	vm.funcTable[ops.I32ShrU] = vm.i32ShrU
 	vm.funcTable[ops.I32Rotl] = vm.i32Rotl
 	vm.funcTable[ops.I32Rotr] = vm.i32Rotr
+	vm.funcTable[ops.I32Extend8S] = vm.i32Extend8S
+	vm.funcTable[ops.I32Extend16S] = vm.i32Extend16S
 	vm.funcTable[ops.I32Eqz] = vm.i32Eqz
 	vm.funcTable[ops.I32Eq] = vm.i32Eq
 	vm.funcTable[ops.I32Ne] = vm.i32Ne
@@ -57,6 +59,9 @@ func (vm *VM) newFuncTable() {
 	vm.funcTable[ops.I64ShrU] = vm.i64ShrU
 	vm.funcTable[ops.I64Rotl] = vm.i64Rotl
 	vm.funcTable[ops.I64Rotr] = vm.i64Rotr
+	vm.funcTable[ops.I64Extend8S] = vm.i64Extend8S
+	vm.funcTable[ops.I64Extend16S] = vm.i64Extend16S
+	vm.funcTable[ops.I64Extend32S] = vm.i64Extend32S
 	vm.funcTable[ops.I64Eqz] = vm.i64Eqz
 	vm.funcTable[ops.I64Eq] = vm.i64Eq
 	vm.funcTable[ops.I64Ne] = vm.i64Ne
@@ -183,4 +188,20 @@ func (vm *VM) newFuncTable() {
 
 	vm.funcTable[ops.Call] = vm.call
 	vm.funcTable[ops.CallIndirect] = vm.callIndirect
+
+	vm.funcTable[ops.MiscPrefix] = vm.misc
+	vm.miscFuncTable[ops.I32TruncSatSF32] = vm.i32TruncSatSF32
+	vm.miscFuncTable[ops.I32TruncSatUF32] = vm.i32TruncSatUF32
+	vm.miscFuncTable[ops.I32TruncSatSF64] = vm.i32TruncSatSF64
+	vm.miscFuncTable[ops.I32TruncSatUF64] = vm.i32TruncSatUF64
+	vm.miscFuncTable[ops.I64TruncSatSF32] = vm.i64TruncSatSF32
+	vm.miscFuncTable[ops.I64TruncSatUF32] = vm.i64TruncSatUF32
+	vm.miscFuncTable[ops.I64TruncSatSF64] = vm.i64TruncSatSF64
+	vm.miscFuncTable[ops.I64TruncSatUF64] = vm.i64TruncSatUF64
+}
+
+// misc executes the operator prefixed by ops.MiscPrefix whose opcode
+// follows the prefix in the code.
+func (vm *VM) misc() {
+	vm.miscFuncTable[vm.fetchUint32()]()
 }
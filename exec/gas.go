@@ -0,0 +1,75 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "github.com/go-interpreter/wagon/exec/internal/compile"
+
+// Opcodes of the operators the VM executes in place of the control flow
+// operators of WebAssembly. They reuse the opcodes of operators that are
+// compiled away, and are metered by the corresponding entries of the Ops
+// table of GasCosts.
+const (
+	OpJmp                = compile.OpJmp                // unconditional jump (for br and else)
+	OpJmpZ               = compile.OpJmpZ               // jump if zero (for if)
+	OpJmpNz              = compile.OpJmpNz              // jump if not zero (for br_if)
+	OpDiscard            = compile.OpDiscard            // discards operands (at the end of blocks and for br)
+	OpDiscardPreserveTop = compile.OpDiscardPreserveTop // discards operands except the top one
+	OpDiscardPreserve    = compile.OpDiscardPreserve    // discards operands except the top ones (for multiple results)
+)
+
+// GasCosts is a table of the gas costs of the execution of operators.
+type GasCosts struct {
+	// Ops is the cost of executing an operator, indexed by its opcode,
+	// including the opcodes of the operators introduced by the VM (see
+	// OpJmp and following). The operators prefixed by
+	// operators.MiscPrefix all cost Ops[operators.MiscPrefix].
+	Ops [256]uint64
+	// HostCall is the cost of a call to a host function, which is added
+	// to the cost of the call operator.
+	HostCall uint64
+}
+
+// NewGasCosts returns a table where all operators have the same cost,
+// and host calls have no additional cost. NewGasCosts(1) counts the
+// number of executed operators.
+func NewGasCosts(cost uint64) *GasCosts {
+	costs := new(GasCosts)
+	for i := range costs.Ops {
+		costs.Ops[i] = cost
+	}
+	return costs
+}
+
+// GasMetering enables the metering of the execution of the VM with the
+// given costs. Each call to (*VM).ExecCode may use at most limit gas,
+// after which the execution aborts with a TrapOutOfGas trap.
+// costs must not be modified while the VM is used.
+func GasMetering(costs *GasCosts, limit uint64) VMOption {
+	return func(vm *VM) {
+		vm.gas = costs
+		vm.gasLimit = limit
+	}
+}
+
+// SetGasLimit sets the gas budget of the subsequent calls to ExecCode,
+// if the VM was created with GasMetering.
+func (vm *VM) SetGasLimit(limit uint64) {
+	vm.gasLimit = limit
+}
+
+// GasUsed returns the gas used by the last call to ExecCode, or 0 if the
+// VM wasn't created with GasMetering. If the gas budget was exhausted, it
+// is the gas used by the operators executed before the trap.
+func (vm *VM) GasUsed() uint64 {
+	return vm.gasUsed
+}
+
+// useGas consumes cost gas, and traps if it exceeds the budget.
+func (vm *VM) useGas(cost uint64) {
+	if cost > vm.gasLimit-vm.gasUsed {
+		vm.trap(TrapOutOfGas)
+	}
+	vm.gasUsed += cost
+}
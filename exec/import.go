@@ -0,0 +1,186 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// UnboundImportError is returned by NewVM when a function imported from a
+// WebAssembly module is not bound to a VM with Import. Unlike host
+// functions, such functions can only be executed by an instance of their
+// module.
+type UnboundImportError struct {
+	ModuleName string
+	FieldName  string
+}
+
+func (e UnboundImportError) Error() string {
+	return fmt.Sprintf("exec: imported function %s.%s is not bound to a VM", e.ModuleName, e.FieldName)
+}
+
+// ImportLimitsError is returned by NewVM when the size of a linear memory
+// or table imported from another VM (see Import) doesn't match the limits
+// declared by the import entry.
+type ImportLimitsError struct {
+	ModuleName string
+	FieldName  string
+}
+
+func (e ImportLimitsError) Error() string {
+	return fmt.Sprintf("exec: size of imported %s.%s does not match its limits", e.ModuleName, e.FieldName)
+}
+
+// Import binds the imports of the VM from the module named name to the
+// exports of exporter, a VM executing that module: the VM calls the
+// functions of exporter, and shares its linear memory, table and globals.
+// In particular, the changes made to an imported mutable global by either
+// VM are seen by the other.
+// The functions of exporter called by the VM are metered and limited like
+// the functions of the VM: they use the gas budget of the execution (see
+// GasMetering), and their frames count against the limits given by
+// MaxCallDepth and MaxStackSize to the VM.
+// The functions imported from a WebAssembly module must be bound with
+// Import, while the other imports may also be provided by the module
+// returned by the wasm.ResolveFunc which was given to wasm.ReadModule.
+func Import(name string, exporter *VM) VMOption {
+	return func(vm *VM) {
+		if vm.imports == nil {
+			vm.imports = make(map[string]*VM)
+		}
+		vm.imports[name] = exporter
+	}
+}
+
+// bindImports binds the imports of the VM's module to the VMs given by
+// Import, and checks that all the functions imported from WebAssembly
+// modules are bound.
+func (vm *VM) bindImports() error {
+	module := vm.module
+	if module.Import == nil {
+		return nil
+	}
+
+	copied := false
+	fnIndex, globalIndex := 0, 0
+	for _, entry := range module.Import.Entries {
+		exporter := vm.imports[entry.ModuleName]
+		if exporter == nil {
+			switch entry.Kind {
+			case wasm.ExternalFunction:
+				if vm.funcs[fnIndex] == nil {
+					return UnboundImportError{entry.ModuleName, entry.FieldName}
+				}
+				fnIndex++
+			case wasm.ExternalGlobal:
+				globalIndex++
+			}
+			continue
+		}
+
+		var export wasm.ExportEntry
+		ok := false
+		if exporter.module.Export != nil {
+			export, ok = exporter.module.Export.Entries[entry.FieldName]
+		}
+		if !ok {
+			return wasm.ExportNotFoundError{ModuleName: entry.ModuleName, FieldName: entry.FieldName}
+		}
+		if export.Kind != entry.Kind {
+			return wasm.KindMismatchError{
+				ModuleName: entry.ModuleName,
+				FieldName:  entry.FieldName,
+				Import:     entry.Kind,
+				Export:     export.Kind,
+			}
+		}
+
+		switch entry.Kind {
+		case wasm.ExternalFunction:
+			want := module.FunctionIndexSpace[fnIndex].Sig
+			got := exporter.module.FunctionIndexSpace[export.Index].Sig
+			if !want.Equal(*got) {
+				return wasm.ImportSigMismatchError{
+					ModuleName: entry.ModuleName,
+					FieldName:  entry.FieldName,
+					Wanted:     *want,
+					Got:        *got,
+				}
+			}
+			if !copied {
+				// the functions of the compiled module are shared
+				vm.funcs = append([]function(nil), vm.funcs...)
+				copied = true
+			}
+			vm.funcs[fnIndex] = vmFunction{exporter, int64(export.Index)}
+			fnIndex++
+		case wasm.ExternalMemory:
+			limits := entry.Type.(wasm.MemoryImport).Type.Limits
+			size := uint32(len(exporter.memory.data) / wasmPageSize)
+			if !limitsMatch(limits, size, exporter.memory.maxPages, true) {
+				return ImportLimitsError{entry.ModuleName, entry.FieldName}
+			}
+			vm.memory = exporter.memory
+		case wasm.ExternalTable:
+			limits := entry.Type.(wasm.TableImport).Type.Limits
+			var max uint32
+			hasMax := false
+			if t := tableType(exporter.module); t != nil && t.Limits.Flags&0x1 != 0 {
+				max, hasMax = t.Limits.Maximum, true
+			}
+			if !limitsMatch(limits, uint32(len(exporter.table)), max, hasMax) {
+				return ImportLimitsError{entry.ModuleName, entry.FieldName}
+			}
+			vm.table = exporter.table
+		case wasm.ExternalGlobal:
+			want := entry.Type.(wasm.GlobalVarImport).Type
+			got := *exporter.module.GlobalIndexSpace[export.Index].Type
+			if want != got {
+				return wasm.ImportGlobalMismatchError{
+					ModuleName: entry.ModuleName,
+					FieldName:  entry.FieldName,
+					Wanted:     want,
+					Got:        got,
+				}
+			}
+			vm.globals[globalIndex] = exporter.globals[export.Index]
+			globalIndex++
+		}
+	}
+
+	return nil
+}
+
+// limitsMatch reports whether a linear memory or table of the given size,
+// whose maximum size is max if hasMax is true, can be imported by an import
+// entry declaring limits.
+func limitsMatch(limits wasm.ResizableLimits, size, max uint32, hasMax bool) bool {
+	if size < limits.Initial {
+		return false
+	}
+	if limits.Flags&0x1 != 0 {
+		return hasMax && max <= limits.Maximum
+	}
+	return true
+}
+
+// tableType returns the description of the module's table, which is either
+// imported or declared in its table section. It returns nil if the module
+// has no table.
+func tableType(module *wasm.Module) *wasm.Table {
+	if module.Import != nil {
+		for _, entry := range module.Import.Entries {
+			if imp, ok := entry.Type.(wasm.TableImport); ok {
+				return &imp.Type
+			}
+		}
+	}
+	if module.Table != nil && len(module.Table.Entries) > 0 {
+		return &module.Table.Entries[0]
+	}
+	return nil
+}
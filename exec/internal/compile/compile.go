@@ -41,6 +41,7 @@ package compile
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 
 	"github.com/go-interpreter/wagon/disasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
@@ -54,39 +55,43 @@ import (
 // Instead of creating a new stack every time we enter a control structure,
 // we record the current stack height on encountering a control operator.
 // After we leave the sequence, the stack height is restored using the discard
-// operator. A block with a signature will push a value of that type on the parent
+// operator. A block with results will push these values on the parent
 // stack (that is, the stack of the parent block where this block started). The
-// OpDiscardPreserveTop operator allows us to preserve this value while
-// discarding the remaining ones.
+// OpDiscardPreserveTop and OpDiscardPreserve operators allow us to preserve
+// these values while discarding the remaining ones.
 
 // Branches are rewritten as
 //     <jmp> <addr>
 // Where the address is an 8 byte address, initially set to zero. It is
 // later "patched" by patchOffset.
 
-var (
+const (
 	// OpJmp unconditionally jumps to the provided address.
 	OpJmp byte = 0x0c
 	// OpJmpZ jumps to the given address if the value at the top of the stack is zero.
 	OpJmpZ byte = 0x03
 	// OpJmpNz jumps to the given address if the value at the top of the
-	// stack is not zero. It also discards elements and optionally preserves
-	// the topmost value on the stack
+	// stack is not zero. It also discards elements while preserving a given
+	// number of values on the top of the stack.
 	OpJmpNz byte = 0x0d
 	// OpDiscard discards a given number of elements from the execution stack.
 	OpDiscard byte = 0x0b
 	// OpDiscardPreserveTop discards a given number of elements from the
 	// execution stack, while preserving the value on the top of the stack.
 	OpDiscardPreserveTop byte = 0x05
+	// OpDiscardPreserve discards a given number of elements from the
+	// execution stack, while preserving a given number of values on the
+	// top of the stack. It is used for blocks with multiple results.
+	OpDiscardPreserve byte = 0x02
 )
 
 // Target is the "target" of a br_table instruction.
 // Unlike other control instructions, br_table does jumps and discarding all
 // by itself.
 type Target struct {
-	Addr        int64 // The absolute address of the target
-	Discard     int64 // The number of elements to discard
-	PreserveTop bool  // Whether the top of the stack is to be preserved
+	Addr     int64 // The absolute address of the target
+	Discard  int64 // The number of elements to discard
+	Preserve int64 // The number of values on the top of the stack to preserve
 }
 
 // BranchTable is the structure pointed to by a rewritten br_table instruction.
@@ -101,6 +106,36 @@ type BranchTable struct {
 	blocksLen     int      // The length of the blocks map in Compile when this table was initialized
 }
 
+// OffsetMap maps offsets in the compiled bytecode to the offsets of the
+// instructions they were compiled from, in the original function bytecode.
+type OffsetMap struct {
+	compiled []int64 // sorted compiled offsets where each instruction starts
+	original []int64 // the corresponding offsets in the original bytecode
+}
+
+func (m *OffsetMap) add(compiled int64, original int64) {
+	if n := len(m.compiled); n != 0 && m.compiled[n-1] == compiled {
+		// the previous instruction did not generate any code.
+		m.original[n-1] = original
+		return
+	}
+	m.compiled = append(m.compiled, compiled)
+	m.original = append(m.original, original)
+}
+
+// Lookup returns the offset in the original bytecode of the instruction
+// compiled to code containing the byte at the compiled offset pc.
+// It returns -1 if pc doesn't belong to any instruction.
+func (m OffsetMap) Lookup(pc int64) int64 {
+	i := sort.Search(len(m.compiled), func(i int) bool {
+		return m.compiled[i] > pc
+	})
+	if i == 0 {
+		return -1
+	}
+	return m.original[i-1]
+}
+
 // block stores the information relevant for a block created by a control operator
 // sequence (if...else...end, loop...end, and block...end)
 type block struct {
@@ -121,22 +156,45 @@ type block struct {
 	// in that case, the 'offset' field is set at the end of the block
 	loopBlock bool
 
-	patchOffsets []int64 // A list of offsets in the bytecode stream that need to be patched with the correct jump addresses
+	patchOffsets []int64        // A list of offsets in the bytecode stream that need to be patched with the correct jump addresses
+	branchTables []*BranchTable // All branch tables that were defined in this block.
+}
 
-	discard      disasm.StackInfo // Information about the stack created in this block, used while creating Discard instructions
-	branchTables []*BranchTable   // All branch tables that were defined in this block.
+// writeDiscard writes the code unwinding the stack as described by
+// stack, if any.
+func writeDiscard(buffer *bytes.Buffer, stack *disasm.StackInfo) {
+	if stack.StackTopDiff == 0 {
+		return
+	}
+	switch stack.Preserve {
+	case 0:
+		buffer.WriteByte(OpDiscard)
+	case 1:
+		buffer.WriteByte(OpDiscardPreserveTop)
+	default:
+		buffer.WriteByte(OpDiscardPreserve)
+		binary.Write(buffer, binary.LittleEndian, int64(stack.Preserve))
+	}
+	binary.Write(buffer, binary.LittleEndian, stack.StackTopDiff)
 }
 
 // Compile rewrites WebAssembly bytecode from its disassembly.
+// The returned OffsetMap maps the rewritten code back to the original
+// bytecode.
 // TODO(vibhavp): Add options for optimizing code. Operators like i32.reinterpret/f32
 // are no-ops, and can be safely removed.
-func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
+func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable, OffsetMap) {
 	buffer := new(bytes.Buffer)
 	branchTables := []*BranchTable{}
+	var offsets OffsetMap
 
 	curBlockDepth := -1
 	blocks := make(map[int]*block) // maps nesting depths (labels) to blocks
+	// the label of the function body, at depth -1, continues at the end of
+	// the code.
+	blocks[curBlockDepth] = &block{}
 	for _, instr := range disassembly {
+		offsets.add(int64(buffer.Len()), int64(instr.Offset))
 		switch instr.Op.Code {
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
 			// memory_immediate has two fields, the alignment and the offset.
@@ -170,6 +228,7 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 			}
 			continue
 		case ops.Else:
+			writeDiscard(buffer, instr.NewStack)
 			// add code for jumping out of a taken if branch
 			buffer.WriteByte(OpJmp)
 			ifBlockEndOffset := int64(buffer.Len())
@@ -188,19 +247,9 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 			depth := curBlockDepth
 			block := blocks[depth]
 
-			if instr.NewStack.StackTopDiff != 0 {
-				// when exiting a block, discard elements to
-				// restore stack height.
-				if instr.NewStack.PreserveTop {
-					// this is true when the block has a
-					// signature, and therefore pushes
-					// a value on to the stack
-					buffer.WriteByte(OpDiscardPreserveTop)
-				} else {
-					buffer.WriteByte(OpDiscard)
-				}
-				binary.Write(buffer, binary.LittleEndian, instr.NewStack.StackTopDiff)
-			}
+			// when exiting a block, discard elements to restore
+			// stack height, preserving the results of the block.
+			writeDiscard(buffer, instr.NewStack)
 
 			if !block.loopBlock { // is a normal block
 				block.offset = int64(buffer.Len())
@@ -223,14 +272,7 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 			curBlockDepth--
 			continue
 		case ops.Br:
-			if instr.NewStack.StackTopDiff != 0 {
-				if instr.NewStack.PreserveTop {
-					buffer.WriteByte(OpDiscardPreserveTop)
-				} else {
-					buffer.WriteByte(OpDiscard)
-				}
-				binary.Write(buffer, binary.LittleEndian, instr.NewStack.StackTopDiff)
-			}
+			writeDiscard(buffer, instr.NewStack)
 			buffer.WriteByte(OpJmp)
 			label := int(instr.Immediates[0].(uint32))
 			block := blocks[curBlockDepth-int(label)]
@@ -245,33 +287,31 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 			block.patchOffsets = append(block.patchOffsets, int64(buffer.Len()))
 			// write the jump address
 			binary.Write(buffer, binary.LittleEndian, int64(0))
-			// write whether we need to preserve the top
-			if !instr.NewStack.PreserveTop || instr.NewStack.StackTopDiff == 0 {
-				buffer.WriteByte(byte(0))
-			} else {
-				buffer.WriteByte(byte(1))
+			// write the number of values on the top of the stack we
+			// need to preserve
+			preserve := int64(instr.NewStack.Preserve)
+			if instr.NewStack.StackTopDiff == 0 {
+				preserve = 0
 			}
+			binary.Write(buffer, binary.LittleEndian, preserve)
 			// write the number of elements on the stack we need to discard
 			binary.Write(buffer, binary.LittleEndian, instr.NewStack.StackTopDiff)
 			continue
 		case ops.BrTable:
 			branchTable := &BranchTable{
-				blocksLen: len(blocks),
+				blocksLen: curBlockDepth + 1,
 			}
 			targetCount := instr.Immediates[0].(uint32)
-			branchTable.Targets = make([]Target, targetCount)
-			for i := range branchTable.Targets {
-				label := int64(instr.Immediates[i+1].(uint32))
-				branchTable.Targets[i].Addr = label
-				block := blocks[curBlockDepth-int(label)]
-				branchTable.Targets[i].Discard = block.discard.StackTopDiff
-				branchTable.Targets[i].PreserveTop = block.discard.PreserveTop
+			targets := make([]Target, targetCount+1)
+			for i := range targets {
+				targets[i] = Target{
+					Addr:     int64(instr.Immediates[i+1].(uint32)),
+					Discard:  instr.Branches[i].StackTopDiff,
+					Preserve: int64(instr.Branches[i].Preserve),
+				}
 			}
-			defaultLabel := int64(instr.Immediates[len(instr.Immediates)-1].(uint32))
-			branchTable.DefaultTarget.Addr = defaultLabel
-			defaultBlock := blocks[curBlockDepth-int(defaultLabel)]
-			branchTable.DefaultTarget.Discard = defaultBlock.discard.StackTopDiff
-			branchTable.DefaultTarget.PreserveTop = defaultBlock.discard.PreserveTop
+			branchTable.Targets = targets[:targetCount]
+			branchTable.DefaultTarget = targets[targetCount]
 			branchTables = append(branchTables, branchTable)
 			for _, block := range blocks {
 				block.branchTables = append(block.branchTables, branchTable)
@@ -290,10 +330,20 @@ func Compile(disassembly []disasm.Instr) ([]byte, []*BranchTable) {
 		}
 	}
 
+	// patch the branches to the label of the function body.
+	end := int64(buffer.Len())
+	for _, offset := range blocks[-1].patchOffsets {
+		code := buffer.Bytes()
+		buffer = patchOffset(code, offset, end)
+	}
+	for _, table := range blocks[-1].branchTables {
+		table.patchTable(table.blocksLen, end)
+	}
+
 	for _, table := range branchTables {
 		table.patchedAddrs = nil
 	}
-	return buffer.Bytes(), branchTables
+	return buffer.Bytes(), branchTables, offsets
 }
 
 // replace the address starting at start with addr
@@ -0,0 +1,155 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+var (
+	// ErrMemoryGrowth is returned by (Memory).Grow when the linear memory
+	// can't grow beyond its maximum size.
+	ErrMemoryGrowth = errors.New("exec: linear memory can't grow beyond its maximum size")
+	// ErrUnterminatedString is returned by (Memory).ReadCString when no NUL
+	// byte terminates the string.
+	ErrUnterminatedString = errors.New("exec: unterminated string in linear memory")
+	// ErrInvalidUTF8 is returned by the methods of Memory reading strings
+	// which aren't valid UTF-8.
+	ErrInvalidUTF8 = errors.New("exec: invalid UTF-8 string in linear memory")
+)
+
+// MemoryAccessError is returned by the methods of Memory when accessing
+// bytes outside the linear memory.
+type MemoryAccessError struct {
+	Offset uint32 // Offset of the first accessed byte
+	Size   uint64 // Number of accessed bytes
+}
+
+func (e MemoryAccessError) Error() string {
+	return fmt.Sprintf("exec: out of bounds memory access of %d bytes at offset %d", e.Size, e.Offset)
+}
+
+// Memory gives access to the linear memory of a VM. It can be used by host
+// functions while the VM executes code (see NewVM), and remains valid when
+// the memory grows.
+type Memory struct {
+	vm *VM
+}
+
+// Memory returns the linear memory of the VM. If the module has no linear
+// memory, its size is zero.
+func (vm *VM) Memory() Memory {
+	return Memory{vm}
+}
+
+// Size returns the size of the memory, in pages of 64 KiB.
+func (m Memory) Size() uint32 {
+	return uint32(len(m.vm.memory.data) / wasmPageSize)
+}
+
+// Grow grows the memory by n pages, like the grow_memory operator, and
+// returns its previous size in pages.
+func (m Memory) Grow(n uint32) (uint32, error) {
+	size, ok := m.vm.grow(n)
+	if !ok {
+		return 0, ErrMemoryGrowth
+	}
+	return size, nil
+}
+
+// bytes returns the n bytes of memory at offset.
+func (m Memory) bytes(offset uint32, n uint64) ([]byte, error) {
+	if uint64(offset)+n > uint64(len(m.vm.memory.data)) {
+		return nil, MemoryAccessError{Offset: offset, Size: n}
+	}
+	return m.vm.memory.data[offset : uint64(offset)+n], nil
+}
+
+// Read returns a copy of the n bytes of memory at offset.
+func (m Memory) Read(offset, n uint32) ([]byte, error) {
+	b, err := m.bytes(offset, uint64(n))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// Write copies b to the memory at offset.
+func (m Memory) Write(offset uint32, b []byte) error {
+	dst, err := m.bytes(offset, uint64(len(b)))
+	if err != nil {
+		return err
+	}
+	copy(dst, b)
+	return nil
+}
+
+// ReadUint32 reads the little-endian uint32 at offset.
+func (m Memory) ReadUint32(offset uint32) (uint32, error) {
+	b, err := m.bytes(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return endianess.Uint32(b), nil
+}
+
+// ReadUint64 reads the little-endian uint64 at offset.
+func (m Memory) ReadUint64(offset uint32) (uint64, error) {
+	b, err := m.bytes(offset, 8)
+	if err != nil {
+		return 0, err
+	}
+	return endianess.Uint64(b), nil
+}
+
+// ReadFloat32 reads the float32 at offset.
+func (m Memory) ReadFloat32(offset uint32) (float32, error) {
+	v, err := m.ReadUint32(offset)
+	return math.Float32frombits(v), err
+}
+
+// ReadFloat64 reads the float64 at offset.
+func (m Memory) ReadFloat64(offset uint32) (float64, error) {
+	v, err := m.ReadUint64(offset)
+	return math.Float64frombits(v), err
+}
+
+// ReadCString reads the UTF-8 string at offset, terminated by a NUL byte.
+func (m Memory) ReadCString(offset uint32) (string, error) {
+	if uint64(offset) >= uint64(len(m.vm.memory.data)) {
+		return "", MemoryAccessError{Offset: offset, Size: 1}
+	}
+	b := m.vm.memory.data[offset:]
+	n := bytes.IndexByte(b, 0)
+	if n < 0 {
+		return "", ErrUnterminatedString
+	}
+	return utf8String(b[:n])
+}
+
+// ReadPrefixedString reads the UTF-8 string at offset, prefixed by its
+// length in bytes as a little-endian uint32.
+func (m Memory) ReadPrefixedString(offset uint32) (string, error) {
+	n, err := m.ReadUint32(offset)
+	if err != nil {
+		return "", err
+	}
+	b, err := m.bytes(offset+4, uint64(n))
+	if err != nil {
+		return "", err
+	}
+	return utf8String(b)
+}
+
+func utf8String(b []byte) (string, error) {
+	if !utf8.Valid(b) {
+		return "", ErrInvalidUTF8
+	}
+	return string(b), nil
+}
@@ -4,129 +4,158 @@
 
 package exec
 
-import (
-	"math"
-)
+// memoryInstance is a linear memory, which is shared by the VM of the
+// module defining it and the VMs importing it (see Import).
+type memoryInstance struct {
+	data     []byte
+	maxPages uint32 // maximum size of the memory, in pages
+}
 
-func (vm *VM) fetchBaseAddr() int {
-	return int(vm.fetchUint32() + uint32(vm.popInt32()))
+// fetchBaseAddr returns the effective address of a memory access of
+// size bytes, computed from the offset on the bytecode stream and the
+// address on the top of the stack. It traps if any of the accessed bytes
+// lies outside the linear memory.
+func (vm *VM) fetchBaseAddr(size int) int {
+	// computed in 64 bits, so that the sum can't wrap around
+	addr := uint64(vm.fetchUint32()) + uint64(vm.popUint32())
+	if addr+uint64(size) > uint64(len(vm.memory.data)) {
+		vm.trap(TrapOutOfBoundsMemory)
+	}
+	return int(addr)
 }
 
-// curMem returns a slice to the memeory segment pointed to by
-// the current base address on the bytecode stream.
-func (vm *VM) curMem() []byte {
-	return vm.memory[vm.fetchBaseAddr():]
+// curMem returns a slice to the size bytes of the memory segment pointed
+// to by the current base address on the bytecode stream.
+func (vm *VM) curMem(size int) []byte {
+	addr := vm.fetchBaseAddr(size)
+	return vm.memory.data[addr : addr+size]
 }
 
 func (vm *VM) i32Load() {
-	vm.pushUint32(endianess.Uint32(vm.curMem()))
+	vm.pushUint32(endianess.Uint32(vm.curMem(4)))
 }
 
 func (vm *VM) i32Load8s() {
-	vm.pushInt32(int32(int8(vm.memory[vm.fetchBaseAddr()])))
+	vm.pushInt32(int32(int8(vm.curMem(1)[0])))
 }
 
 func (vm *VM) i32Load8u() {
-	vm.pushUint32(uint32(uint8(vm.memory[vm.fetchBaseAddr()])))
+	vm.pushUint32(uint32(uint8(vm.curMem(1)[0])))
 }
 
 func (vm *VM) i32Load16s() {
-	vm.pushInt32(int32(int16(endianess.Uint16(vm.curMem()))))
+	vm.pushInt32(int32(int16(endianess.Uint16(vm.curMem(2)))))
 }
 
 func (vm *VM) i32Load16u() {
-	vm.pushUint32(uint32(endianess.Uint16(vm.curMem())))
+	vm.pushUint32(uint32(endianess.Uint16(vm.curMem(2))))
 }
 
 func (vm *VM) i64Load() {
-	vm.pushUint64(endianess.Uint64(vm.curMem()))
+	vm.pushUint64(endianess.Uint64(vm.curMem(8)))
 }
 
 func (vm *VM) i64Load8s() {
-	vm.pushInt64(int64(int8(vm.memory[vm.fetchBaseAddr()])))
+	vm.pushInt64(int64(int8(vm.curMem(1)[0])))
 }
 
 func (vm *VM) i64Load8u() {
-	vm.pushUint64(uint64(uint8(vm.memory[vm.fetchBaseAddr()])))
+	vm.pushUint64(uint64(uint8(vm.curMem(1)[0])))
 }
 
 func (vm *VM) i64Load16s() {
-	vm.pushInt64(int64(int16(endianess.Uint16(vm.curMem()))))
+	vm.pushInt64(int64(int16(endianess.Uint16(vm.curMem(2)))))
 }
 
 func (vm *VM) i64Load16u() {
-	vm.pushUint64(uint64(endianess.Uint16(vm.curMem())))
+	vm.pushUint64(uint64(endianess.Uint16(vm.curMem(2))))
 }
 
 func (vm *VM) i64Load32s() {
-	vm.pushInt64(int64(int32(endianess.Uint32(vm.curMem()))))
+	vm.pushInt64(int64(int32(endianess.Uint32(vm.curMem(4)))))
 }
 
 func (vm *VM) i64Load32u() {
-	vm.pushUint64(uint64(endianess.Uint32(vm.curMem())))
+	vm.pushUint64(uint64(endianess.Uint32(vm.curMem(4))))
 }
 
+// float values are loaded and stored as raw bits, preserving NaN payloads.
+
 func (vm *VM) f32Store() {
-	v := math.Float32bits(vm.popFloat32())
-	endianess.PutUint32(vm.curMem(), v)
+	v := vm.popUint32()
+	endianess.PutUint32(vm.curMem(4), v)
 }
 
 func (vm *VM) f32Load() {
-	vm.pushFloat32(math.Float32frombits(endianess.Uint32(vm.curMem())))
+	vm.pushUint32(endianess.Uint32(vm.curMem(4)))
 }
 
 func (vm *VM) f64Store() {
-	v := math.Float64bits(vm.popFloat64())
-	endianess.PutUint64(vm.curMem(), v)
+	v := vm.popUint64()
+	endianess.PutUint64(vm.curMem(8), v)
 }
 
 func (vm *VM) f64Load() {
-	vm.pushFloat64(math.Float64frombits(endianess.Uint64(vm.curMem())))
+	vm.pushUint64(endianess.Uint64(vm.curMem(8)))
 }
 
 func (vm *VM) i32Store() {
 	v := vm.popUint32()
-	endianess.PutUint32(vm.curMem(), v)
+	endianess.PutUint32(vm.curMem(4), v)
 }
 
 func (vm *VM) i32Store8() {
 	v := byte(uint8(vm.popUint32()))
-	vm.memory[vm.fetchBaseAddr()] = v
+	vm.curMem(1)[0] = v
 }
 
 func (vm *VM) i32Store16() {
 	v := uint16(vm.popUint32())
-	endianess.PutUint16(vm.curMem(), v)
+	endianess.PutUint16(vm.curMem(2), v)
 }
 
 func (vm *VM) i64Store() {
 	v := vm.popUint64()
-	endianess.PutUint64(vm.curMem(), v)
+	endianess.PutUint64(vm.curMem(8), v)
 }
 
 func (vm *VM) i64Store8() {
 	v := byte(uint8(vm.popUint64()))
-	vm.memory[vm.fetchBaseAddr()] = v
+	vm.curMem(1)[0] = v
 }
 
 func (vm *VM) i64Store16() {
 	v := uint16(vm.popUint64())
-	endianess.PutUint16(vm.curMem(), v)
+	endianess.PutUint16(vm.curMem(2), v)
 }
 
 func (vm *VM) i64Store32() {
 	v := uint32(vm.popUint64())
-	endianess.PutUint32(vm.curMem(), v)
+	endianess.PutUint32(vm.curMem(4), v)
 }
 
 func (vm *VM) currentMemory() {
-	_ = vm.fetchInt8() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
-	vm.pushInt32(int32(len(vm.memory) / wasmPageSize))
+	_ = vm.fetchUint32() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
+	vm.pushInt32(int32(len(vm.memory.data) / wasmPageSize))
 }
 
 func (vm *VM) growMemory() {
-	curLen := len(vm.memory) / wasmPageSize
-	n := vm.popInt32()
-	vm.memory = append(vm.memory, make([]byte, n*wasmPageSize)...)
-	vm.pushInt32(int32(curLen))
+	_ = vm.fetchUint32() // reserved
+	curLen, ok := vm.grow(vm.popUint32())
+	if !ok {
+		vm.pushInt32(-1)
+		return
+	}
+	vm.pushUint32(curLen)
+}
+
+// grow grows the linear memory by n pages, and returns its previous size
+// in pages. It returns false if the memory can't grow beyond its maximum.
+func (vm *VM) grow(n uint32) (uint32, bool) {
+	curLen := uint32(len(vm.memory.data) / wasmPageSize)
+	if uint64(curLen)+uint64(n) > uint64(vm.memory.maxPages) {
+		return 0, false
+	}
+	vm.memory.data = append(vm.memory.data, make([]byte, uint(n)*wasmPageSize)...)
+	return curLen, true
 }
@@ -0,0 +1,186 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"testing"
+)
+
+var memoryOps = []struct {
+	name  string
+	op    func(*VM)
+	size  int
+	store bool
+}{
+	{"i32.load", (*VM).i32Load, 4, false},
+	{"i64.load", (*VM).i64Load, 8, false},
+	{"f32.load", (*VM).f32Load, 4, false},
+	{"f64.load", (*VM).f64Load, 8, false},
+	{"i32.load8_s", (*VM).i32Load8s, 1, false},
+	{"i32.load8_u", (*VM).i32Load8u, 1, false},
+	{"i32.load16_s", (*VM).i32Load16s, 2, false},
+	{"i32.load16_u", (*VM).i32Load16u, 2, false},
+	{"i64.load8_s", (*VM).i64Load8s, 1, false},
+	{"i64.load8_u", (*VM).i64Load8u, 1, false},
+	{"i64.load16_s", (*VM).i64Load16s, 2, false},
+	{"i64.load16_u", (*VM).i64Load16u, 2, false},
+	{"i64.load32_s", (*VM).i64Load32s, 4, false},
+	{"i64.load32_u", (*VM).i64Load32u, 4, false},
+	{"i32.store", (*VM).i32Store, 4, true},
+	{"i64.store", (*VM).i64Store, 8, true},
+	{"f32.store", (*VM).f32Store, 4, true},
+	{"f64.store", (*VM).f64Store, 8, true},
+	{"i32.store8", (*VM).i32Store8, 1, true},
+	{"i32.store16", (*VM).i32Store16, 2, true},
+	{"i64.store8", (*VM).i64Store8, 1, true},
+	{"i64.store16", (*VM).i64Store16, 2, true},
+	{"i64.store32", (*VM).i64Store32, 4, true},
+}
+
+// runMemoryOp runs op on a VM with a single page of memory, with the
+// given address on the stack and offset as its immediate.
+func runMemoryOp(op func(*VM), store bool, addr, offset uint32) (vm *VM, kind TrapKind) {
+	vm = &VM{memory: &memoryInstance{data: make([]byte, wasmPageSize)}}
+	vm.ctx.code = make([]byte, 4)
+	endianess.PutUint32(vm.ctx.code, offset)
+	vm.pushUint32(addr)
+	if store {
+		vm.pushUint64(math.MaxUint64)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			kind = r.(TrapKind)
+		}
+	}()
+	op(vm)
+	return vm, 0
+}
+
+func TestMemoryBounds(t *testing.T) {
+	for _, tc := range memoryOps {
+		last := uint32(wasmPageSize - tc.size)
+		for _, addr := range []struct {
+			addr, offset uint32
+			trap         bool
+		}{
+			{0, 0, false},
+			{last, 0, false},
+			{0, last, false},
+			{last - 1, 1, false},
+			{last + 1, 0, true},
+			{0, last + 1, true},
+			{last, 1, true},
+			{wasmPageSize, 0, true},
+			{math.MaxUint32, 0, true},
+			{math.MaxUint32, 1, true}, // wraps around to 0 in 32 bits
+			{1, math.MaxUint32, true},
+			{math.MaxUint32, math.MaxUint32, true},
+		} {
+			vm, trap := runMemoryOp(tc.op, tc.store, addr.addr, addr.offset)
+			switch {
+			case addr.trap && trap != TrapOutOfBoundsMemory:
+				t.Errorf("%s %d offset=%d: expected an out of bounds trap, got %v", tc.name, addr.addr, addr.offset, trap)
+			case !addr.trap && trap != 0:
+				t.Errorf("%s %d offset=%d: unexpected trap: %v", tc.name, addr.addr, addr.offset, trap)
+			case !addr.trap && tc.store:
+				ea := int(addr.addr + addr.offset)
+				for i, b := range vm.memory.data {
+					if want := i >= ea && i < ea+tc.size; want != (b == 0xff) {
+						t.Errorf("%s %d offset=%d: unexpected value at address %d: %#x", tc.name, addr.addr, addr.offset, i, b)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMemoryFloatBits(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		store, load func(*VM)
+		bits        uint64
+	}{
+		{"f32", (*VM).f32Store, (*VM).f32Load, f32NaNSignal},
+		{"f64", (*VM).f64Store, (*VM).f64Load, f64NaNSignal},
+	} {
+		vm := &VM{memory: &memoryInstance{data: make([]byte, wasmPageSize)}}
+		vm.ctx.code = make([]byte, 8)
+		vm.pushUint32(8)
+		vm.pushUint64(tc.bits)
+		tc.store(vm)
+		vm.pushUint32(8)
+		tc.load(vm)
+		if got := vm.popUint64(); got != tc.bits {
+			t.Errorf("%s: unexpected value: got=%#x, want=%#x", tc.name, got, tc.bits)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	vm := &VM{memory: &memoryInstance{data: make([]byte, wasmPageSize), maxPages: 2}}
+	mem := vm.Memory()
+
+	if err := mem.Write(8, []byte{1, 0, 0, 0, 0, 0, 0xf0, 0x3f}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadUint32(8); err != nil || v != 1 {
+		t.Errorf("ReadUint32: got=%v, %v, want=1", v, err)
+	}
+	if v, err := mem.ReadFloat64(8); err != nil || v != 1.0000000000000002 {
+		t.Errorf("ReadFloat64: got=%v, %v, want=1.0000000000000002", v, err)
+	}
+	if b, err := mem.Read(10, 2); err != nil || len(b) != 2 || b[0] != 0 {
+		t.Errorf("Read: got=%v, %v", b, err)
+	}
+
+	mem.Write(100, []byte("\x03\x00\x00\x00abc\x00"))
+	if s, err := mem.ReadPrefixedString(100); err != nil || s != "abc" {
+		t.Errorf("ReadPrefixedString: got=%q, %v, want=\"abc\"", s, err)
+	}
+	if s, err := mem.ReadCString(104); err != nil || s != "abc" {
+		t.Errorf("ReadCString: got=%q, %v, want=\"abc\"", s, err)
+	}
+	mem.Write(200, []byte{0xff, 0})
+	mem.Write(300, []byte{0xff, 0xff, 0, 0})
+	if _, err := mem.ReadCString(200); err != ErrInvalidUTF8 {
+		t.Errorf("ReadCString: unexpected error: got=%v, want=%v", err, ErrInvalidUTF8)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   func() error
+		want error
+	}{
+		{"Read", func() error { _, err := mem.Read(wasmPageSize-1, 2); return err }, MemoryAccessError{Offset: wasmPageSize - 1, Size: 2}},
+		{"Write", func() error { return mem.Write(wasmPageSize, []byte{0}) }, MemoryAccessError{Offset: wasmPageSize, Size: 1}},
+		{"ReadUint64", func() error { _, err := mem.ReadUint64(math.MaxUint32); return err }, MemoryAccessError{Offset: math.MaxUint32, Size: 8}},
+		{"ReadCString", func() error { _, err := mem.ReadCString(wasmPageSize); return err }, MemoryAccessError{Offset: wasmPageSize, Size: 1}},
+		{"ReadPrefixedString", func() error { _, err := mem.ReadPrefixedString(300); return err }, MemoryAccessError{Offset: 304, Size: 0xffff}},
+	} {
+		if err := tc.fn(); err != tc.want {
+			t.Errorf("%s: unexpected error: got=%v, want=%v", tc.name, err, tc.want)
+		}
+	}
+
+	mem.Write(wasmPageSize-1, []byte{'a'})
+	if _, err := mem.ReadCString(wasmPageSize - 1); err != ErrUnterminatedString {
+		t.Errorf("ReadCString: unexpected error: got=%v, want=%v", err, ErrUnterminatedString)
+	}
+	if n, err := mem.Grow(1); err != nil || n != 1 {
+		t.Errorf("Grow: got=%v, %v, want=1", n, err)
+	}
+	if mem.Size() != 2 {
+		t.Errorf("Size: got=%d, want=2", mem.Size())
+	}
+	if s, err := mem.ReadCString(wasmPageSize - 1); err != nil || s != "a" {
+		t.Errorf("ReadCString after Grow: got=%q, %v, want=\"a\"", s, err)
+	}
+	if _, err := mem.Grow(1); err != ErrMemoryGrowth {
+		t.Errorf("Grow: unexpected error: got=%v, want=%v", err, ErrMemoryGrowth)
+	}
+}
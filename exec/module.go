@@ -0,0 +1,224 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ErrSegmentOutOfBounds is returned by NewVM when an element or data
+// segment does not fit in its table or linear memory.
+var ErrSegmentOutOfBounds = errors.New("exec: segment does not fit in its table or linear memory")
+
+// CompiledModule is a module whose functions have been compiled, from
+// which VMs can be created. A CompiledModule is never modified once
+// created, and can be used by multiple goroutines simultaneously.
+type CompiledModule struct {
+	module *wasm.Module
+	funcs  []function
+
+	// initial state of the VMs, whose globals and segments are initialized
+	// by NewVM, once their imports are bound
+	memory    *wasm.Memory // nil if the module has no linear memory
+	data      []byte       // content of a linear memory imported from the resolved module
+	tableSize int          // -1 if the module has no table
+}
+
+// Compile compiles the functions of module, which must not be modified
+// afterwards, using the operators of the enabled features.
+func Compile(module *wasm.Module, features wasm.Features) (*CompiledModule, error) {
+	c := &CompiledModule{module: module, tableSize: -1}
+
+	if c.memory = linearMemory(module); c.memory != nil {
+		if module.Memory == nil || len(module.Memory.Entries) == 0 {
+			c.data = module.LinearMemoryIndexSpace[0]
+		}
+	} else if module.Memory != nil && len(module.Memory.Entries) > 1 {
+		return nil, ErrMultipleLinearMemories
+	}
+	if len(module.TableIndexSpace) != 0 {
+		c.tableSize = len(module.TableIndexSpace[0])
+	}
+
+	c.funcs = make([]function, len(module.FunctionIndexSpace))
+	for i, fn := range module.FunctionIndexSpace {
+		if fn.Body == nil && !fn.IsHost() {
+			// imported from a WebAssembly module, bound by NewVM
+			continue
+		}
+		if fn.IsHost() {
+			goFn, err := newGoFunction(i, fn)
+			if err != nil {
+				return nil, err
+			}
+			c.funcs[i] = goFn
+			continue
+		}
+
+		disassembly, err := disasm.Disassemble(fn, module, features)
+		if err != nil {
+			return nil, err
+		}
+
+		totalLocalVars := 0
+		totalLocalVars += len(fn.Sig.ParamTypes)
+		for _, entry := range fn.Body.Locals {
+			totalLocalVars += int(entry.Count)
+		}
+		code, table, offsets := compile.Compile(disassembly.Code)
+		c.funcs[i] = compiledFunction{
+			code:           code,
+			branchTables:   table,
+			offsets:        offsets,
+			maxDepth:       disassembly.MaxDepth,
+			totalLocalVars: totalLocalVars,
+			args:           len(fn.Sig.ParamTypes),
+			returns:        len(fn.Sig.ReturnTypes),
+		}
+	}
+
+	return c, nil
+}
+
+// Module returns the module compiled by c.
+func (c *CompiledModule) Module() *wasm.Module {
+	return c.module
+}
+
+// NewVM creates a new VM executing the compiled module. Each VM is an
+// instance of the module, with its own linear memory, globals, table and
+// stacks, and is initialized like by the package-level NewVM, including
+// the execution of the start function. The linear memory, table and
+// globals imported from the modules bound with Import are the ones of
+// their VMs.
+// NewVM doesn't compile anything, and may be called by multiple goroutines
+// simultaneously.
+func (c *CompiledModule) NewVM(opts ...VMOption) (*VM, error) {
+	vm := VM{maxPages: wasmMaxPages, maxCallDepth: defaultMaxCallDepth}
+	for _, opt := range opts {
+		opt(&vm)
+	}
+
+	vm.module = c.module
+	vm.funcs = c.funcs
+	values := make([]uint64, len(c.module.GlobalIndexSpace))
+	vm.globals = make([]*uint64, len(values))
+	for i := range values {
+		vm.globals[i] = &values[i]
+	}
+	if err := vm.bindImports(); err != nil {
+		return nil, err
+	}
+	// The initializer expressions may read the imported globals, which
+	// come first in the index space.
+	for i, global := range c.module.GlobalIndexSpace {
+		v, _, err := vm.initExpr(global.Init)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	switch mem := c.memory; {
+	case vm.memory != nil:
+		// imported from another VM
+	case mem != nil:
+		maxPages := vm.maxPages
+		if mem.Limits.Flags&0x1 != 0 && mem.Limits.Maximum < maxPages {
+			maxPages = mem.Limits.Maximum
+		}
+		if mem.Limits.Initial > maxPages {
+			return nil, ErrMemoryLimitExceeded
+		}
+		vm.memory = &memoryInstance{
+			data:     make([]byte, uint(mem.Limits.Initial)*wasmPageSize),
+			maxPages: maxPages,
+		}
+		copy(vm.memory.data, c.data)
+	default:
+		vm.memory = &memoryInstance{}
+	}
+
+	if vm.table == nil && c.tableSize >= 0 {
+		vm.table = make([]vmFunction, c.tableSize)
+	}
+	if err := vm.initSegments(); err != nil {
+		return nil, err
+	}
+
+	vm.newFuncTable()
+
+	if c.module.Start != nil {
+		_, err := vm.ExecCode(int64(c.module.Start.Index))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &vm, nil
+}
+
+// initExpr evaluates the initializer expression expr, reading the globals
+// of the VM, and returns the bits of its value along with its type.
+func (vm *VM) initExpr(expr []byte) (uint64, wasm.ValueType, error) {
+	return vm.module.EvalInitExpr(expr, func(index uint32) (uint64, error) {
+		return *vm.globals[index], nil
+	})
+}
+
+// initSegments initializes the linear memory and table of the VM, which may
+// be imported from other VMs, with the data and element segments of the
+// VM's module. All the segments are checked to fit before any of them is
+// written.
+func (vm *VM) initSegments() error {
+	module := vm.module
+	var elems, data []int
+	if module.Elements != nil {
+		for _, entry := range module.Elements.Entries {
+			offset, err := vm.segmentOffset(entry.Offset, len(entry.Elems), len(vm.table))
+			if err != nil {
+				return err
+			}
+			elems = append(elems, offset)
+		}
+	}
+	if module.Data != nil {
+		for _, entry := range module.Data.Entries {
+			offset, err := vm.segmentOffset(entry.Offset, len(entry.Data), len(vm.memory.data))
+			if err != nil {
+				return err
+			}
+			data = append(data, offset)
+		}
+	}
+
+	for i, offset := range elems {
+		for j, index := range module.Elements.Entries[i].Elems {
+			vm.table[offset+j] = vmFunction{vm, int64(index)}
+		}
+	}
+	for i, offset := range data {
+		copy(vm.memory.data[offset:], module.Data.Entries[i].Data)
+	}
+	return nil
+}
+
+// segmentOffset returns the offset of a segment of n elements, given by
+// the initializer expression expr, and checks that it fits in a table or
+// linear memory of the given size.
+func (vm *VM) segmentOffset(expr []byte, n, size int) (int, error) {
+	val, typ, err := vm.initExpr(expr)
+	if err != nil {
+		return 0, err
+	}
+	if typ != wasm.ValueTypeI32 || uint64(uint32(val))+uint64(n) > uint64(size) {
+		return 0, ErrSegmentOutOfBounds
+	}
+	return int(uint32(val)), nil
+}
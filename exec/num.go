@@ -34,24 +34,44 @@ func (vm *VM) i32Mul() {
 func (vm *VM) i32DivS() {
 	v2 := vm.popInt32()
 	v1 := vm.popInt32()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
+	if v1 == math.MinInt32 && v2 == -1 {
+		vm.trap(TrapIntegerOverflow)
+	}
 	vm.pushInt32(v1 / v2)
 }
 
 func (vm *VM) i32DivU() {
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
 	vm.pushUint32(v1 / v2)
 }
 
 func (vm *VM) i32RemS() {
 	v2 := vm.popInt32()
 	v1 := vm.popInt32()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
+	if v2 == -1 {
+		// avoid overflowing with math.MinInt32 % -1
+		vm.pushInt32(0)
+		return
+	}
 	vm.pushInt32(v1 % v2)
 }
 
 func (vm *VM) i32RemU() {
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
 	vm.pushUint32(v1 % v2)
 }
 
@@ -76,19 +96,19 @@ func (vm *VM) i32Xor() {
 func (vm *VM) i32Shl() {
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
-	vm.pushUint32(v1 << v2)
+	vm.pushUint32(v1 << (v2 & 31))
 }
 
 func (vm *VM) i32ShrU() {
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
-	vm.pushUint32(v1 >> v2)
+	vm.pushUint32(v1 >> (v2 & 31))
 }
 
 func (vm *VM) i32ShrS() {
 	v2 := vm.popUint32()
 	v1 := vm.popInt32()
-	vm.pushInt32(v1 >> v2)
+	vm.pushInt32(v1 >> (v2 & 31))
 }
 
 func (vm *VM) i32Rotl() {
@@ -103,6 +123,14 @@ func (vm *VM) i32Rotr() {
 	vm.pushUint32(bits.RotateLeft32(v1, -int(v2)))
 }
 
+func (vm *VM) i32Extend8S() {
+	vm.pushInt32(int32(int8(vm.popInt32())))
+}
+
+func (vm *VM) i32Extend16S() {
+	vm.pushInt32(int32(int16(vm.popInt32())))
+}
+
 func (vm *VM) i32LeS() {
 	v2 := vm.popInt32()
 	v1 := vm.popInt32()
@@ -194,24 +222,44 @@ func (vm *VM) i64Mul() {
 func (vm *VM) i64DivS() {
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
+	if v1 == math.MinInt64 && v2 == -1 {
+		vm.trap(TrapIntegerOverflow)
+	}
 	vm.pushInt64(v1 / v2)
 }
 
 func (vm *VM) i64DivU() {
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
 	vm.pushUint64(v1 / v2)
 }
 
 func (vm *VM) i64RemS() {
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
+	if v2 == -1 {
+		// avoid overflowing with math.MinInt64 % -1
+		vm.pushInt64(0)
+		return
+	}
 	vm.pushInt64(v1 % v2)
 }
 
 func (vm *VM) i64RemU() {
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
+	if v2 == 0 {
+		vm.trap(TrapIntegerDivideByZero)
+	}
 	vm.pushUint64(v1 % v2)
 }
 
@@ -230,19 +278,19 @@ func (vm *VM) i64Xor() {
 func (vm *VM) i64Shl() {
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 << v2)
+	vm.pushUint64(v1 << (v2 & 63))
 }
 
 func (vm *VM) i64ShrS() {
 	v2 := vm.popUint64()
 	v1 := vm.popInt64()
-	vm.pushInt64(v1 >> v2)
+	vm.pushInt64(v1 >> (v2 & 63))
 }
 
 func (vm *VM) i64ShrU() {
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 >> v2)
+	vm.pushUint64(v1 >> (v2 & 63))
 }
 
 func (vm *VM) i64Rotl() {
@@ -257,6 +305,18 @@ func (vm *VM) i64Rotr() {
 	vm.pushUint64(bits.RotateLeft64(v1, -int(v2)))
 }
 
+func (vm *VM) i64Extend8S() {
+	vm.pushInt64(int64(int8(vm.popInt64())))
+}
+
+func (vm *VM) i64Extend16S() {
+	vm.pushInt64(int64(int16(vm.popInt64())))
+}
+
+func (vm *VM) i64Extend32S() {
+	vm.pushInt64(int64(int32(vm.popInt64())))
+}
+
 func (vm *VM) i64Eq() {
 	vm.pushBool(vm.popUint64() == vm.popUint64())
 }
@@ -319,12 +379,15 @@ func (vm *VM) i64GeU() {
 
 // float32 operators
 
+// abs, neg and copysign only operate on the sign bit, and preserve
+// the payload of NaN values.
+
 func (vm *VM) f32Abs() {
-	vm.pushFloat32(float32(math.Abs(float64(vm.popFloat32()))))
+	vm.pushUint32(vm.popUint32() &^ f32SignBit)
 }
 
 func (vm *VM) f32Neg() {
-	vm.pushFloat32(-vm.popFloat32())
+	vm.pushUint32(vm.popUint32() ^ f32SignBit)
 }
 
 func (vm *VM) f32Ceil() {
@@ -340,8 +403,7 @@ func (vm *VM) f32Trunc() {
 }
 
 func (vm *VM) f32Nearest() {
-	f := vm.popFloat32()
-	vm.pushFloat32(float32(int32(f + float32(math.Copysign(0.5, float64(f))))))
+	vm.pushFloat32(float32(nearest(float64(vm.popFloat32()))))
 }
 
 func (vm *VM) f32Sqrt() {
@@ -369,15 +431,35 @@ func (vm *VM) f32Div() {
 }
 
 func (vm *VM) f32Min() {
-	vm.pushFloat32(float32(math.Min(float64(vm.popFloat32()), float64(vm.popFloat32()))))
+	v2 := vm.popUint32()
+	v1 := vm.popUint32()
+	switch {
+	case isNaN32(v1):
+		vm.pushUint32(v1 | f32QuietBit)
+	case isNaN32(v2):
+		vm.pushUint32(v2 | f32QuietBit)
+	default:
+		vm.pushFloat32(float32(math.Min(float64(math.Float32frombits(v1)), float64(math.Float32frombits(v2)))))
+	}
 }
 
 func (vm *VM) f32Max() {
-	vm.pushFloat32(float32(math.Max(float64(vm.popFloat32()), float64(vm.popFloat32()))))
+	v2 := vm.popUint32()
+	v1 := vm.popUint32()
+	switch {
+	case isNaN32(v1):
+		vm.pushUint32(v1 | f32QuietBit)
+	case isNaN32(v2):
+		vm.pushUint32(v2 | f32QuietBit)
+	default:
+		vm.pushFloat32(float32(math.Max(float64(math.Float32frombits(v1)), float64(math.Float32frombits(v2)))))
+	}
 }
 
 func (vm *VM) f32Copysign() {
-	vm.pushFloat32(float32(math.Copysign(float64(vm.popFloat32()), float64(vm.popFloat32()))))
+	v2 := vm.popUint32()
+	v1 := vm.popUint32()
+	vm.pushUint32(v1&^f32SignBit | v2&f32SignBit)
 }
 
 func (vm *VM) f32Eq() {
@@ -415,11 +497,11 @@ func (vm *VM) f32Ge() {
 // float64 operators
 
 func (vm *VM) f64Abs() {
-	vm.pushFloat64(math.Abs(vm.popFloat64()))
+	vm.pushUint64(vm.popUint64() &^ f64SignBit)
 }
 
 func (vm *VM) f64Neg() {
-	vm.pushFloat64(-vm.popFloat64())
+	vm.pushUint64(vm.popUint64() ^ f64SignBit)
 }
 
 func (vm *VM) f64Ceil() {
@@ -435,8 +517,7 @@ func (vm *VM) f64Trunc() {
 }
 
 func (vm *VM) f64Nearest() {
-	f := vm.popFloat64()
-	vm.pushFloat64(float64(int64(f + math.Copysign(0.5, f))))
+	vm.pushFloat64(nearest(vm.popFloat64()))
 }
 
 func (vm *VM) f64Sqrt() {
@@ -464,15 +545,35 @@ func (vm *VM) f64Div() {
 }
 
 func (vm *VM) f64Min() {
-	vm.pushFloat64(math.Min(vm.popFloat64(), vm.popFloat64()))
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	switch {
+	case isNaN64(v1):
+		vm.pushUint64(v1 | f64QuietBit)
+	case isNaN64(v2):
+		vm.pushUint64(v2 | f64QuietBit)
+	default:
+		vm.pushFloat64(math.Min(math.Float64frombits(v1), math.Float64frombits(v2)))
+	}
 }
 
 func (vm *VM) f64Max() {
-	vm.pushFloat64(math.Max(vm.popFloat64(), vm.popFloat64()))
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	switch {
+	case isNaN64(v1):
+		vm.pushUint64(v1 | f64QuietBit)
+	case isNaN64(v2):
+		vm.pushUint64(v2 | f64QuietBit)
+	default:
+		vm.pushFloat64(math.Max(math.Float64frombits(v1), math.Float64frombits(v2)))
+	}
 }
 
 func (vm *VM) f64Copysign() {
-	vm.pushFloat64(math.Copysign(vm.popFloat64(), vm.popFloat64()))
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	vm.pushUint64(v1&^f64SignBit | v2&f64SignBit)
 }
 
 func (vm *VM) f64Eq() {
@@ -506,3 +607,35 @@ func (vm *VM) f64Ge() {
 	v1 := vm.popFloat64()
 	vm.pushBool(v1 >= v2)
 }
+
+const (
+	f32SignBit  = 1 << 31
+	f32QuietBit = 1 << 22
+	f32ExpMask  = 0xff << 23
+	f32FracMask = 1<<23 - 1
+	f64SignBit  = 1 << 63
+	f64QuietBit = 1 << 51
+	f64ExpMask  = 0x7ff << 52
+	f64FracMask = 1<<52 - 1
+)
+
+func isNaN32(bits uint32) bool {
+	return bits&f32ExpMask == f32ExpMask && bits&f32FracMask != 0
+}
+
+func isNaN64(bits uint64) bool {
+	return bits&f64ExpMask == f64ExpMask && bits&f64FracMask != 0
+}
+
+// nearest rounds f to the nearest integer, rounding ties to even.
+func nearest(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) || f == 0 {
+		return f
+	}
+	t := math.Trunc(f)
+	if d := math.Abs(f - t); d > 0.5 || (d == 0.5 && math.Mod(t, 2) != 0) {
+		t += math.Copysign(1, f)
+	}
+	// preserve the sign of values rounded to zero
+	return math.Copysign(t, f)
+}
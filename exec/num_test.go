@@ -0,0 +1,145 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"testing"
+)
+
+// runOp pushes args on the stack of a new VM, and runs op. It returns
+// the value on the top of the stack, or the kind of trap caused by op.
+func runOp(op func(*VM), args ...uint64) (res uint64, kind TrapKind) {
+	vm := &VM{}
+	for _, arg := range args {
+		vm.pushUint64(arg)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			kind = r.(TrapKind)
+		}
+	}()
+	op(vm)
+	return vm.popUint64(), 0
+}
+
+func i32(v int32) uint64   { return uint64(uint32(v)) }
+func i64(v int64) uint64   { return uint64(v) }
+func f32(v float32) uint64 { return uint64(math.Float32bits(v)) }
+func f64(v float64) uint64 { return math.Float64bits(v) }
+
+const (
+	f32NaN       = 0x7fc00000
+	f32NegNaN    = 0xffc00000
+	f32NaNSignal = 0x7fa00000 // signaling NaN with a payload
+	f64NaN       = 0x7ff8000000000000
+	f64NegNaN    = 0xfff8000000000000
+	f64NaNSignal = 0x7ff4000000000000 // signaling NaN with a payload
+)
+
+func TestNumericOps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		op   func(*VM)
+		args []uint64
+		want uint64
+		trap TrapKind
+	}{
+		{"i32.div_s", (*VM).i32DivS, []uint64{i32(-7), i32(2)}, i32(-3), 0},
+		{"i32.div_s", (*VM).i32DivS, []uint64{i32(1), i32(0)}, 0, TrapIntegerDivideByZero},
+		{"i32.div_s", (*VM).i32DivS, []uint64{i32(math.MinInt32), i32(-1)}, 0, TrapIntegerOverflow},
+		{"i32.div_u", (*VM).i32DivU, []uint64{i32(-1), i32(2)}, i32(math.MaxInt32), 0},
+		{"i32.div_u", (*VM).i32DivU, []uint64{i32(1), i32(0)}, 0, TrapIntegerDivideByZero},
+		{"i32.rem_s", (*VM).i32RemS, []uint64{i32(-7), i32(2)}, i32(-1), 0},
+		{"i32.rem_s", (*VM).i32RemS, []uint64{i32(math.MinInt32), i32(-1)}, i32(0), 0},
+		{"i32.rem_s", (*VM).i32RemS, []uint64{i32(1), i32(0)}, 0, TrapIntegerDivideByZero},
+		{"i32.rem_u", (*VM).i32RemU, []uint64{i32(1), i32(0)}, 0, TrapIntegerDivideByZero},
+		{"i32.shl", (*VM).i32Shl, []uint64{i32(1), i32(33)}, i32(2), 0},
+		{"i32.shr_s", (*VM).i32ShrS, []uint64{i32(-8), i32(33)}, i32(-4), 0},
+		{"i32.shr_u", (*VM).i32ShrU, []uint64{i32(8), i32(-1)}, i32(0), 0},
+		{"i32.rotl", (*VM).i32Rotl, []uint64{i32(math.MinInt32), i32(33)}, i32(1), 0},
+
+		{"i64.div_s", (*VM).i64DivS, []uint64{i64(math.MinInt64), i64(-1)}, 0, TrapIntegerOverflow},
+		{"i64.div_s", (*VM).i64DivS, []uint64{i64(1), i64(0)}, 0, TrapIntegerDivideByZero},
+		{"i64.div_u", (*VM).i64DivU, []uint64{i64(1), i64(0)}, 0, TrapIntegerDivideByZero},
+		{"i64.rem_s", (*VM).i64RemS, []uint64{i64(math.MinInt64), i64(-1)}, i64(0), 0},
+		{"i64.rem_u", (*VM).i64RemU, []uint64{i64(1), i64(0)}, 0, TrapIntegerDivideByZero},
+		{"i64.shl", (*VM).i64Shl, []uint64{i64(1), i64(65)}, i64(2), 0},
+		{"i64.shr_s", (*VM).i64ShrS, []uint64{i64(-8), i64(65)}, i64(-4), 0},
+
+		{"i32.extend8_s", (*VM).i32Extend8S, []uint64{i32(0x1280)}, i32(-128), 0},
+		{"i32.extend8_s", (*VM).i32Extend8S, []uint64{i32(0x127f)}, i32(0x7f), 0},
+		{"i32.extend16_s", (*VM).i32Extend16S, []uint64{i32(0x18000)}, i32(-32768), 0},
+		{"i64.extend8_s", (*VM).i64Extend8S, []uint64{i64(0x1ff)}, i64(-1), 0},
+		{"i64.extend16_s", (*VM).i64Extend16S, []uint64{i64(0x17fff)}, i64(0x7fff), 0},
+		{"i64.extend32_s", (*VM).i64Extend32S, []uint64{i64(0x180000000)}, i64(math.MinInt32), 0},
+		{"i64.extend32_s", (*VM).i64Extend32S, []uint64{i32(-1)}, i64(-1), 0},
+
+		{"f32.min", (*VM).f32Min, []uint64{f32(0), f32NegNaN}, f32NegNaN, 0},
+		{"f32.min", (*VM).f32Min, []uint64{f32NaNSignal, f32(0)}, f32NaNSignal | f32QuietBit, 0},
+		{"f32.min", (*VM).f32Min, []uint64{f32(0), f32(float32(math.Copysign(0, -1)))}, f32SignBit, 0},
+		{"f32.max", (*VM).f32Max, []uint64{f32(float32(math.Copysign(0, -1))), f32(0)}, 0, 0},
+		{"f32.max", (*VM).f32Max, []uint64{f32(1), f32NaN}, f32NaN, 0},
+		{"f32.copysign", (*VM).f32Copysign, []uint64{f32(1), f32(-2)}, f32(-1), 0},
+		{"f32.copysign", (*VM).f32Copysign, []uint64{f32NaN, f32(-2)}, f32NegNaN, 0},
+		{"f32.abs", (*VM).f32Abs, []uint64{f32NegNaN}, f32NaN, 0},
+		{"f32.neg", (*VM).f32Neg, []uint64{f32NaNSignal}, f32NaNSignal | f32SignBit, 0},
+		{"f32.nearest", (*VM).f32Nearest, []uint64{f32(2.5)}, f32(2), 0},
+		{"f32.nearest", (*VM).f32Nearest, []uint64{f32(3.5)}, f32(4), 0},
+		{"f32.nearest", (*VM).f32Nearest, []uint64{f32(-0.5)}, f32SignBit, 0},
+		{"f32.nearest", (*VM).f32Nearest, []uint64{f32(8388609)}, f32(8388609), 0},
+		{"f32.nearest", (*VM).f32Nearest, []uint64{f32(-4.5)}, f32(-4), 0},
+
+		{"f64.min", (*VM).f64Min, []uint64{f64(-1), f64NaNSignal}, f64NaNSignal | f64QuietBit, 0},
+		{"f64.min", (*VM).f64Min, []uint64{f64(math.Copysign(0, -1)), f64(0)}, f64SignBit, 0},
+		{"f64.max", (*VM).f64Max, []uint64{f64(0), f64(math.Copysign(0, -1))}, 0, 0},
+		{"f64.max", (*VM).f64Max, []uint64{f64NegNaN, f64(1)}, f64NegNaN, 0},
+		{"f64.copysign", (*VM).f64Copysign, []uint64{f64(-1), f64(2)}, f64(1), 0},
+		{"f64.neg", (*VM).f64Neg, []uint64{f64NaN}, f64NegNaN, 0},
+		{"f64.abs", (*VM).f64Abs, []uint64{f64NegNaN}, f64NaN, 0},
+		{"f64.nearest", (*VM).f64Nearest, []uint64{f64(-2.5)}, f64(-2), 0},
+		{"f64.nearest", (*VM).f64Nearest, []uint64{f64(0.5000000000000001)}, f64(1), 0},
+		{"f64.nearest", (*VM).f64Nearest, []uint64{f64(4503599627370497)}, f64(4503599627370497), 0},
+
+		{"i32.trunc_s/f32", (*VM).i32TruncSF32, []uint64{f32(-2147483648)}, i32(math.MinInt32), 0},
+		{"i32.trunc_s/f32", (*VM).i32TruncSF32, []uint64{f32(2147483648)}, 0, TrapIntegerOverflow},
+		{"i32.trunc_s/f32", (*VM).i32TruncSF32, []uint64{f32NaN}, 0, TrapInvalidConversion},
+		{"i32.trunc_u/f32", (*VM).i32TruncUF32, []uint64{f32(-0.9)}, 0, 0},
+		{"i32.trunc_u/f32", (*VM).i32TruncUF32, []uint64{f32(-1)}, 0, TrapIntegerOverflow},
+		{"i32.trunc_u/f32", (*VM).i32TruncUF32, []uint64{f32(4294967040)}, i32(-256), 0},
+		{"i32.trunc_s/f64", (*VM).i32TruncSF64, []uint64{f64(-2147483648.9)}, i32(math.MinInt32), 0},
+		{"i32.trunc_s/f64", (*VM).i32TruncSF64, []uint64{f64(-2147483649)}, 0, TrapIntegerOverflow},
+		{"i32.trunc_u/f64", (*VM).i32TruncUF64, []uint64{f64(4294967295.9)}, i32(-1), 0},
+		{"i32.trunc_u/f64", (*VM).i32TruncUF64, []uint64{f64(math.Inf(1))}, 0, TrapIntegerOverflow},
+		{"i64.trunc_s/f32", (*VM).i64TruncSF32, []uint64{f32(-9223372036854775808)}, i64(math.MinInt64), 0},
+		{"i64.trunc_s/f32", (*VM).i64TruncSF32, []uint64{f32(9223372036854775808)}, 0, TrapIntegerOverflow},
+		{"i64.trunc_u/f32", (*VM).i64TruncUF32, []uint64{f32(18446744073709551616)}, 0, TrapIntegerOverflow},
+		{"i64.trunc_s/f64", (*VM).i64TruncSF64, []uint64{f64(-9223372036854775808)}, i64(math.MinInt64), 0},
+		{"i64.trunc_s/f64", (*VM).i64TruncSF64, []uint64{f64NegNaN}, 0, TrapInvalidConversion},
+		{"i64.trunc_u/f64", (*VM).i64TruncUF64, []uint64{f64(18446744073709549568)}, 18446744073709549568, 0},
+		{"i64.trunc_u/f64", (*VM).i64TruncUF64, []uint64{f64(18446744073709551616)}, 0, TrapIntegerOverflow},
+
+		{"f32.reinterpret/i32", (*VM).f32ReinterpretI32, []uint64{f32NaNSignal}, f32NaNSignal, 0},
+		{"f64.reinterpret/i64", (*VM).f64ReinterpretI64, []uint64{f64NaNSignal}, f64NaNSignal, 0},
+	} {
+		got, trap := runOp(tc.op, tc.args...)
+		if trap != tc.trap {
+			t.Errorf("%s %#x: unexpected trap: got=%v, want=%v", tc.name, tc.args, trap, tc.trap)
+			continue
+		}
+		if tc.trap != 0 {
+			continue
+		}
+
+		// i32 and f32 results may be sign extended on the stack.
+		if tc.want <= math.MaxUint32 && (tc.name[:3] == "i32" || tc.name[:3] == "f32") {
+			got = uint64(uint32(got))
+		}
+		if got != tc.want {
+			t.Errorf("%s %#x: unexpected result: got=%#x, want=%#x", tc.name, tc.args, got, tc.want)
+		}
+	}
+}
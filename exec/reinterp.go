@@ -4,26 +4,23 @@
 
 package exec
 
-import (
-	"math"
-)
-
-// these operations are essentially no-ops.
+// these operations are essentially no-ops, they only operate on the
+// bits of the values, so that NaN payloads are preserved.
 // TODO(vibhavp): Add optimisations to package compiles that
 // removes them from the original bytecode.
 
 func (vm *VM) i32ReinterpretF32() {
-	vm.pushUint32(math.Float32bits(vm.popFloat32()))
+	vm.pushUint32(vm.popUint32())
 }
 
 func (vm *VM) i64ReinterpretF64() {
-	vm.pushUint64(math.Float64bits(vm.popFloat64()))
+	vm.pushUint64(vm.popUint64())
 }
 
 func (vm *VM) f32ReinterpretI32() {
-	vm.pushFloat32(math.Float32frombits(vm.popUint32()))
+	vm.pushUint32(vm.popUint32())
 }
 
 func (vm *VM) f64ReinterpretI64() {
-	vm.pushFloat64(math.Float64frombits(vm.popUint64()))
+	vm.pushUint64(vm.popUint64())
 }
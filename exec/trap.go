@@ -0,0 +1,106 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "fmt"
+
+// TrapKind describes the cause of a trap.
+type TrapKind int
+
+const (
+	// TrapUnreachable is caused by the execution of an unreachable operator.
+	TrapUnreachable TrapKind = iota + 1
+	// TrapOutOfBoundsMemory is caused by a memory access outside the
+	// bounds of the linear memory.
+	TrapOutOfBoundsMemory
+	// TrapIntegerOverflow is caused by an integer operation whose result
+	// is not representable, like a signed division of the minimum integer
+	// by -1.
+	TrapIntegerOverflow
+	// TrapIntegerDivideByZero is caused by an integer division or
+	// remainder by zero.
+	TrapIntegerDivideByZero
+	// TrapInvalidConversion is caused by the truncation of a NaN, or of a
+	// float not representable by the target integer type.
+	TrapInvalidConversion
+	// TrapIndirectCallMismatch is caused by a call_indirect operation whose
+	// expected signature doesn't match the one of the table element.
+	TrapIndirectCallMismatch
+	// TrapUndefinedElement is caused by a call_indirect operation on an
+	// undefined table element.
+	TrapUndefinedElement
+	// TrapStackExhausted is caused by the exhaustion of the call stack.
+	TrapStackExhausted
+	// TrapOutOfGas is caused by the exhaustion of the gas budget of the VM
+	// (see GasMetering).
+	TrapOutOfGas
+)
+
+var trapKindStrs = map[TrapKind]string{
+	TrapUnreachable:          "unreachable executed",
+	TrapOutOfBoundsMemory:    "out of bounds memory access",
+	TrapIntegerOverflow:      "integer overflow",
+	TrapIntegerDivideByZero:  "integer divide by zero",
+	TrapInvalidConversion:    "invalid conversion to integer",
+	TrapIndirectCallMismatch: "indirect call signature mismatch",
+	TrapUndefinedElement:     "undefined table element",
+	TrapStackExhausted:       "call stack exhausted",
+	TrapOutOfGas:             "out of gas",
+}
+
+func (k TrapKind) String() string {
+	str, ok := trapKindStrs[k]
+	if !ok {
+		str = fmt.Sprintf("<unknown trap %d>", int(k))
+	}
+	return str
+}
+
+// Trap is the error returned by (*VM).ExecCode when the execution of
+// WebAssembly code traps. Once ExecCode returns, the VM can be used again.
+type Trap struct {
+	Kind         TrapKind
+	Function     int64  // Index into the function index space of the function that trapped
+	FunctionName string // Name of the function that trapped in the module's name section, if any
+	Offset       int64  // Byte offset of the trapping operator in the function's bytecode, -1 if unknown
+}
+
+func (t Trap) Error() string {
+	fn := fmt.Sprint(t.Function)
+	if t.FunctionName != "" {
+		fn += fmt.Sprintf(" (%s)", t.FunctionName)
+	}
+	return fmt.Sprintf("exec: trap in function %s at offset %d: %v", fn, t.Offset, t.Kind)
+}
+
+// trap aborts the execution of the current function.
+func (vm *VM) trap(kind TrapKind) {
+	panic(kind)
+}
+
+// recoverTrap converts the value r recovered from a panic while executing
+// code into a Trap, and resets the execution context of the VM.
+// It returns false if r wasn't caused by a trap.
+func (vm *VM) recoverTrap(r interface{}) (Trap, bool) {
+	kind, ok := r.(TrapKind)
+	if !ok {
+		return Trap{}, false
+	}
+
+	t := Trap{
+		Kind:     kind,
+		Function: vm.ctx.curFunc,
+		Offset:   -1,
+	}
+	if int(t.Function) < len(vm.funcs) {
+		if fn, ok := vm.funcs[t.Function].(compiledFunction); ok {
+			t.Offset = fn.offsets.Lookup(vm.ctx.pc - 1)
+		}
+		t.FunctionName = vm.module.FunctionIndexSpace[t.Function].Name
+	}
+
+	vm.ctx = execContext{}
+	return t, true
+}
@@ -16,16 +16,16 @@ func (vm *VM) setLocal() {
 
 func (vm *VM) teeLocal() {
 	index := vm.fetchUint32()
-	val := vm.popUint64()
+	val := vm.ctx.stack[len(vm.ctx.stack)-1]
 	vm.ctx.locals[int(index)] = val
 }
 
 func (vm *VM) getGlobal() {
 	index := vm.fetchUint32()
-	vm.pushUint64(vm.globals[int(index)])
+	vm.pushUint64(*vm.globals[int(index)])
 }
 
 func (vm *VM) setGlobal() {
 	index := vm.fetchUint32()
-	vm.globals[int(index)] = vm.popUint64()
+	*vm.globals[int(index)] = vm.popUint64()
 }
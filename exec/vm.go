@@ -6,24 +6,27 @@
 package exec
 
 import (
+	gocontext "context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 
-	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/exec/internal/compile"
 	"github.com/go-interpreter/wagon/wasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 var (
-	// ErrMultipleLinearMemories is returned by (*VM).NewVM when the module
+	// ErrMultipleLinearMemories is returned by Compile and NewVM when the module
 	// has more then one entries in the linear memory space.
 	ErrMultipleLinearMemories = errors.New("exec: more than one linear memories in module")
 	// ErrInvalidArgumentCount is returned by (*VM).ExecCode when an invalid
 	// number of arguments to the WebAssembly function are passed to it.
 	ErrInvalidArgumentCount = errors.New("exec: invalid number of arguments to function")
+	// ErrMemoryLimitExceeded is returned by NewVM when the initial size of
+	// the module's linear memory exceeds its maximum size.
+	ErrMemoryLimitExceeded = errors.New("exec: initial linear memory size exceeds the memory limit")
 )
 
 // InvalidReturnTypeError is returned by (*VM).ExecCode when the module
@@ -42,7 +45,7 @@ func (e InvalidFunctionIndexError) Error() string {
 	return fmt.Sprintf("Invalid index to function index space: %d", int64(e))
 }
 
-type context struct {
+type execContext struct {
 	stack   []uint64
 	locals  []uint64
 	code    []byte
@@ -52,69 +55,113 @@ type context struct {
 
 // VM is the execution context for executing WebAssembly bytecode.
 type VM struct {
-	ctx context
+	ctx execContext
 
-	module        *wasm.Module
-	globals       []uint64
-	memory        []byte
-	compiledFuncs []compiledFunction
+	module  *wasm.Module
+	globals []*uint64 // shared with the VMs importing them
+	memory  *memoryInstance
+	table   []vmFunction   // nil if the module has no table
+	funcs   []function     // shared with the CompiledModule of the VM, unless it has imports bound to other VMs
+	imports map[string]*VM // VMs bound with Import, by module name
 
-	funcTable [256]func()
+	maxPages uint32 // maximum size of the linear memory created by the VM, in pages
+
+	callDepth    int // number of frames of WebAssembly functions being executed
+	maxCallDepth int
+	stackSize    int // number of values in the stacks and locals of the frames
+	maxStackSize int // 0 if unlimited
+
+	done <-chan struct{} // closed when the current execution is canceled
+
+	gas      *GasCosts // nil if the execution isn't metered
+	gasLimit uint64
+	gasUsed  uint64 // by the current or last call to ExecCode
+
+	funcTable     [256]func()
+	miscFuncTable [256]func() // the operators prefixed by ops.MiscPrefix
 }
 
 // As per the WebAssembly spec: https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/Semantics.md#linear-memory
 const wasmPageSize = 65536 // (64 KB)
 
-var endianess = binary.LittleEndian
+// The maximum number of pages of a linear memory, which is the size of the
+// 32-bit address space.
+const wasmMaxPages = 65536
 
-// NewVM creates a new VM from a given module. If the module defines a
-// start function, it will be executed.
-func NewVM(module *wasm.Module) (*VM, error) {
-	var vm VM
+// VMOption configures a VM created by NewVM.
+type VMOption func(*VM)
 
-	if module.Memory != nil && len(module.Memory.Entries) != 0 {
-		if len(module.Memory.Entries) > 1 {
-			return nil, ErrMultipleLinearMemories
-		}
-		vm.memory = make([]byte, uint(module.Memory.Entries[0].Limits.Initial)*wasmPageSize)
-		copy(vm.memory, module.LinearMemoryIndexSpace[0])
+// The default maximum number of nested calls of WebAssembly functions. Each
+// call also uses the Go stack, which must not overflow.
+const defaultMaxCallDepth = 16384
+
+// MaxCallDepth limits the number of nested calls of WebAssembly functions
+// to n. Calls beyond this limit trap with TrapStackExhausted. The default
+// limit is 16384.
+func MaxCallDepth(n int) VMOption {
+	return func(vm *VM) {
+		vm.maxCallDepth = n
 	}
+}
 
-	vm.compiledFuncs = make([]compiledFunction, len(module.FunctionIndexSpace))
-	vm.globals = make([]uint64, len(module.GlobalIndexSpace))
-	vm.newFuncTable()
-	vm.module = module
+// MaxStackSize limits the total number of values in the operand stacks
+// and local variables of the functions being executed to n, beyond which
+// calls trap with TrapStackExhausted. By default, only the call depth is
+// limited (see MaxCallDepth).
+func MaxStackSize(n int) VMOption {
+	return func(vm *VM) {
+		vm.maxStackSize = n
+	}
+}
 
-	for i, fn := range module.FunctionIndexSpace {
-		disassembly, err := disasm.Disassemble(fn, module)
-		if err != nil {
-			return nil, err
+// MaxMemoryPages limits the size of the VM's linear memory to n pages,
+// regardless of the maximum size declared by the module. grow_memory
+// fails when growing the memory beyond this limit.
+func MaxMemoryPages(n uint32) VMOption {
+	return func(vm *VM) {
+		if n < vm.maxPages {
+			vm.maxPages = n
 		}
+	}
+}
 
-		totalLocalVars := 0
-		totalLocalVars += len(fn.Sig.ParamTypes)
-		for _, entry := range fn.Body.Locals {
-			totalLocalVars += int(entry.Count)
-		}
-		code, table := compile.Compile(disassembly.Code)
-		vm.compiledFuncs[i] = compiledFunction{
-			code:           code,
-			branchTables:   table,
-			maxDepth:       disassembly.MaxDepth,
-			totalLocalVars: totalLocalVars,
-			args:           len(fn.Sig.ParamTypes),
-			returns:        len(fn.Sig.ReturnTypes) != 0,
-		}
+var endianess = binary.LittleEndian
+
+// NewVM creates a new VM from a given module. If the module defines a
+// start function, it will be executed.
+// Imported host functions (see wasm.Function.Host) are called by the VM
+// like any other function, and must have a Go type matching their
+// signature: each i32, i64, f32 and f64 parameter or return value
+// corresponds to an (u)int32, (u)int64, float32 and float64 value respectively.
+// A host function may also take the calling *VM as its first parameter,
+// to access its linear memory (see (*VM).Memory).
+//
+// NewVM compiles the module with the given features each time it is
+// called: use Compile and (*CompiledModule).NewVM to create several VMs
+// for the same module.
+func NewVM(module *wasm.Module, features wasm.Features, opts ...VMOption) (*VM, error) {
+	compiled, err := Compile(module, features)
+	if err != nil {
+		return nil, err
 	}
+	return compiled.NewVM(opts...)
+}
 
-	if module.Start != nil {
-		_, err := vm.ExecCode(int64(module.Start.Index))
-		if err != nil {
-			return nil, err
+// linearMemory returns the description of the module's linear memory,
+// which is either imported or declared in its memory section. It returns
+// nil if the module has no (or more than one) linear memory.
+func linearMemory(module *wasm.Module) *wasm.Memory {
+	if module.Import != nil {
+		for _, entry := range module.Import.Entries {
+			if imp, ok := entry.Type.(wasm.MemoryImport); ok {
+				return &imp.Type
+			}
 		}
 	}
-
-	return &vm, nil
+	if module.Memory != nil && len(module.Memory.Entries) == 1 {
+		return &module.Memory.Entries[0]
+	}
+	return nil
 }
 
 func (vm *VM) pushBool(v bool) {
@@ -216,56 +263,143 @@ func (vm *VM) pushFloat32(f float32) {
 // ExecCode calls the function with the given index and arguments.
 // fnIndex should be a valid index into the function index space of
 // the VM's module.
+// The result of the function is returned as a uint32, uint64, float32 or
+// float64 value depending on its type, or nil if the function returns no
+// value. A function with multiple results returns them in a []interface{}.
+// If the execution traps, the returned error is a Trap. If the VM meters its
+// execution (see GasMetering), it traps with TrapOutOfGas when its gas
+// budget is exhausted.
 func (vm *VM) ExecCode(fnIndex int64, args ...uint64) (interface{}, error) {
-	if int(fnIndex) > len(vm.compiledFuncs) {
+	return vm.ExecCodeContext(gocontext.Background(), fnIndex, args...)
+}
+
+// ExecCodeContext is like ExecCode, but aborts the execution when ctx is
+// done, returning ctx.Err(). ctx is checked before the execution, and then
+// at each call and backward jump, so that a module can't run longer than
+// the deadline of ctx by more than the execution of a straight sequence of
+// operators.
+// Like after a trap, the changes made to the linear memory and globals
+// before the cancellation are kept, and the VM can be used again.
+func (vm *VM) ExecCodeContext(ctx gocontext.Context, fnIndex int64, args ...uint64) (rtrn interface{}, err error) {
+	if fnIndex < 0 || int(fnIndex) >= len(vm.funcs) {
 		return nil, InvalidFunctionIndexError(fnIndex)
 	}
-	if len(vm.module.GetFunction(int(fnIndex)).Sig.ParamTypes) != len(args) {
+	sig := vm.module.GetFunction(int(fnIndex)).Sig
+	if len(sig.ParamTypes) != len(args) {
 		return nil, ErrInvalidArgumentCount
 	}
-	compiled := vm.compiledFuncs[fnIndex]
-	if len(vm.ctx.stack) < compiled.maxDepth {
-		vm.ctx.stack = make([]uint64, compiled.maxDepth)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	vm.ctx.locals = make([]uint64, compiled.totalLocalVars)
-	vm.ctx.pc = 0
-	vm.ctx.code = compiled.code
 
-	for i, arg := range args {
-		vm.ctx.locals[i] = arg
+	vm.gasUsed = 0
+	vm.callDepth, vm.stackSize = 0, 0
+	vm.done = ctx.Done()
+	defer func() {
+		vm.done = nil
+		if r := recover(); r != nil {
+			if _, ok := r.(canceled); ok {
+				vm.ctx = execContext{}
+				rtrn, err = nil, ctx.Err()
+				return
+			}
+			t, ok := vm.recoverTrap(r)
+			if !ok {
+				panic(r)
+			}
+			rtrn, err = nil, t
+		}
+	}()
+
+	var res []uint64
+	switch fn := vm.funcs[fnIndex].(type) {
+	case compiledFunction:
+		if cap(vm.ctx.stack) < fn.maxDepth {
+			vm.ctx.stack = make([]uint64, 0, fn.maxDepth)
+		}
+		vm.ctx.stack = vm.ctx.stack[:0]
+		vm.ctx.locals = make([]uint64, fn.totalLocalVars)
+		vm.ctx.pc = 0
+		vm.ctx.code = fn.code
+		vm.ctx.curFunc = fnIndex
+		vm.enterFrame(fn)
+
+		for i, arg := range args {
+			vm.ctx.locals[i] = arg
+		}
+
+		res = vm.execCode(fn)
+	case goFunction, vmFunction:
+		vm.ctx.curFunc = fnIndex
+		for _, arg := range args {
+			vm.pushUint64(arg)
+		}
+		fn.call(vm, fnIndex)
+		n := len(vm.ctx.stack) - len(sig.ReturnTypes)
+		res = vm.ctx.stack[n:]
+		vm.ctx.stack = vm.ctx.stack[:n]
 	}
 
-	var rtrn interface{}
-	res := vm.execCode(compiled)
-	if compiled.returns {
-		rtrnType := vm.module.GetFunction(int(fnIndex)).Sig.ReturnTypes[0]
+	rtrns := make([]interface{}, len(sig.ReturnTypes))
+	for i, rtrnType := range sig.ReturnTypes {
 		switch rtrnType {
 		case wasm.ValueTypeI32:
-			rtrn = uint32(res)
+			rtrns[i] = uint32(res[i])
 		case wasm.ValueTypeI64:
-			rtrn = uint64(res)
+			rtrns[i] = uint64(res[i])
 		case wasm.ValueTypeF32:
-			rtrn = math.Float32frombits(uint32(res))
+			rtrns[i] = math.Float32frombits(uint32(res[i]))
 		case wasm.ValueTypeF64:
-			rtrn = math.Float64frombits(res)
+			rtrns[i] = math.Float64frombits(res[i])
 		default:
 			return nil, InvalidReturnTypeError(rtrnType)
 		}
 	}
 
-	return rtrn, nil
+	switch len(rtrns) {
+	case 0:
+		return nil, nil
+	case 1:
+		return rtrns[0], nil
+	}
+	return rtrns, nil
+}
+
+// canceled is the value execCode panics with when the context of the
+// execution is done.
+type canceled struct{}
+
+// checkDone aborts the execution if its context is done.
+func (vm *VM) checkDone() {
+	if vm.done == nil {
+		return
+	}
+	select {
+	case <-vm.done:
+		panic(canceled{})
+	default:
+	}
 }
 
-func (vm *VM) execCode(compiled compiledFunction) uint64 {
+// execCode executes the compiled function in the current execution context,
+// and returns its results, which stay on the top of the stack.
+func (vm *VM) execCode(compiled compiledFunction) []uint64 {
 outer:
 	for int(vm.ctx.pc) < len(vm.ctx.code) {
 		op := vm.ctx.code[vm.ctx.pc]
 		vm.ctx.pc++
+		if vm.gas != nil {
+			vm.useGas(vm.gas.Ops[op])
+		}
 		switch op {
 		case ops.Return:
 			break outer
 		case compile.OpJmp:
-			vm.ctx.pc = vm.fetchInt64()
+			target := vm.fetchInt64()
+			if target < vm.ctx.pc {
+				vm.checkDone()
+			}
+			vm.ctx.pc = target
 			continue
 		case compile.OpJmpZ:
 			target := vm.fetchInt64()
@@ -275,40 +409,32 @@ outer:
 			}
 		case compile.OpJmpNz:
 			target := vm.fetchInt64()
-			preserveTop := vm.fetchBool()
+			preserve := vm.fetchInt64()
 			discard := vm.fetchInt64()
 			if vm.popUint32() != 0 {
-				vm.ctx.pc = target
-				var top uint64
-				if preserveTop {
-					top = vm.ctx.stack[len(vm.ctx.stack)-1]
-				}
-				vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(discard)]
-				if preserveTop {
-					vm.pushUint64(top)
+				if target < vm.ctx.pc {
+					vm.checkDone()
 				}
+				vm.ctx.pc = target
+				vm.discard(int(discard), int(preserve))
 				continue
 			}
 		case ops.BrTable:
 			// println("brtable")
 			index := vm.fetchInt64()
-			label := vm.popInt32()
-			table := vm.compiledFuncs[vm.ctx.curFunc].branchTables[index]
+			label := vm.popUint32()
+			table := compiled.branchTables[index]
 			var target compile.Target
-			if label < int32(len(table.Targets)) {
-				target = table.Targets[int32(label)]
+			if label < uint32(len(table.Targets)) {
+				target = table.Targets[label]
 			} else {
 				target = table.DefaultTarget
 			}
-			vm.ctx.pc = target.Addr
-			var top uint64
-			if target.PreserveTop {
-				top = vm.ctx.stack[len(vm.ctx.stack)-1]
-			}
-			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(target.Discard)]
-			if target.PreserveTop {
-				vm.pushUint64(top)
+			if target.Addr < vm.ctx.pc {
+				vm.checkDone()
 			}
+			vm.ctx.pc = target.Addr
+			vm.discard(int(target.Discard), int(target.Preserve))
 			continue
 		case compile.OpDiscard:
 			place := vm.fetchInt64()
@@ -316,15 +442,27 @@ outer:
 		case compile.OpDiscardPreserveTop:
 			top := vm.ctx.stack[len(vm.ctx.stack)-1]
 			place := vm.fetchInt64()
-			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(place)]
+			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-1-int(place)]
 			vm.pushUint64(top)
+		case compile.OpDiscardPreserve:
+			preserve := vm.fetchInt64()
+			place := vm.fetchInt64()
+			vm.discard(int(place), int(preserve))
 		default:
 			vm.funcTable[op]()
 		}
 	}
 
-	if compiled.returns {
-		return vm.ctx.stack[len(vm.ctx.stack)-1]
+	return vm.ctx.stack[len(vm.ctx.stack)-compiled.returns:]
+}
+
+// discard removes n values from the stack, below the preserve values on
+// its top.
+func (vm *VM) discard(n, preserve int) {
+	if n == 0 {
+		return
 	}
-	return 0
+	stack := vm.ctx.stack
+	copy(stack[len(stack)-n-preserve:], stack[len(stack)-preserve:])
+	vm.ctx.stack = stack[:len(stack)-n]
 }
@@ -7,22 +7,95 @@ package validate
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/go-interpreter/wagon/wasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
+// Error is returned when the body of a function is invalid. It locates
+// the offending operator, and the operand stack it was validated against.
 type Error struct {
 	Offset   int // Byte offset in the bytecode vector where the error occurs.
 	Function int // Index into the function index space for the offending function.
 	Err      error
+
+	Name         string // Name of the function in the module's name section, if any.
+	ModuleOffset int64  // Byte offset from the start of the module's binary where the error occurs, or 0 if unknown.
+	Op           string // Name of the offending operator.
+
+	// Want holds the types of the operands the operator expected, and
+	// Stack the types of the operands of the current block when the
+	// operator was reached. The topmost operand is the last one. The
+	// operands of unknown type (0) in Want match any operand.
+	Want  []wasm.ValueType
+	Stack []wasm.ValueType
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("error while validating function %d at offset %d: %v", e.Offset, e.Function, e.Err)
+	fn := fmt.Sprintf("function %d", e.Function)
+	if e.Name != "" {
+		fn = fmt.Sprintf("function %d (%s)", e.Function, e.Name)
+	}
+	if e.Op == "" {
+		return fmt.Sprintf("error while validating %s at offset %d: %v", fn, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("error while validating %s at offset %d: %s: %v", fn, e.Offset, e.Op, e.Err)
 }
 
-var ErrStackUnderflow = errors.New("validate: stack underflow")
+// Errors is the list of errors returned by VerifyModuleDiagnostics.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var (
+	ErrStackUnderflow  = errors.New("validate: stack underflow")
+	ErrUnbalancedStack = errors.New("validate: operands left on the stack at the end of a block")
+	ErrBrTableTypes    = errors.New("validate: br_table targets take different operands")
+)
+
+var (
+	ErrMultipleTables      = errors.New("validate: multiple tables in module")
+	ErrMultipleMemories    = errors.New("validate: multiple linear memories in module")
+	ErrInitExprNotConstant = errors.New("validate: initializer expression is not a single constant operator")
+)
+
+// InvalidLimitsError is returned when the limits of a table or a linear
+// memory are invalid: the minimum size is above the maximum one, or the
+// sizes of a memory are above 4 GiB.
+type InvalidLimitsError wasm.ResizableLimits
+
+func (e InvalidLimitsError) Error() string {
+	if e.Flags&0x1 != 0 {
+		return fmt.Sprintf("invalid limits: min %d, max %d", e.Initial, e.Maximum)
+	}
+	return fmt.Sprintf("invalid limits: min %d", e.Initial)
+}
+
+// InvalidStartFunctionError is returned when the start function of a
+// module takes parameters or returns values.
+type InvalidStartFunctionError struct {
+	Index uint32           // Index into the function index space of the start function
+	Sig   wasm.FunctionSig // The signature of the start function
+}
+
+func (e InvalidStartFunctionError) Error() string {
+	return fmt.Sprintf("start function %d has signature %v, wanted no parameter and no result", e.Index, e.Sig)
+}
+
+// InvalidInitExprGlobalError is returned when an initializer expression
+// gets a global that is not an immutable imported global.
+type InvalidInitExprGlobalError uint32
+
+func (e InvalidInitExprGlobalError) Error() string {
+	return fmt.Sprintf("initializer expression can't get global %d, which is not an immutable imported global", uint32(e))
+}
 
 type InvalidImmediateError struct {
 	ImmType string
@@ -46,6 +119,24 @@ func (e InvalidLabelError) Error() string {
 	return fmt.Sprintf("invalid nesting depth %d", uint32(e))
 }
 
+// ImmutableGlobalError is returned when set_global sets an immutable global.
+type ImmutableGlobalError uint32
+
+func (e ImmutableGlobalError) Error() string {
+	return fmt.Sprintf("global %d is immutable", uint32(e))
+}
+
+// InvalidAlignmentError is returned when the alignment of a memory operator
+// is larger than the natural alignment of the operator.
+type InvalidAlignmentError struct {
+	OpName string
+	Align  uint32 // The log2 of the alignment
+}
+
+func (e InvalidAlignmentError) Error() string {
+	return fmt.Sprintf("invalid alignment 2**%d for op %s", e.Align, e.OpName)
+}
+
 type InvalidLocalIndexError uint32
 
 func (e InvalidLocalIndexError) Error() string {
@@ -58,7 +149,7 @@ type InvalidTypeError struct {
 }
 
 func (e InvalidTypeError) Error() string {
-	return fmt.Sprintf("invalid type, got: %v, wanted: %v", e.Got, e.Got)
+	return fmt.Sprintf("invalid type, got: %v, wanted: %v", e.Got, e.Wanted)
 }
 
 type InvalidElementIndexError uint32
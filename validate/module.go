@@ -0,0 +1,344 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package validate
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// maxPages is the maximum size of a linear memory, in pages of 64 KiB.
+const maxPages = 65536
+
+// imports counts the tables and linear memories imported by a module, and
+// records the types of its imported globals, which all come first in their
+// index spaces.
+type imports struct {
+	tables, memories int
+	globals          []wasm.GlobalVar
+}
+
+func countImports(module *wasm.Module) imports {
+	var n imports
+	if module.Import == nil {
+		return n
+	}
+	for _, entry := range module.Import.Entries {
+		switch entry.Kind {
+		case wasm.ExternalTable:
+			n.tables++
+		case wasm.ExternalMemory:
+			n.memories++
+		case wasm.ExternalGlobal:
+			if imp, ok := entry.Type.(wasm.GlobalVarImport); ok {
+				n.globals = append(n.globals, imp.Type)
+			}
+		}
+	}
+	return n
+}
+
+// verifyTypes checks that the function types of module have at most one
+// result, unless multi-value is enabled.
+func verifyTypes(module *wasm.Module, _ imports, features wasm.Features) error {
+	if module.Types == nil {
+		return nil
+	}
+	for _, sig := range module.Types.Entries {
+		if len(sig.ReturnTypes) > 1 {
+			return features.Check(wasm.FeatureMultiValue)
+		}
+	}
+	return nil
+}
+
+// verifyImports checks the types of the entities imported by module.
+func verifyImports(module *wasm.Module, _ imports, features wasm.Features) error {
+	if module.Import == nil {
+		return nil
+	}
+	for _, entry := range module.Import.Entries {
+		switch typ := entry.Type.(type) {
+		case wasm.FuncImport:
+			if module.Types == nil || int(typ.Type) >= len(module.Types.Entries) {
+				return wasm.InvalidTypeIndexError(typ.Type)
+			}
+		case wasm.TableImport:
+			if err := verifyLimits(typ.Type.Limits, 0); err != nil {
+				return err
+			}
+		case wasm.MemoryImport:
+			if err := verifyLimits(typ.Type.Limits, maxPages); err != nil {
+				return err
+			}
+		case wasm.GlobalVarImport:
+			if typ.Type.Mutable {
+				if err := features.Check(wasm.FeatureMutableGlobals); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// verifyFunctions checks the type indices of the functions declared by
+// module.
+func verifyFunctions(module *wasm.Module, _ imports, _ wasm.Features) error {
+	if module.Function == nil {
+		return nil
+	}
+	for _, index := range module.Function.Types {
+		if module.Types == nil || int(index) >= len(module.Types.Entries) {
+			return wasm.InvalidTypeIndexError(index)
+		}
+	}
+	return nil
+}
+
+// verifyLimits checks that the minimum of limits is not above its
+// maximum, and that both are not above max if it isn't 0.
+func verifyLimits(limits wasm.ResizableLimits, max uint32) error {
+	hasMax := limits.Flags&0x1 != 0
+	switch {
+	case hasMax && limits.Initial > limits.Maximum,
+		max != 0 && limits.Initial > max,
+		max != 0 && hasMax && limits.Maximum > max:
+		return InvalidLimitsError(limits)
+	}
+	return nil
+}
+
+// verifyTables checks that module has at most one table, with valid limits.
+func verifyTables(module *wasm.Module, imported imports, _ wasm.Features) error {
+	n := imported.tables
+	if module.Table != nil {
+		n += len(module.Table.Entries)
+		for _, table := range module.Table.Entries {
+			if err := verifyLimits(table.Limits, 0); err != nil {
+				return err
+			}
+		}
+	}
+	if n > 1 {
+		return ErrMultipleTables
+	}
+	return nil
+}
+
+// verifyMemories checks that module has at most one linear memory, with
+// valid limits.
+func verifyMemories(module *wasm.Module, imported imports, _ wasm.Features) error {
+	n := imported.memories
+	if module.Memory != nil {
+		n += len(module.Memory.Entries)
+		for _, mem := range module.Memory.Entries {
+			if err := verifyLimits(mem.Limits, maxPages); err != nil {
+				return err
+			}
+		}
+	}
+	if n > 1 {
+		return ErrMultipleMemories
+	}
+	return nil
+}
+
+// verifyGlobals checks that the initializers of the globals declared by
+// module are constant expressions of the type of the globals.
+func verifyGlobals(module *wasm.Module, imported imports, _ wasm.Features) error {
+	if module.Global == nil {
+		return nil
+	}
+	for _, global := range module.Global.Globals {
+		if err := verifyInitExpr(module, imported, global.Init, global.Type.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyExports checks that the names of the exports of module are unique,
+// and that they refer to existing entities. Mutable globals can only be
+// exported if their feature is enabled.
+func verifyExports(module *wasm.Module, imported imports, features wasm.Features) error {
+	if module.Export == nil {
+		return nil
+	}
+
+	names := make(map[string]bool, len(module.Export.Entries))
+	for _, name := range module.Export.Names {
+		if names[name] {
+			return wasm.DuplicateExportError(name)
+		}
+		names[name] = true
+	}
+
+	for _, entry := range module.Export.Entries {
+		index := entry.Index
+		switch entry.Kind {
+		case wasm.ExternalFunction:
+			if module.GetFunction(int(index)) == nil {
+				return wasm.InvalidFunctionIndexError(index)
+			}
+		case wasm.ExternalTable:
+			if int(index) >= imported.tables+len(tableEntries(module)) {
+				return wasm.InvalidTableIndexError(index)
+			}
+		case wasm.ExternalMemory:
+			if int(index) >= imported.memories+len(memoryEntries(module)) {
+				return wasm.InvalidLinearMemoryIndexError(index)
+			}
+		case wasm.ExternalGlobal:
+			global := module.GetGlobal(int(index))
+			if global == nil {
+				return wasm.InvalidGlobalIndexError(index)
+			}
+			if global.Type.Mutable {
+				if err := features.Check(wasm.FeatureMutableGlobals); err != nil {
+					return err
+				}
+			}
+		default:
+			return wasm.InvalidExternalError(entry.Kind)
+		}
+	}
+	return nil
+}
+
+func tableEntries(module *wasm.Module) []wasm.Table {
+	if module.Table == nil {
+		return nil
+	}
+	return module.Table.Entries
+}
+
+func memoryEntries(module *wasm.Module) []wasm.Memory {
+	if module.Memory == nil {
+		return nil
+	}
+	return module.Memory.Entries
+}
+
+// verifyStart checks that the start function of module exists, and takes
+// no parameter and returns no value.
+func verifyStart(module *wasm.Module, _ imports, _ wasm.Features) error {
+	if module.Start == nil {
+		return nil
+	}
+	index := module.Start.Index
+	fn := module.GetFunction(int(index))
+	if fn == nil {
+		return wasm.InvalidFunctionIndexError(index)
+	}
+	if len(fn.Sig.ParamTypes) != 0 || len(fn.Sig.ReturnTypes) != 0 {
+		return InvalidStartFunctionError{Index: index, Sig: *fn.Sig}
+	}
+	return nil
+}
+
+// verifyElements checks that the element segments of module initialize
+// an existing table with existing functions, at constant i32 offsets.
+func verifyElements(module *wasm.Module, imported imports, _ wasm.Features) error {
+	if module.Elements == nil {
+		return nil
+	}
+	for _, elem := range module.Elements.Entries {
+		if int(elem.Index) >= imported.tables+len(tableEntries(module)) {
+			return wasm.InvalidTableIndexError(elem.Index)
+		}
+		if err := verifyInitExpr(module, imported, elem.Offset, wasm.ValueTypeI32); err != nil {
+			return err
+		}
+		for _, index := range elem.Elems {
+			if module.GetFunction(int(index)) == nil {
+				return wasm.InvalidFunctionIndexError(index)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyData checks that the data segments of module initialize an
+// existing linear memory, at constant i32 offsets.
+func verifyData(module *wasm.Module, imported imports, _ wasm.Features) error {
+	if module.Data == nil {
+		return nil
+	}
+	for _, data := range module.Data.Entries {
+		if int(data.Index) >= imported.memories+len(memoryEntries(module)) {
+			return wasm.InvalidLinearMemoryIndexError(data.Index)
+		}
+		if err := verifyInitExpr(module, imported, data.Offset, wasm.ValueTypeI32); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyInitExpr checks that the initializer expression expr is a constant
+// expression yielding a value of type want: a single constant operator, or
+// a get_global of an immutable imported global, followed by end.
+func verifyInitExpr(module *wasm.Module, imported imports, expr []byte, want wasm.ValueType) error {
+	r := bytes.NewReader(expr)
+	op, err := r.ReadByte()
+	if err == io.EOF || op == ops.End {
+		return wasm.ErrEmptyInitExpr
+	} else if err != nil {
+		return err
+	}
+
+	var got wasm.ValueType
+	switch op {
+	case ops.I32Const:
+		_, err = leb128.ReadVarint32(r)
+		got = wasm.ValueTypeI32
+	case ops.I64Const:
+		_, err = leb128.ReadVarint64(r)
+		got = wasm.ValueTypeI64
+	case ops.F32Const:
+		_, err = r.Seek(4, io.SeekCurrent)
+		got = wasm.ValueTypeF32
+	case ops.F64Const:
+		_, err = r.Seek(8, io.SeekCurrent)
+		got = wasm.ValueTypeF64
+	case ops.GetGlobal:
+		var index uint32
+		index, err = leb128.ReadVarUint32(r)
+		if err != nil {
+			break
+		}
+		// The type of an imported global is the one declared by its
+		// import entry, not the one of the global it was resolved to.
+		if int(index) >= len(imported.globals) {
+			if module.GetGlobal(int(index)) == nil {
+				return wasm.InvalidGlobalIndexError(index)
+			}
+			return InvalidInitExprGlobalError(index)
+		}
+		global := imported.globals[index]
+		if global.Mutable {
+			return InvalidInitExprGlobalError(index)
+		}
+		got = global.Type
+	default:
+		return wasm.InvalidInitExprOpError(op)
+	}
+	if err != nil {
+		return err
+	}
+
+	if op, err := r.ReadByte(); err != nil || op != ops.End || r.Len() != 0 {
+		return ErrInitExprNotConstant
+	}
+	if got != want {
+		return InvalidTypeError{want, got}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package validate_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+func TestInitExprImportedGlobal(t *testing.T) {
+	resolve := func(string) (*wasm.Module, error) {
+		return wast.ReadModule(strings.NewReader(`(module (global (export "g") (mut i32) (i32.const 0)))`), nil, wasm.AllFeatures)
+	}
+	for _, tc := range []struct {
+		src  string
+		want error
+	}{
+		{`(module (global (import "env" "g") (mut i32)) (global i32 (get_global 0)))`, validate.InvalidInitExprGlobalError(0)},
+		{`(module (global (import "env" "g") (mut i32)) (global i32 (i32.const 0)) (global i32 (get_global 1)))`, validate.InvalidInitExprGlobalError(1)},
+		{`(module (global i32 (get_global 1)))`, wasm.InvalidGlobalIndexError(1)},
+	} {
+		m, err := wast.ReadModule(strings.NewReader(tc.src), resolve, wasm.AllFeatures)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		// the checks must only depend on the import entries of the module,
+		// and not on the globals they were resolved to.
+		for i := range m.GlobalIndexSpace {
+			m.GlobalIndexSpace[i].Type = &wasm.GlobalVar{Type: wasm.ValueTypeI32}
+		}
+
+		if err := validate.VerifyModule(m, wasm.AllFeatures); err != tc.want {
+			t.Errorf("%s: unexpected error: got=%v, want=%v", tc.src, err, tc.want)
+		}
+	}
+}
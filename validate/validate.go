@@ -7,23 +7,24 @@ package validate
 
 import (
 	"bytes"
-	"errors"
 	"io"
 
 	"github.com/go-interpreter/wagon/wasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
-// vibhavp: TODO: We do not verify whether blocks don't access for the parent block, do that.
-func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Module) (*mockVM, error) {
+// verifyBody verifies the body of a function with the signature fn, using
+// only the operators of the enabled features.
+func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Module, features wasm.Features) (*mockVM, error) {
 	vm := &mockVM{
-		stack:    []operand{},
-		stackTop: 0,
+		stack: []operand{},
 
 		code:       bytes.NewReader(body.Code),
 		origLength: len(body.Code),
 
-		blocks: []block{},
+		// the function body is the outermost block, whose label is
+		// the target of branches returning from the function.
+		blocks: []block{{op: ops.Block, blockType: wasm.BlockTypeEmpty, sig: *fn}},
 	}
 
 	localVariables := []operand{}
@@ -43,19 +44,35 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 		localVariables = append(localVariables, vars...)
 	}
 
-	lastOpReturn := false
-
 	for {
+		pc := vm.pc()
 		op, err := vm.code.ReadByte()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return vm, err
 		}
+		vm.startOp(op, pc)
 
-		opStruct, err := ops.New(op)
-		if err != nil {
-			return vm, err
+		var opStruct ops.Op
+		if op == ops.MiscPrefix {
+			code, err := vm.fetchVarUint()
+			if err != nil {
+				return vm, err
+			}
+			if err := features.Check(ops.MiscFeature(code)); err != nil {
+				return vm, err
+			}
+			if opStruct, err = ops.NewMisc(code); err != nil {
+				return vm, err
+			}
+		} else {
+			if err := features.Check(ops.Feature(op)); err != nil {
+				return vm, err
+			}
+			if opStruct, err = ops.New(op); err != nil {
+				return vm, err
+			}
 		}
 
 		logger.Printf("PC: %d OP: %s", vm.pc(), opStruct.Name)
@@ -67,66 +84,77 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 		}
 
 		switch op {
+		case ops.Unreachable:
+			vm.setUnreachable()
+
 		case ops.If, ops.Block, ops.Loop:
 			sig, err := vm.fetchVarInt()
 			if err != nil {
 				return vm, err
 			}
 
-			switch wasm.ValueType(sig) {
-			case wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64, wasm.ValueType(wasm.BlockTypeEmpty):
-				vm.pushBlock(op, wasm.BlockType(sig))
-			default:
+			blockType := wasm.BlockType(sig)
+			if _, ok := blockType.TypeIndex(); ok {
+				if err := features.Check(wasm.FeatureMultiValue); err != nil {
+					return vm, err
+				}
+			}
+			blockSig, err := module.BlockSignature(blockType)
+			if err != nil {
 				return vm, InvalidImmediateError{"block_type", opStruct.Name}
 			}
+			// the parameters of the block are moved to its stack.
+			if err := vm.popOperands(blockSig.ParamTypes); err != nil {
+				return vm, err
+			}
+			vm.pushBlock(op, blockType, blockSig)
 
 		case ops.Else:
-			block := vm.topBlock()
-			if block == nil || block.op != ops.If {
+			if top := vm.topBlock(); len(vm.blocks) == 1 || top.op != ops.If {
 				return vm, UnmatchedOpError(op)
 			}
-
-			if block.blockType != wasm.BlockTypeEmpty {
-				if !lastOpReturn {
-					top, under := vm.topOperand()
-					if under || (top.Type != wasm.ValueType(block.blockType)) {
-						return vm, InvalidTypeError{wasm.ValueType(block.blockType), top.Type}
-					}
-				}
-				vm.pushOperand(wasm.ValueType(block.blockType))
+			block, err := vm.popBlock()
+			if err != nil {
+				return vm, err
 			}
-			vm.stackTop = block.stackTop
+			vm.pushBlock(ops.Else, block.blockType, block.sig)
+
 		case ops.End:
-			block := vm.popBlock()
-			if block == nil {
+			if len(vm.blocks) == 1 {
+				// the end of the function body is not part of its code.
 				return vm, UnmatchedOpError(op)
 			}
-
-			if block.blockType != wasm.BlockTypeEmpty {
-				if !lastOpReturn {
-					top, under := vm.topOperand()
-					if under || (!lastOpReturn && top.Type != wasm.ValueType(block.blockType)) {
-						return vm, InvalidTypeError{wasm.ValueType(block.blockType), top.Type}
-					}
-				}
-				vm.stackTop = block.stackTop
-				vm.pushOperand(wasm.ValueType(block.blockType))
+			block, err := vm.popBlock()
+			if err != nil {
+				return vm, err
+			}
+			if block.op == ops.If && !sameTypes(block.sig.ParamTypes, block.sig.ReturnTypes) {
+				// without else, the parameters of the block are its results.
+				return vm, ErrUnbalancedStack
 			}
-			vm.stackTop = block.stackTop
+			vm.pushOperands(block.sig.ReturnTypes)
 
 		case ops.BrIf, ops.Br:
 			depth, err := vm.fetchVarUint()
 			if err != nil {
 				return vm, err
 			}
-			if err = vm.canBranch(int(depth)); err != nil {
+			types, err := vm.labelTypes(depth)
+			if err != nil {
 				return vm, err
 			}
+			if err := vm.popOperands(types); err != nil {
+				return vm, err
+			}
+			if op == ops.Br {
+				vm.setUnreachable()
+			} else {
+				vm.pushOperands(types)
+			}
 
 		case ops.BrTable:
-			operand, under := vm.popOperand()
-			if under || operand.Type != wasm.ValueTypeI32 {
-				return vm, InvalidTypeError{wasm.ValueTypeI32, operand.Type}
+			if _, err := vm.popType(wasm.ValueTypeI32); err != nil {
+				return vm, err
 			}
 			// read table entries
 			targetCount, err := vm.fetchVarUint()
@@ -140,9 +168,6 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				if err != nil {
 					return vm, err
 				}
-				if err = vm.canBranch(int(entry)); err != nil {
-					return vm, err
-				}
 				targetTable = append(targetTable, entry)
 			}
 
@@ -150,25 +175,33 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			if err != nil {
 				return vm, err
 			}
-			if err = vm.canBranch(int(defaultTarget)); err != nil {
+			types, err := vm.labelTypes(defaultTarget)
+			if err != nil {
+				return vm, err
+			}
+			// all the targets must take the same operands.
+			for _, target := range targetTable {
+				targetTypes, err := vm.labelTypes(target)
+				if err != nil {
+					return vm, err
+				}
+				if !sameTypes(targetTypes, types) {
+					return vm, ErrBrTableTypes
+				}
+			}
+			if err := vm.popOperands(types); err != nil {
 				return vm, err
 			}
+			vm.setUnreachable()
 
 		case ops.Return:
-			if len(fn.ReturnTypes) > 1 {
-				panic("not implemented")
-			}
-			if len(fn.ReturnTypes) != 0 {
-				// only single returns supported for now
-				top, under := vm.popOperand()
-				if under || top.Type != fn.ReturnTypes[0] {
-					return vm, InvalidTypeError{fn.ReturnTypes[0], top.Type}
-				}
+			if err := vm.popOperands(fn.ReturnTypes); err != nil {
+				return vm, err
 			}
-			lastOpReturn = true
+			vm.setUnreachable()
 
 		case ops.I32Const:
-			_, err := vm.fetchVarUint()
+			_, err := vm.fetchVarInt()
 			if err != nil {
 				return vm, err
 			}
@@ -201,9 +234,8 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			if op == ops.GetLocal {
 				vm.pushOperand(v.Type)
 			} else { // == set_local or tee_local
-				top, under := vm.popOperand()
-				if under || top.Type != v.Type {
-					return vm, InvalidTypeError{v.Type, top.Type}
+				if _, err := vm.popType(v.Type); err != nil {
+					return vm, err
 				}
 				if op == ops.TeeLocal {
 					vm.pushOperand(v.Type)
@@ -223,29 +255,43 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			if op == ops.GetGlobal {
 				vm.pushOperand(gv.Type.Type)
 			} else {
-				val, under := vm.popOperand()
-				if under || val.Type != gv.Type.Type {
-					return vm, InvalidTypeError{gv.Type.Type, val.Type}
+				if !gv.Type.Mutable {
+					return vm, ImmutableGlobalError(index)
+				}
+				if _, err := vm.popType(gv.Type.Type); err != nil {
+					return vm, err
 				}
 			}
 
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
+			if !hasMemory(module) {
+				return vm, NoSectionError(wasm.SectionIDMemory)
+			}
 			// read memory_immediate
 			// flags
-			_, err := vm.fetchVarUint()
+			align, err := vm.fetchVarUint()
 			if err != nil {
 				return vm, err
 			}
+			if align > naturalAlignment(op) {
+				return vm, InvalidAlignmentError{opStruct.Name, align}
+			}
 			// offset
 			_, err = vm.fetchVarUint()
 			if err != nil {
 				return vm, err
 			}
 		case ops.CurrentMemory, ops.GrowMemory:
-			_, err := vm.fetchVarUint()
+			if !hasMemory(module) {
+				return vm, NoSectionError(wasm.SectionIDMemory)
+			}
+			res, err := vm.fetchVarUint()
 			if err != nil {
 				return vm, err
 			}
+			if res != 0 {
+				return vm, InvalidImmediateError{"reserved", opStruct.Name}
+			}
 
 		case ops.Call:
 			index, err := vm.fetchVarUint()
@@ -259,20 +305,13 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			}
 
 			logger.Printf("Function being called: %v", fn)
-			for index := range fn.Sig.ParamTypes {
-				argType := fn.Sig.ParamTypes[len(fn.Sig.ParamTypes)-index-1]
-				operand, under := vm.popOperand()
-				if under || operand.Type != argType {
-					return vm, InvalidTypeError{argType, operand.Type}
-				}
-			}
-
-			if len(fn.Sig.ReturnTypes) > 0 {
-				vm.pushOperand(fn.Sig.ReturnTypes[0])
+			if err := vm.popOperands(fn.Sig.ParamTypes); err != nil {
+				return vm, err
 			}
+			vm.pushOperands(fn.Sig.ReturnTypes)
 
 		case ops.CallIndirect:
-			if module.Table == nil || len(module.Table.Entries) == 0 {
+			if !hasTable(module) {
 				return vm, NoSectionError(wasm.SectionIDTable)
 			}
 			// The call_indirect process consists of getting two i32 values
@@ -292,79 +331,189 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				return vm, err
 			}
 
-			fnExpect := module.GetFunction(int(index))
-			if fnExpect == nil {
-				return vm, wasm.InvalidFunctionIndexError(index)
+			if module.Types == nil || int(index) >= len(module.Types.Entries) {
+				return vm, wasm.InvalidTypeIndexError(index)
 			}
+			fnExpect := &module.Types.Entries[index]
 
-			if operand, under := vm.popOperand(); under || operand.Type != wasm.ValueTypeI32 {
-				return vm, InvalidTypeError{wasm.ValueTypeI32, operand.Type}
+			// reserved
+			reserved, err := vm.fetchVarUint()
+			if err != nil {
+				return vm, err
 			}
-
-			for index := range fnExpect.Sig.ParamTypes {
-				argType := fnExpect.Sig.ParamTypes[len(fnExpect.Sig.ParamTypes)-index-1]
-				operand, under := vm.popOperand()
-				if under || operand.Type != argType {
-					return vm, InvalidTypeError{argType, operand.Type}
-				}
+			if reserved != 0 {
+				return vm, InvalidImmediateError{"reserved", opStruct.Name}
 			}
 
-			if len(fnExpect.Sig.ReturnTypes) > 0 {
-				vm.pushOperand(fnExpect.Sig.ReturnTypes[0])
+			if _, err := vm.popType(wasm.ValueTypeI32); err != nil {
+				return vm, err
+			}
+			if err := vm.popOperands(fnExpect.ParamTypes); err != nil {
+				return vm, err
 			}
+			vm.pushOperands(fnExpect.ReturnTypes)
 
 		case ops.Drop:
-			if _, under := vm.popOperand(); under {
-				return vm, errors.New("Stack underflow")
+			if _, err := vm.popOperand(); err != nil {
+				return vm, err
 			}
 
 		case ops.Select:
-			operands := make([]operand, 2)
-			c, under := vm.popOperand()
-			if under || c.Type != wasm.ValueTypeI32 {
-				return vm, InvalidTypeError{wasm.ValueTypeI32, c.Type}
+			if _, err := vm.popType(wasm.ValueTypeI32); err != nil {
+				return vm, err
 			}
-
-			for i := 0; i < 2; i++ {
-				operand, under := vm.popOperand()
-				if under {
-					return vm, ErrStackUnderflow
-				}
-				operands[i] = operand
+			// the 2 operands should be of the same type
+			t1, err := vm.popType(unknownType)
+			if err != nil {
+				return vm, err
 			}
-
-			// last 2 popped values should be of the same type
-			if operands[0].Type != operands[1].Type {
-				return vm, InvalidTypeError{operands[1].Type, operands[2].Type}
+			t2, err := vm.popType(t1)
+			if err != nil {
+				return vm, err
 			}
-
-			vm.pushOperand(operands[1].Type)
-		}
-		if op != ops.Return {
-			lastOpReturn = false
+			vm.pushOperand(t2)
 		}
 	}
 
+	// the end of the function body ends its block.
+	vm.startOp(ops.End, vm.pc())
+	if len(vm.blocks) != 1 {
+		return vm, UnmatchedOpError(vm.topBlock().op)
+	}
+	if _, err := vm.popBlock(); err != nil {
+		return vm, err
+	}
+
 	return vm, nil
 }
 
+func sameTypes(a, b []wasm.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasMemory(module *wasm.Module) bool {
+	return (module.Memory != nil && len(module.Memory.Entries) != 0) || countImports(module).memories != 0
+}
+
+func hasTable(module *wasm.Module) bool {
+	return (module.Table != nil && len(module.Table.Entries) != 0) || countImports(module).tables != 0
+}
+
+// naturalAlignment returns the log2 of the number of bytes accessed by the
+// memory operator op, which is the maximum alignment of the operator.
+func naturalAlignment(op byte) uint32 {
+	switch op {
+	case ops.I32Load8s, ops.I32Load8u, ops.I64Load8s, ops.I64Load8u, ops.I32Store8, ops.I64Store8:
+		return 0
+	case ops.I32Load16s, ops.I32Load16u, ops.I64Load16s, ops.I64Load16u, ops.I32Store16, ops.I64Store16:
+		return 1
+	case ops.I64Load, ops.F64Load, ops.I64Store, ops.F64Store:
+		return 3
+	}
+	return 2
+}
+
 // VerifyModule verifies the given module according to WebAssembly verification
-// specs.
-func VerifyModule(module *wasm.Module) error {
-	if module.Function == nil || module.Types == nil || len(module.Types.Entries) == 0 {
-		return nil
+// specs: the imports, tables, linear memories, globals, exports, start
+// function, element and data segments of the module, and the bodies of its
+// functions. It returns the first error found, an Error if a function body
+// is invalid. The operators and encodings of the proposals beyond the MVP
+// are only accepted if their features are enabled.
+func VerifyModule(module *wasm.Module, features wasm.Features) error {
+	if errs := verifyModule(module, features, false); len(errs) != 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// VerifyModuleDiagnostics verifies module like VerifyModule, but returns all
+// the errors found instead of the first one: the first error of each part of
+// the module, and an Error for every invalid function body. It returns nil
+// if the module is valid.
+func VerifyModuleDiagnostics(module *wasm.Module, features wasm.Features) Errors {
+	return verifyModule(module, features, true)
+}
+
+// verifyModule verifies module, stopping at the first error unless all is
+// true.
+func verifyModule(module *wasm.Module, features wasm.Features, all bool) Errors {
+	var errs Errors
+
+	imported := countImports(module)
+	for _, verify := range []func(*wasm.Module, imports, wasm.Features) error{
+		verifyTypes,
+		verifyImports,
+		verifyFunctions,
+		verifyTables,
+		verifyMemories,
+		verifyGlobals,
+		verifyExports,
+		verifyStart,
+		verifyElements,
+		verifyData,
+	} {
+		if err := verify(module, imported, features); err != nil {
+			if errs = append(errs, err); !all {
+				return errs
+			}
+		}
+	}
+
+	if module.Function == nil || len(module.Function.Types) == 0 {
+		return errs
 	}
 	if module.Code == nil {
-		return NoSectionError(wasm.SectionIDCode)
+		return append(errs, NoSectionError(wasm.SectionIDCode))
 	}
 
 	logger.Printf("There are %d functions", len(module.Function.Types))
-	for i, fn := range module.FunctionIndexSpace {
-		if vm, err := verifyBody(fn.Sig, fn.Body, module); err != nil {
-			return Error{vm.pc(), i, err}
+	// the functions declared by the module come after the imported ones
+	// in the function index space.
+	first := len(module.FunctionIndexSpace) - len(module.Function.Types)
+	for i := first; i < len(module.FunctionIndexSpace); i++ {
+		fn := module.FunctionIndexSpace[i]
+		if fn.IsHost() {
+			// host functions have no bytecode to verify.
+			continue
+		}
+		if vm, err := verifyBody(fn.Sig, fn.Body, module, features); err != nil {
+			if errs = append(errs, newError(i, &fn, vm, err)); !all {
+				return errs
+			}
+			continue
 		}
 		logger.Printf("No errors in function %d", i)
 	}
 
-	return nil
+	return errs
+}
+
+// newError returns the Error describing the failure of vm to validate the
+// function at index i of the function index space.
+func newError(i int, fn *wasm.Function, vm *mockVM, err error) Error {
+	e := Error{
+		Offset:   vm.opPC,
+		Function: i,
+		Err:      err,
+		Name:     fn.Name,
+		Want:     vm.want,
+	}
+	if fn.Body.Offset != 0 {
+		e.ModuleOffset = fn.Body.Offset + int64(vm.opPC)
+	}
+	if op, err := ops.New(vm.op); err == nil {
+		e.Op = op.Name
+	}
+	if len(vm.blocks) != 0 {
+		e.Stack = vm.opStack()
+	}
+	return e
 }
@@ -0,0 +1,109 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package validate_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wast"
+)
+
+func TestVerifyModule(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want error // the error of the function body, for an Error
+	}{
+		{`(module (func (param i32) (result i32) (i32.add (get_local 0) (i32.const 1))))`, nil},
+		{`(module (memory 0) (memory 0))`, validate.ErrMultipleMemories},
+		{`(module (table 0 anyfunc) (table 0 anyfunc))`, validate.ErrMultipleTables},
+		{`(module (memory 2 1))`, validate.InvalidLimitsError{Flags: 1, Initial: 2, Maximum: 1}},
+		{`(module (global i32 (i32.const 0)) (func (set_global 0 (i32.const 1))))`, validate.ImmutableGlobalError(0)},
+		{`(module (memory 1) (func (drop (i32.load align=8 (i32.const 0)))))`, validate.InvalidAlignmentError{OpName: "i32.load", Align: 3}},
+		{`(module (func (block (br 2))))`, validate.InvalidLabelError(2)},
+		{`(module (func (result i32) (i64.const 0)))`, validate.InvalidTypeError{Wanted: wasm.ValueTypeI32, Got: wasm.ValueTypeI64}},
+		{`(module (func (drop)))`, validate.ErrStackUnderflow},
+	} {
+		m, err := wast.ReadModule(strings.NewReader(tc.src), nil, wasm.MVP)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		err = validate.VerifyModule(m, wasm.MVP)
+		errs := validate.VerifyModuleDiagnostics(m, wasm.MVP)
+		if len(errs) != 1 || !reflect.DeepEqual(errs[0], err) {
+			if err != nil || errs != nil {
+				t.Errorf("%s: VerifyModuleDiagnostics returned %v, want [%v]", tc.src, errs, err)
+			}
+		}
+		if e, ok := err.(validate.Error); ok {
+			err = e.Err
+		}
+		if !reflect.DeepEqual(err, tc.want) {
+			t.Errorf("%s: unexpected error: got=%v, want=%v", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestVerifyModuleDiagnostics(t *testing.T) {
+	b, err := wast.Assemble([]byte(`(module
+  (memory 0) (memory 0)
+  (func (result i32) (i32.const 0))
+  (func (param i64) (result i32) (i32.add (i32.const 1) (get_local 0)))
+  (func (result i32) (block (i32.const 1)) (i32.const 0)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// name section naming the second function "add"
+	b = append(b, 0, 13, 4, 'n', 'a', 'm', 'e', 1, 6, 1, 1, 3, 'a', 'd', 'd')
+	m, err := wasm.ReadModule(bytes.NewReader(b), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := validate.VerifyModuleDiagnostics(m, wasm.MVP)
+	if len(errs) != 3 {
+		t.Fatalf("got %d errors, want 3: %v", len(errs), errs)
+	}
+	if errs[0] != validate.ErrMultipleMemories {
+		t.Errorf("unexpected module error: %v", errs[0])
+	}
+	if err := validate.VerifyModule(m, wasm.MVP); err != errs[0] {
+		t.Errorf("VerifyModule returned %v, want the first error %v", err, errs[0])
+	}
+
+	for i, want := range []validate.Error{
+		{
+			Offset: 4, Function: 1, Err: validate.InvalidTypeError{Wanted: wasm.ValueTypeI32, Got: wasm.ValueTypeI64},
+			Name: "add", Op: "i32.add",
+			Want:  []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			Stack: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64},
+		},
+		{
+			Offset: 4, Function: 2, Err: validate.ErrUnbalancedStack,
+			Op:    "end",
+			Stack: []wasm.ValueType{wasm.ValueTypeI32},
+		},
+	} {
+		got, ok := errs[i+1].(validate.Error)
+		if !ok {
+			t.Errorf("error %d: got %v, want a validate.Error", i+1, errs[i+1])
+			continue
+		}
+		if got.ModuleOffset == 0 || int(got.ModuleOffset) >= len(b) || b[got.ModuleOffset] != m.FunctionIndexSpace[got.Function].Body.Code[got.Offset] {
+			t.Errorf("error %d: module offset %d doesn't point to the offending operator", i+1, got.ModuleOffset)
+		}
+		got.ModuleOffset = 0
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("error %d:\ngot:  %#v\nwant: %#v", i+1, got, want)
+		}
+	}
+	if want := `error while validating function 1 (add) at offset 4: i32.add: invalid type, got: i64, wanted: i32`; errs[1].Error() != want {
+		t.Errorf("unexpected message:\ngot:  %s\nwant: %s", errs[1], want)
+	}
+}
@@ -14,26 +14,49 @@ import (
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
+// unknownType is the type of the operands of a polymorphic stack, in
+// unreachable code: it matches any value type.
+const unknownType wasm.ValueType = 0
+
 // mockVM is a minimal implementation of a virtual machine to
-// validate WebAssembly code
+// validate WebAssembly code. It implements the validation algorithm
+// of the specification, which tracks the types of the operands and
+// a stack of control frames.
 type mockVM struct {
-	stack      []operand
-	stackTop   int // the top of the operand stack
-	origLength int // the original length of the bytecode stream
+	stack      []operand // the operand stack
+	origLength int       // the original length of the bytecode stream
 
 	code *bytes.Reader
 
-	blocks []block // a stack of encountered blocks
+	blocks []block // the control frames, the function body being the first one
+
+	// the current operator, its pc, and the operands it popped and
+	// expected, for error reporting.
+	op     byte
+	opPC   int
+	popped []wasm.ValueType
+	want   []wasm.ValueType
 }
 
-// a block reprsents an instruction sequence preceeded by a control flow operator
-// it is used to verify that the block signature set by the operator is the correct
-// one when the block ends
+// a block represents an instruction sequence preceded by a control flow operator
+// (or the function body). It is used to verify that the operands of the block
+// match its signature, and that it doesn't access operands of its parent.
 type block struct {
-	pc        int            // the pc where the control flow operator starting the block is located
-	stackTop  int            // stack top when the block started
-	blockType wasm.BlockType // block_type signature of the control operator
-	op        byte           // opcode for the operator starting the new block
+	pc          int              // the pc where the control flow operator starting the block is located
+	stackTop    int              // height of the operand stack when the block started, below its parameters
+	blockType   wasm.BlockType   // block_type signature of the control operator
+	sig         wasm.FunctionSig // the parameters and results of the block
+	op          byte             // opcode for the operator starting the new block
+	unreachable bool             // whether the rest of the block is unreachable, making its stack polymorphic
+}
+
+// labelTypes returns the types of the operands taken by a branch to the
+// label of the block.
+func (b *block) labelTypes() []wasm.ValueType {
+	if b.op == ops.Loop {
+		return b.sig.ParamTypes
+	}
+	return b.sig.ReturnTypes
 }
 
 func (vm *mockVM) fetchVarUint() (uint32, error) {
@@ -66,13 +89,34 @@ func (vm *mockVM) fetchUint64() (uint64, error) {
 	return binary.LittleEndian.Uint64(buf[:]), nil
 }
 
-func (vm *mockVM) pushBlock(op byte, blockType wasm.BlockType) {
+// pushBlock starts a new block, and pushes its parameters, which must have
+// been popped by the caller.
+func (vm *mockVM) pushBlock(op byte, blockType wasm.BlockType, sig wasm.FunctionSig) {
 	logger.Printf("Pushing block %v", blockType)
 	vm.blocks = append(vm.blocks, block{
 		pc:        vm.pc(),
-		stackTop:  vm.stackTop,
+		stackTop:  len(vm.stack),
 		blockType: blockType,
+		sig:       sig,
 		op:        op})
+	vm.pushOperands(sig.ParamTypes)
+}
+
+// popBlock ends the current block, checking that its results are the only
+// operands left on its stack. The results are popped.
+func (vm *mockVM) popBlock() (*block, error) {
+	if len(vm.blocks) == 0 {
+		return nil, UnmatchedOpError(ops.End)
+	}
+	block := *vm.topBlock()
+	if err := vm.popOperands(block.sig.ReturnTypes); err != nil {
+		return nil, err
+	}
+	if len(vm.stack) != block.stackTop {
+		return nil, ErrUnbalancedStack
+	}
+	vm.blocks = vm.blocks[:len(vm.blocks)-1]
+	return &block, nil
 }
 
 // Get a block from it's relative nesting depth
@@ -84,86 +128,129 @@ func (vm *mockVM) getBlockFromDepth(depth int) *block {
 	return &vm.blocks[len(vm.blocks)-1-depth]
 }
 
-// Returns nil if depth is a valid nesting depth value that can be
-// branched to
-func (vm *mockVM) canBranch(depth int) error {
-	block := vm.getBlockFromDepth(depth)
+// labelTypes returns the types of the operands taken by a branch to the
+// block at the given relative depth.
+func (vm *mockVM) labelTypes(depth uint32) ([]wasm.ValueType, error) {
+	block := vm.getBlockFromDepth(int(depth))
 	if block == nil {
-		return InvalidLabelError(uint32(depth))
+		return nil, InvalidLabelError(depth)
 	}
-	if block.blockType != wasm.BlockTypeEmpty {
-		top, under := vm.topOperand()
-		if under || top.Type != wasm.ValueType(block.blockType) {
-			return InvalidTypeError{wasm.ValueType(block.blockType), top.Type}
-		}
-	}
-
-	return nil
+	return block.labelTypes(), nil
 }
 
-// returns nil in case of an underflow
-func (vm *mockVM) popBlock() *block {
+func (vm *mockVM) topBlock() *block {
 	if len(vm.blocks) == 0 {
 		return nil
 	}
 
-	stackTop := len(vm.blocks) - 1
-	block := vm.blocks[stackTop]
-	vm.blocks = append(vm.blocks[:stackTop], vm.blocks[stackTop+1:]...)
+	return &vm.blocks[len(vm.blocks)-1]
+}
 
-	return &block
+// setUnreachable marks the rest of the current block as unreachable,
+// after an unconditional branch. The stack of the block is emptied, and
+// becomes polymorphic.
+func (vm *mockVM) setUnreachable() {
+	block := vm.topBlock()
+	block.unreachable = true
+	vm.stack = vm.stack[:block.stackTop]
 }
 
-func (vm *mockVM) topBlock() *block {
-	if len(vm.blocks) == 0 {
-		return nil
+// popOperand pops an operand off the stack. The operands of the
+// parents of the current block can't be popped. In unreachable code,
+// popping an empty stack yields an operand of unknown type.
+func (vm *mockVM) popOperand() (operand, error) {
+	block := vm.topBlock()
+	if len(vm.stack) == block.stackTop {
+		if block.unreachable {
+			return operand{unknownType}, nil
+		}
+		return operand{}, ErrStackUnderflow
 	}
+	o := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	vm.popped = append(vm.popped, o.Type)
 
-	return &vm.blocks[len(vm.blocks)-1]
+	logger.Printf("Stack after pop is %v. Popped %v", vm.stack, o)
+	return o, nil
 }
 
-func (vm *mockVM) topOperand() (o operand, under bool) {
-	stackTop := vm.stackTop - 1
-	if stackTop == -1 {
-		under = true
-		return
+// popType pops an operand of type t off the stack, and returns its type,
+// which is only unknown if both t and the type of the operand are.
+func (vm *mockVM) popType(t wasm.ValueType) (wasm.ValueType, error) {
+	vm.expect([]wasm.ValueType{t})
+	return vm.popExpected(t)
+}
+
+func (vm *mockVM) popExpected(t wasm.ValueType) (wasm.ValueType, error) {
+	o, err := vm.popOperand()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case o.Type == unknownType:
+		return t, nil
+	case t == unknownType || o.Type == t:
+		return o.Type, nil
+	}
+	return 0, InvalidTypeError{t, o.Type}
+}
+
+// popOperands pops operands of the given types, the last type being the
+// one of the topmost operand.
+func (vm *mockVM) popOperands(types []wasm.ValueType) error {
+	vm.expect(types)
+	for i := len(types) - 1; i >= 0; i-- {
+		if _, err := vm.popExpected(types[i]); err != nil {
+			return err
+		}
 	}
-	o = vm.stack[stackTop]
-	return
+	return nil
 }
 
-func (vm *mockVM) popOperand() (operand, bool) {
-	var o operand
-	stackTop := vm.stackTop - 1
-	if stackTop == -1 {
-		return o, true
+// expect records that the current operator pops operands of the given
+// types, below the ones it has already popped.
+func (vm *mockVM) expect(types []wasm.ValueType) {
+	if len(types) == 0 {
+		return
 	}
-	o = vm.stack[stackTop]
-	vm.stackTop--
+	vm.want = append(append([]wasm.ValueType{}, types...), vm.want...)
+}
 
-	logger.Printf("Stack after pop is %v. Popped %v", vm.stack[:vm.stackTop], o)
-	return o, false
+// startOp starts the validation of the operator op, located at pc.
+func (vm *mockVM) startOp(op byte, pc int) {
+	vm.op, vm.opPC = op, pc
+	vm.popped = vm.popped[:0]
+	vm.want = nil
+}
+
+// opStack returns the types of the operands of the current block before
+// the current operator popped any of them.
+func (vm *mockVM) opStack() []wasm.ValueType {
+	var types []wasm.ValueType
+	for _, o := range vm.stack[vm.topBlock().stackTop:] {
+		types = append(types, o.Type)
+	}
+	for i := len(vm.popped) - 1; i >= 0; i-- {
+		types = append(types, vm.popped[i])
+	}
+	return types
 }
 
 func (vm *mockVM) pushOperand(t wasm.ValueType) {
 	o := operand{t}
-	logger.Printf("Stack top: %d, Len of stack :%d", vm.stackTop, len(vm.stack))
-	if vm.stackTop == len(vm.stack) {
-		vm.stack = append(vm.stack, o)
-	} else {
-		vm.stack[vm.stackTop] = o
-	}
-	vm.stackTop++
+	vm.stack = append(vm.stack, o)
+	logger.Printf("Stack after push is %v. Pushed %v", vm.stack, o)
+}
 
-	logger.Printf("Stack after push is %v. Pushed %v", vm.stack[:vm.stackTop], o)
+func (vm *mockVM) pushOperands(types []wasm.ValueType) {
+	for _, t := range types {
+		vm.pushOperand(t)
+	}
 }
 
 func (vm *mockVM) adjustStack(op ops.Op) error {
-	for _, t := range op.Args {
-		op, under := vm.popOperand()
-		if under || op.Type != t {
-			return InvalidTypeError{t, op.Type}
-		}
+	if err := vm.popOperands(op.Args); err != nil {
+		return err
 	}
 
 	if op.Returns != wasm.ValueType(wasm.BlockTypeEmpty) {
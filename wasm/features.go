@@ -0,0 +1,101 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Features is a set of WebAssembly proposals extending the MVP
+// specification. The operators and encodings added by a proposal are
+// only accepted when its feature is enabled, the use of a disabled
+// feature being reported by a FeatureDisabledError.
+//
+// The sign-extension, saturating float-to-int conversions, multi-value and
+// mutable globals proposals are supported. The operators and encodings of
+// the bulk memory and reference types proposals are only recognized to
+// report their use, by a FeatureDisabledError when they are disabled and
+// by a FeatureUnsupportedError when they are enabled.
+type Features uint32
+
+const (
+	FeatureSignExtension  Features = 1 << iota // sign-extension operators
+	FeatureSaturatingConv                      // non-trapping float-to-int conversions
+	FeatureMultiValue                          // multiple results, and block parameters
+	FeatureBulkMemory                          // bulk memory operators and passive segments
+	FeatureReferenceTypes                      // reference types and table operators
+	FeatureMutableGlobals                      // import and export of mutable globals
+
+	// MVP is the empty set of features, accepting only the modules of the
+	// MVP specification.
+	MVP Features = 0
+
+	// AllFeatures is the set of all the supported features.
+	AllFeatures = FeatureSignExtension | FeatureSaturatingConv | FeatureMultiValue | FeatureMutableGlobals
+)
+
+var featureNames = []struct {
+	f    Features
+	name string
+}{
+	{FeatureSignExtension, "sign-extension"},
+	{FeatureSaturatingConv, "saturating-float-to-int"},
+	{FeatureMultiValue, "multi-value"},
+	{FeatureBulkMemory, "bulk-memory"},
+	{FeatureReferenceTypes, "reference-types"},
+	{FeatureMutableGlobals, "mutable-globals"},
+}
+
+// Has reports whether all the features of g are enabled in f.
+func (f Features) Has(g Features) bool {
+	return f&g == g
+}
+
+func (f Features) String() string {
+	var names []string
+	for _, feature := range featureNames {
+		if f.Has(feature.f) {
+			names = append(names, feature.name)
+			f &^= feature.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("<unknown features %#x>", uint32(f)))
+	}
+	if len(names) == 0 {
+		return "mvp"
+	}
+	return strings.Join(names, "|")
+}
+
+// FeatureDisabledError is returned when a module uses a feature which is
+// not enabled.
+type FeatureDisabledError Features
+
+func (e FeatureDisabledError) Error() string {
+	return fmt.Sprintf("wasm: feature %v is not enabled", Features(e))
+}
+
+// FeatureUnsupportedError is returned when a module uses an enabled
+// feature which is not supported.
+type FeatureUnsupportedError Features
+
+func (e FeatureUnsupportedError) Error() string {
+	return fmt.Sprintf("wasm: feature %v is not supported", Features(e))
+}
+
+// Check returns a FeatureDisabledError if the features of g are not all
+// enabled in f, a FeatureUnsupportedError if some of them are not
+// supported, and nil otherwise.
+func (f Features) Check(g Features) error {
+	if !f.Has(g) {
+		return FeatureDisabledError(g)
+	}
+	if unsupported := g &^ AllFeatures; unsupported != 0 {
+		return FeatureUnsupportedError(unsupported)
+	}
+	return nil
+}
@@ -52,7 +52,6 @@ type GlobalVarImport struct {
 func (GlobalVarImport) isImport() {}
 
 var (
-	ErrImportMutGlobal           = errors.New("wasm: cannot import global mutable variable")
 	ErrNoExportsInImportedModule = errors.New("wasm: imported module has no exports")
 )
 
@@ -85,7 +84,46 @@ func (e ExportNotFoundError) Error() string {
 type InvalidFunctionIndexError uint32
 
 func (e InvalidFunctionIndexError) Error() string {
-	return fmt.Sprintf("wasm: Invalid index to function index space: %#x", e)
+	return fmt.Sprintf("wasm: Invalid index to function index space: %#x", uint32(e))
+}
+
+type InvalidTypeIndexError uint32
+
+func (e InvalidTypeIndexError) Error() string {
+	return fmt.Sprintf("wasm: Invalid index to type section: %d", uint32(e))
+}
+
+// ImportSigMismatchError is returned when the signature of an imported
+// function does not match the type declared by the import entry.
+type ImportSigMismatchError struct {
+	ModuleName string
+	FieldName  string
+	Wanted     FunctionSig
+	Got        FunctionSig
+}
+
+func (e ImportSigMismatchError) Error() string {
+	return fmt.Sprintf("wasm: Mismatching signature for imported function %s.%s: wanted %v, got %v", e.ModuleName, e.FieldName, e.Wanted, e.Got)
+}
+
+// ImportGlobalMismatchError is returned when the type or mutability of an
+// imported global does not match the global declared by the import entry.
+type ImportGlobalMismatchError struct {
+	ModuleName string
+	FieldName  string
+	Wanted     GlobalVar
+	Got        GlobalVar
+}
+
+func (e ImportGlobalMismatchError) Error() string {
+	return fmt.Sprintf("wasm: Mismatching type for imported global %s.%s: wanted %s, got %s", e.ModuleName, e.FieldName, globalVarString(e.Wanted), globalVarString(e.Got))
+}
+
+func globalVarString(g GlobalVar) string {
+	if g.Mutable {
+		return fmt.Sprintf("mut %v", g.Type)
+	}
+	return g.Type.String()
 }
 
 func (module *Module) resolveImports(resolve ResolveFunc) error {
@@ -133,16 +171,58 @@ func (module *Module) resolveImports(resolve ResolveFunc) error {
 			if fn == nil {
 				return InvalidFunctionIndexError(index)
 			}
+			typeIndex := importEntry.Type.(FuncImport).Type
+			if module.Types == nil || int(typeIndex) >= len(module.Types.Entries) {
+				return InvalidTypeIndexError(typeIndex)
+			}
+			if sig := module.Types.Entries[typeIndex]; !sig.Equal(*fn.Sig) {
+				return ImportSigMismatchError{
+					ModuleName: importEntry.ModuleName,
+					FieldName:  importEntry.FieldName,
+					Wanted:     sig,
+					Got:        *fn.Sig,
+				}
+			}
+			if !fn.IsHost() {
+				// A function defined in WebAssembly can only be executed
+				// against the index spaces of its own module: only its
+				// signature is imported, and the embedder binds it to an
+				// instance of the imported module (see exec.Import).
+				fn = &Function{Sig: fn.Sig}
+			}
 			module.FunctionIndexSpace = append(module.FunctionIndexSpace, *fn)
 		case ExternalGlobal:
 			glb := importedModule.GetGlobal(int(index))
 			if glb == nil {
 				return InvalidGlobalIndexError(index)
 			}
-			if glb.Type.Mutable {
-				return ErrImportMutGlobal
+			typ := importEntry.Type.(GlobalVarImport).Type
+			if typ != *glb.Type {
+				return ImportGlobalMismatchError{
+					ModuleName: importEntry.ModuleName,
+					FieldName:  importEntry.FieldName,
+					Wanted:     typ,
+					Got:        *glb.Type,
+				}
+			}
+			if typ.Mutable {
+				if err := module.features.Check(FeatureMutableGlobals); err != nil {
+					return err
+				}
+			}
+			// The initializer of the global may refer to the global index
+			// space of the imported module, replace it by its value. This
+			// is the initial value of a mutable global, whose storage is
+			// shared with the instances bound by the embedder (see
+			// exec.Import).
+			v, _, err := importedModule.execInitExpr(glb.Init)
+			if err != nil {
+				return err
 			}
-			module.GlobalIndexSpace = append(module.GlobalIndexSpace, *glb)
+			module.GlobalIndexSpace = append(module.GlobalIndexSpace, GlobalEntry{
+				Type: &typ,
+				Init: constInitExpr(v, typ.Type),
+			})
 
 			// In both cases below, index should be always 0 (according to the MVP)
 			// We check it against the length of the index space anyway.
@@ -150,12 +230,17 @@ func (module *Module) resolveImports(resolve ResolveFunc) error {
 			if int(index) >= len(importedModule.TableIndexSpace) {
 				return InvalidTableIndexError(index)
 			}
-			module.TableIndexSpace[0] = importedModule.TableIndexSpace[0]
+			if len(module.TableIndexSpace) == 0 {
+				module.TableIndexSpace = make([][]uint32, 1)
+			}
+			// The elements of the table are functions of the imported
+			// module, which are not in the function index space of module.
+			module.TableIndexSpace[0] = newTable(uint32(len(importedModule.TableIndexSpace[index])))
 		case ExternalMemory:
 			if int(index) >= len(importedModule.LinearMemoryIndexSpace) {
 				return InvalidLinearMemoryIndexError(index)
 			}
-			module.LinearMemoryIndexSpace[0] = importedModule.LinearMemoryIndexSpace[0]
+			module.LinearMemoryIndexSpace[0] = append([]byte(nil), importedModule.LinearMemoryIndexSpace[index]...)
 		default:
 			return InvalidExternalError(exportEntry.Kind)
 		}
@@ -40,7 +40,7 @@ func (m *Module) populateFunctions() error {
 
 	for codeIndex, typeIndex := range m.Function.Types {
 		if int(typeIndex) >= len(m.Types.Entries) {
-			return InvalidFunctionIndexError(typeIndex)
+			return InvalidTypeIndexError(typeIndex)
 		}
 
 		fn := Function{
@@ -54,6 +54,22 @@ func (m *Module) populateFunctions() error {
 	return nil
 }
 
+// populateFunctionNames names the functions in the function index space
+// after the module's name section.
+func (m *Module) populateFunctionNames() error {
+	if m.Name == nil {
+		return nil
+	}
+
+	for index, name := range m.Name.Functions {
+		if int(index) < len(m.FunctionIndexSpace) {
+			m.FunctionIndexSpace[index].Name = name
+		}
+	}
+
+	return nil
+}
+
 // GetFunction returns a *Function, based on the function's index in
 // the function index space. Returns nil when the index is invalid
 func (m *Module) GetFunction(i int) *Function {
@@ -84,12 +100,33 @@ func (m *Module) GetGlobal(i int) *GlobalEntry {
 	return &m.GlobalIndexSpace[i]
 }
 
+// UninitializedElement is the value of the elements of the tables in
+// Module.TableIndexSpace which are not initialized by an element segment.
+const UninitializedElement = ^uint32(0)
+
+// newTable returns a table of n uninitialized elements.
+func newTable(n uint32) []uint32 {
+	table := make([]uint32, n)
+	for i := range table {
+		table[i] = UninitializedElement
+	}
+	return table
+}
+
+// ElementSegmentOutOfBoundsError is returned when an element segment does
+// not fit in its table.
+type ElementSegmentOutOfBoundsError uint32
+
+func (e ElementSegmentOutOfBoundsError) Error() string {
+	return fmt.Sprintf("wasm: element segment %d does not fit in its table", uint32(e))
+}
+
 func (m *Module) populateTables() error {
-	if m.Table == nil || len(m.Table.Entries) == 0 || m.Elements == nil || len(m.Elements.Entries) == 0 {
+	if m.Elements == nil || len(m.Elements.Entries) == 0 {
 		return nil
 	}
 
-	for _, elem := range m.Elements.Entries {
+	for i, elem := range m.Elements.Entries {
 		// the MVP dictates that index should always be zero, we shuold
 		// probably check this
 		if int(elem.Index) >= len(m.TableIndexSpace) {
@@ -106,15 +143,10 @@ func (m *Module) populateTables() error {
 		}
 
 		table := m.TableIndexSpace[int(elem.Index)]
-		if int(offset)+len(elem.Elems) > len(table) {
-			data := make([]uint32, int(offset)+len(elem.Elems))
-			copy(data[offset:], elem.Elems)
-			copy(data, table)
-			m.TableIndexSpace[int(elem.Index)] = data
-		} else {
-			copy(table[int(offset):], elem.Elems)
-			m.TableIndexSpace[int(elem.Index)] = table
+		if offset < 0 || int64(offset)+int64(len(elem.Elems)) > int64(len(table)) {
+			return ElementSegmentOutOfBoundsError(i)
 		}
+		copy(table[int(offset):], elem.Elems)
 	}
 
 	logger.Printf("There are %d entries in the table index space.", len(m.TableIndexSpace))
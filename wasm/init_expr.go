@@ -6,6 +6,7 @@ package wasm
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -90,13 +91,52 @@ outer:
 // which can either be int32, int64, float32 or float64.
 // It returns an error if the expression is invalid, and nil when the expression
 // yields no value.
+// Only imported globals may be accessed by get_global in initializer
+// expressions.
 func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
+	v, typ, err := m.execInitExpr(expr)
+	if err != nil || typ == 0 {
+		return nil, err
+	}
+
+	switch typ {
+	case ValueTypeI32:
+		return int32(v), nil
+	case ValueTypeI64:
+		return int64(v), nil
+	case ValueTypeF32:
+		return math.Float32frombits(uint32(v)), nil
+	case ValueTypeF64:
+		return math.Float64frombits(uint64(v)), nil
+	default:
+		panic(fmt.Sprintf("Invalid value type produced by initializer expression: %d", int8(typ)))
+	}
+}
+
+// execInitExpr executes an initializer expression, and returns the bits
+// of the value it yields along with its type, which is 0 if the
+// expression yields no value.
+func (m *Module) execInitExpr(expr []byte) (uint64, ValueType, error) {
+	return m.EvalInitExpr(expr, func(index uint32) (uint64, error) {
+		// imported globals are initialized by a constant
+		// expression (see resolveImports).
+		v, _, err := m.execInitExpr(m.GlobalIndexSpace[index].Init)
+		return v, err
+	})
+}
+
+// EvalInitExpr executes an initializer expression like ExecInitExpr, but
+// get_global yields the value returned by global for the index of the
+// imported global, instead of the value the global was resolved to when the
+// module was read. It returns the bits of the value yielded by the
+// expression along with its type, which is 0 if it yields no value.
+func (m *Module) EvalInitExpr(expr []byte, global func(index uint32) (uint64, error)) (uint64, ValueType, error) {
 	var stack []uint64
 	var lastVal ValueType
 	r := bytes.NewReader(expr)
 
 	if r.Len() == 0 {
-		return nil, ErrEmptyInitExpr
+		return 0, 0, ErrEmptyInitExpr
 	}
 
 	for {
@@ -104,69 +144,95 @@ func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 		switch b {
 		case i32Const:
 			i, err := leb128.ReadVarint32(r)
 			if err != nil {
-				return nil, err
+				return 0, 0, err
 			}
-			stack = append(stack, uint64(i))
+			stack = append(stack, uint64(uint32(i)))
 			lastVal = ValueTypeI32
 		case i64Const:
 			i, err := leb128.ReadVarint64(r)
 			if err != nil {
-				return nil, err
+				return 0, 0, err
 			}
 			stack = append(stack, uint64(i))
 			lastVal = ValueTypeI64
 		case f32Const:
 			i, err := readU32(r)
 			if err != nil {
-				return nil, err
+				return 0, 0, err
 			}
 			stack = append(stack, uint64(i))
 			lastVal = ValueTypeF32
 		case f64Const:
 			i, err := readU64(r)
 			if err != nil {
-				return nil, err
+				return 0, 0, err
 			}
 			stack = append(stack, i)
 			lastVal = ValueTypeF64
 		case getGlobal:
 			index, err := leb128.ReadVarUint32(r)
 			if err != nil {
-				return nil, err
+				return 0, 0, err
 			}
 			globalVar := m.GetGlobal(int(index))
-			if globalVar == nil {
-				return nil, InvalidGlobalIndexError(index)
+			if globalVar == nil || int(index) >= m.importedGlobals() {
+				return 0, 0, InvalidGlobalIndexError(index)
 			}
+			v, err := global(index)
+			if err != nil {
+				return 0, 0, err
+			}
+			stack = append(stack, v)
 			lastVal = globalVar.Type.Type
 		case end:
 			break
 		default:
-			return nil, InvalidInitExprOpError(b)
+			return 0, 0, InvalidInitExprOpError(b)
 		}
 	}
 
 	if len(stack) == 0 {
-		return nil, nil
+		return 0, 0, nil
+	}
+	return stack[len(stack)-1], lastVal, nil
+}
+
+// importedGlobals returns the number of imported globals, which come first
+// in the global index space.
+func (m *Module) importedGlobals() int {
+	if m.Import == nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range m.Import.Entries {
+		if entry.Kind == ExternalGlobal {
+			n++
+		}
 	}
+	return n
+}
 
-	v := stack[len(stack)-1]
-	switch lastVal {
+// constInitExpr returns an initializer expression yielding the value v of
+// type typ, encoded as in the stack of execInitExpr.
+func constInitExpr(v uint64, typ ValueType) []byte {
+	var expr []byte
+	switch typ {
 	case ValueTypeI32:
-		return int32(v), nil
+		expr = leb128.AppendVarint32(append(expr, i32Const), int32(v))
 	case ValueTypeI64:
-		return int64(v), nil
+		expr = leb128.AppendVarint64(append(expr, i64Const), int64(v))
 	case ValueTypeF32:
-		return math.Float32frombits(uint32(v)), nil
+		expr = append(expr, f32Const, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(expr[1:], uint32(v))
 	case ValueTypeF64:
-		return math.Float64frombits(uint64(v)), nil
-	default:
-		panic(fmt.Sprintf("Invalid value type produced by initializer expression: %d", int8(lastVal)))
+		expr = append(expr, f64Const, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(expr[1:], v)
 	}
+	return append(expr, end)
 }
@@ -0,0 +1,59 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package leb128
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The fuzz targets check that the values are decoded back from their
+// encoding, and that arbitrary input is either rejected or decoded to a
+// value whose encoding is at most as long as the input.
+
+func FuzzReadVarint32(f *testing.F) {
+	f.Add(int32(0), []byte{0x00})
+	f.Add(int32(-1<<31), []byte{0xff, 0xff, 0xff, 0xff, 0x7f})
+	f.Add(int32(1<<31-1), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00})
+	f.Fuzz(func(t *testing.T, v int32, b []byte) {
+		enc := AppendVarint32(nil, v)
+		n, size, err := ReadVarint32Size(bytes.NewReader(enc))
+		if err != nil || n != v || size != uint(len(enc)) {
+			t.Fatalf("%d: decoded %#x to %d, %d, %v", v, enc, n, size, err)
+		}
+
+		n, size, err = ReadVarint32Size(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		if size > 5 || len(AppendVarint32(nil, n)) > int(size) {
+			t.Fatalf("%#x: decoded %d from %d bytes", b, n, size)
+		}
+	})
+}
+
+func FuzzReadVarUint64(f *testing.F) {
+	f.Add(uint64(0), []byte{0x00})
+	f.Add(uint64(1<<64-1), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add(uint64(1<<63), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00})
+	f.Fuzz(func(t *testing.T, v uint64, b []byte) {
+		enc := AppendVarUint64(nil, v)
+		n, size, err := ReadVarUint64Size(bytes.NewReader(enc))
+		if err != nil || n != v || size != uint(len(enc)) {
+			t.Fatalf("%d: decoded %#x to %d, %d, %v", v, enc, n, size, err)
+		}
+
+		n, size, err = ReadVarUint64Size(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		if size > 10 || len(AppendVarUint64(nil, n)) > int(size) {
+			t.Fatalf("%#x: decoded %d from %d bytes", b, n, size)
+		}
+	})
+}
@@ -2,29 +2,82 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package leb128 provides functions for reading integer values encoded in the
-// Little Endian Base 128 (LEB128) format: https://en.wikipedia.org/wiki/LEB128
+// Package leb128 provides functions for reading and writing integer values
+// encoded in the Little Endian Base 128 (LEB128) format:
+// https://en.wikipedia.org/wiki/LEB128
+//
+// As required by the WebAssembly spec, the readers reject encodings of
+// N-bit integers longer than ceil(N/7) bytes, and encodings whose last
+// byte has unused bits that are not zero (for unsigned integers) or a
+// sign extension of the value (for signed integers).
 package leb128
 
 import (
+	"fmt"
 	"io"
 )
 
-// ReadVarUint32Size reads a LEB128 encoded unsigned 32-bit integer from r.
-// It returns the integer value, the size of the encoded value (in bytes), and
-// the error (if any).
-func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
+// TooLongError is returned when the encoding of an integer is longer than
+// the maximum length for its type.
+type TooLongError struct {
+	Signed bool
+	Bits   uint // Size of the integer type, in bits
+}
+
+func (e TooLongError) Error() string {
+	return fmt.Sprintf("leb128: encoding of %s exceeds %d bytes", typeName(e.Signed, e.Bits), maxLen(e.Bits))
+}
+
+// UnusedBitsError is returned when the unused bits of the last byte of an
+// integer's encoding are invalid for its type.
+type UnusedBitsError struct {
+	Signed bool
+	Bits   uint // Size of the integer type, in bits
+	Byte   byte // Last byte of the encoding
+}
+
+func (e UnusedBitsError) Error() string {
+	return fmt.Sprintf("leb128: invalid unused bits in last byte %#x of %s", e.Byte, typeName(e.Signed, e.Bits))
+}
+
+func typeName(signed bool, bits uint) string {
+	if signed {
+		return fmt.Sprintf("varint%d", bits)
+	}
+	return fmt.Sprintf("varuint%d", bits)
+}
+
+// maxLen returns the maximum length of the encoding of an integer of the
+// given size (in bits).
+func maxLen(bits uint) uint {
+	return (bits + 6) / 7
+}
+
+// readVarUint reads a LEB128 encoded unsigned integer of the given size (in
+// bits) from r.
+func readVarUint(r io.Reader, bits uint) (res uint64, size uint, err error) {
 	b := make([]byte, 1)
 	var shift uint
 	for {
 		if _, err = io.ReadFull(r, b); err != nil {
+			if err == io.EOF && size != 0 {
+				err = io.ErrUnexpectedEOF
+			}
 			return
 		}
-
 		size++
 
-		cur := uint32(b[0])
-		res |= (cur & 0x7f) << (shift)
+		cur := uint64(b[0])
+		if size == maxLen(bits) {
+			if cur&0x80 != 0 {
+				return 0, size, TooLongError{Signed: false, Bits: bits}
+			}
+			if cur>>(bits-shift) != 0 {
+				return 0, size, UnusedBitsError{Signed: false, Bits: bits, Byte: b[0]}
+			}
+		}
+
+		res |= (cur & 0x7f) << shift
 		if cur&0x80 == 0 {
 			return res, size, nil
 		}
@@ -32,6 +85,53 @@ func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
 	}
 }
 
+// readVarint reads a LEB128 encoded signed integer of the given size (in
+// bits) from r.
+func readVarint(r io.Reader, bits uint) (res int64, size uint, err error) {
+	b := make([]byte, 1)
+	var shift uint
+	for {
+		if _, err = io.ReadFull(r, b); err != nil {
+			if err == io.EOF && size != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		size++
+
+		cur := int64(b[0])
+		if size == maxLen(bits) {
+			if cur&0x80 != 0 {
+				return 0, size, TooLongError{Signed: true, Bits: bits}
+			}
+			// the unused bits must be equal to the sign bit
+			if rest := cur >> (bits - shift - 1); rest != 0 && rest != 0x7f>>(bits-shift-1) {
+				return 0, size, UnusedBitsError{Signed: true, Bits: bits, Byte: b[0]}
+			}
+		}
+
+		res |= (cur & 0x7f) << shift
+		shift += 7
+		if cur&0x80 == 0 {
+			break
+		}
+	}
+
+	// sign extend the result
+	if shift < 64 && res&(1<<(shift-1)) != 0 {
+		res |= -1 << shift
+	}
+	return res, size, nil
+}
+
+// ReadVarUint32Size reads a LEB128 encoded unsigned 32-bit integer from r.
+// It returns the integer value, the size of the encoded value (in bytes), and
+// the error (if any).
+func ReadVarUint32Size(r io.Reader) (res uint32, size uint, err error) {
+	res64, size, err := readVarUint(r, 32)
+	return uint32(res64), size, err
+}
+
 // ReadVarUint32 reads a LEB128 encoded unsigned 32-bit integer from r, and
 // returns the integer value, and the error (if any).
 func ReadVarUint32(r io.Reader) (uint32, error) {
@@ -39,13 +139,26 @@ func ReadVarUint32(r io.Reader) (uint32, error) {
 	return n, err
 }
 
+// ReadVarUint64Size reads a LEB128 encoded unsigned 64-bit integer from r.
+// It returns the integer value, the size of the encoded value (in bytes), and
+// the error (if any).
+func ReadVarUint64Size(r io.Reader) (res uint64, size uint, err error) {
+	return readVarUint(r, 64)
+}
+
+// ReadVarUint64 reads a LEB128 encoded unsigned 64-bit integer from r, and
+// returns the integer value, and the error (if any).
+func ReadVarUint64(r io.Reader) (uint64, error) {
+	n, _, err := ReadVarUint64Size(r)
+	return n, err
+}
+
 // ReadVarint32Size reads a LEB128 encoded signed 32-bit integer from r, and
 // returns the integer value, the size of the encoded value, and the error
 // (if any)
 func ReadVarint32Size(r io.Reader) (res int32, size uint, err error) {
-	res64, size, err := ReadVarint64Size(r)
-	res = int32(res64)
-	return
+	res64, size, err := readVarint(r, 32)
+	return int32(res64), size, err
 }
 
 // ReadVarint32 reads a LEB128 encoded signed 32-bit integer from r, and
@@ -59,29 +172,7 @@ func ReadVarint32(r io.Reader) (int32, error) {
 // returns the integer value, the size of the encoded value, and the error
 // (if any)
 func ReadVarint64Size(r io.Reader) (res int64, size uint, err error) {
-	var shift uint
-	var sign int64 = -1
-	b := make([]byte, 1)
-
-	for {
-		if _, err = io.ReadFull(r, b); err != nil {
-			return
-		}
-		size++
-
-		cur := int64(b[0])
-		res |= (cur & 0x7f) << shift
-		shift += 7
-		sign <<= 7
-		if cur&0x80 == 0 {
-			break
-		}
-	}
-
-	if ((sign >> 1) & res) != 0 {
-		res |= sign
-	}
-	return res, size, nil
+	return readVarint(r, 64)
 }
 
 // ReadVarint64 reads a LEB128 encoded signed 64-bit integer from r, and
@@ -6,7 +6,9 @@ package leb128
 
 import (
 	"bytes"
+	"io"
 	"testing"
+	"testing/quick"
 )
 
 func TestReadVarUint32(t *testing.T) {
@@ -29,3 +31,133 @@ func TestReadVarint32(t *testing.T) {
 		t.Fatalf("got = %d; want = %d", n, -129)
 	}
 }
+
+func TestReadInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		read func(io.Reader) error
+		b    []byte
+		err  error
+	}{
+		// overlong and padded encodings, continued past the 5 and 10
+		// byte limits
+		{"varuint32", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, TooLongError{false, 32}},
+		{"varuint32", readUint32, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, TooLongError{false, 32}},
+		{"varuint32", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x80}, TooLongError{false, 32}},
+		{"varuint64", readUint64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, TooLongError{false, 64}},
+		{"varuint64", readUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x81, 0x00}, TooLongError{false, 64}},
+		{"varint32", readInt32, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, TooLongError{true, 32}},
+		{"varint32", readInt32, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, TooLongError{true, 32}},
+		{"varint64", readInt64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, TooLongError{true, 64}},
+		{"varint64", readInt64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, TooLongError{true, 64}},
+
+		// unused bits of the 5th and 10th bytes
+		{"varuint32", readUint32, []byte{0xff, 0xff, 0xff, 0xff, 0x1f}, UnusedBitsError{false, 32, 0x1f}},
+		{"varuint32", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x10}, UnusedBitsError{false, 32, 0x10}},
+		{"varuint32", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x70}, UnusedBitsError{false, 32, 0x70}},
+		{"varuint64", readUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, UnusedBitsError{false, 64, 0x02}},
+		{"varuint64", readUint64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7e}, UnusedBitsError{false, 64, 0x7e}},
+		{"varint32", readInt32, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, UnusedBitsError{true, 32, 0x0f}},
+		{"varint32", readInt32, []byte{0x80, 0x80, 0x80, 0x80, 0x70}, UnusedBitsError{true, 32, 0x70}},
+		{"varint32", readInt32, []byte{0x80, 0x80, 0x80, 0x80, 0x40}, UnusedBitsError{true, 32, 0x40}},
+		{"varint32", readInt32, []byte{0xff, 0xff, 0xff, 0xff, 0x77}, UnusedBitsError{true, 32, 0x77}},
+		{"varint64", readInt64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, UnusedBitsError{true, 64, 0x01}},
+		{"varint64", readInt64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7e}, UnusedBitsError{true, 64, 0x7e}},
+		{"varint64", readInt64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40}, UnusedBitsError{true, 64, 0x40}},
+
+		// truncated encodings
+		{"varuint32", readUint32, []byte{0x80, 0x80}, io.ErrUnexpectedEOF},
+		{"varuint32", readUint32, []byte{}, io.EOF},
+		{"varint64", readInt64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, io.ErrUnexpectedEOF},
+	} {
+		if err := tc.read(bytes.NewReader(tc.b)); err != tc.err {
+			t.Errorf("%s %#x: unexpected error: got=%v, want=%v", tc.name, tc.b, err, tc.err)
+		}
+	}
+}
+
+func TestReadPadded(t *testing.T) {
+	// padded encodings are valid, as long as they don't exceed the
+	// maximum length.
+	for _, tc := range []struct {
+		name string
+		read func(io.Reader) error
+		b    []byte
+	}{
+		{"varuint32", readUint32, []byte{0x80, 0x80, 0x80, 0x80, 0x00}},
+		{"varuint32", readUint32, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}},
+		{"varint32", readInt32, []byte{0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{"varint32", readInt32, []byte{0x80, 0x80, 0x80, 0x80, 0x78}},
+		{"varint64", readInt64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{"varuint64", readUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	} {
+		if err := tc.read(bytes.NewReader(tc.b)); err != nil {
+			t.Errorf("%s %#x: unexpected error: %v", tc.name, tc.b, err)
+		}
+	}
+}
+
+func readUint32(r io.Reader) error { _, err := ReadVarUint32(r); return err }
+func readUint64(r io.Reader) error { _, err := ReadVarUint64(r); return err }
+func readInt32(r io.Reader) error  { _, err := ReadVarint32(r); return err }
+func readInt64(r io.Reader) error  { _, err := ReadVarint64(r); return err }
+
+func TestRoundTrip(t *testing.T) {
+	for name, f := range map[string]interface{}{
+		"varuint32": func(v uint32) bool {
+			b := AppendVarUint32(nil, v)
+			n, size, err := ReadVarUint32Size(bytes.NewReader(b))
+			return err == nil && n == v && size == uint(len(b))
+		},
+		"varuint64": func(v uint64) bool {
+			b := AppendVarUint64(nil, v)
+			n, size, err := ReadVarUint64Size(bytes.NewReader(b))
+			return err == nil && n == v && size == uint(len(b))
+		},
+		"varint32": func(v int32) bool {
+			b := AppendVarint32(nil, v)
+			n, size, err := ReadVarint32Size(bytes.NewReader(b))
+			return err == nil && n == v && size == uint(len(b))
+		},
+		"varint64": func(v int64) bool {
+			b := AppendVarint64(nil, v)
+			n, size, err := ReadVarint64Size(bytes.NewReader(b))
+			return err == nil && n == v && size == uint(len(b))
+		},
+		// shifted values cover all encoding lengths
+		"varuint64 shifted": func(v uint64, shift uint8) bool {
+			v >>= shift % 64
+			n, err := ReadVarUint64(bytes.NewReader(AppendVarUint64(nil, v)))
+			return err == nil && n == v
+		},
+		"varint64 shifted": func(v int64, shift uint8) bool {
+			v >>= shift % 64
+			n, err := ReadVarint64(bytes.NewReader(AppendVarint64(nil, v)))
+			return err == nil && n == v
+		},
+	} {
+		if err := quick.Check(f, &quick.Config{MaxCount: 10000}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	for _, tc := range []struct {
+		got, want []byte
+	}{
+		{AppendVarUint32(nil, 0), []byte{0x00}},
+		{AppendVarUint32(nil, 16256), []byte{0x80, 0x7f}},
+		{AppendVarUint32([]byte{0x01}, 0xffffffff), []byte{0x01, 0xff, 0xff, 0xff, 0xff, 0x0f}},
+		{AppendVarint32(nil, -129), []byte{0xff, 0x7e}},
+		{AppendVarint32(nil, 63), []byte{0x3f}},
+		{AppendVarint32(nil, 64), []byte{0xc0, 0x00}},
+		{AppendVarint32(nil, -64), []byte{0x40}},
+		{AppendVarint64(nil, -1<<63), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}},
+		{AppendVarUint64(nil, 1<<63), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}},
+	} {
+		if !bytes.Equal(tc.got, tc.want) {
+			t.Errorf("got=%#x, want=%#x", tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package leb128
+
+// AppendVarUint32 appends the LEB128 encoding of the unsigned 32-bit
+// integer v to b, and returns the extended buffer.
+func AppendVarUint32(b []byte, v uint32) []byte {
+	return AppendVarUint64(b, uint64(v))
+}
+
+// AppendVarUint64 appends the LEB128 encoding of the unsigned 64-bit
+// integer v to b, and returns the extended buffer.
+func AppendVarUint64(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// AppendVarint32 appends the LEB128 encoding of the signed 32-bit integer
+// v to b, and returns the extended buffer.
+func AppendVarint32(b []byte, v int32) []byte {
+	return AppendVarint64(b, int64(v))
+}
+
+// AppendVarint64 appends the LEB128 encoding of the signed 64-bit integer
+// v to b, and returns the extended buffer.
+func AppendVarint64(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
@@ -7,6 +7,7 @@ package wasm
 import (
 	"errors"
 	"io"
+	"reflect"
 
 	"github.com/go-interpreter/wagon/wasm/internal/readpos"
 )
@@ -22,6 +23,22 @@ const (
 type Function struct {
 	Sig  *FunctionSig
 	Body *FunctionBody
+
+	// Host, if valid, is a Go function implementing this function. Host
+	// functions are provided by the embedder (see ResolveFunc), and have
+	// no Body.
+	// The functions imported from a WebAssembly module have neither a
+	// Body nor a Host function: they are only executed by the instances
+	// of that module.
+	Host reflect.Value
+
+	// Name is the name of the function in the module's name section, if any.
+	Name string
+}
+
+// IsHost indicates whether this function is implemented by a Go function.
+func (fct *Function) IsHost() bool {
+	return fct.Host.IsValid()
 }
 
 // Module represents a parsed WebAssembly module:
@@ -44,28 +61,76 @@ type Module struct {
 	// The function index space of the module
 	FunctionIndexSpace []Function
 	GlobalIndexSpace   []GlobalEntry
-	// function indices into the global function space
-	// the limit of each table is its capacity (cap)
+	// function indices into the global function space, or
+	// UninitializedElement for the elements not set by the element section.
+	// Each table has the initial size given by its limits.
 	TableIndexSpace        [][]uint32
 	LinearMemoryIndexSpace [][]byte
 
-	// Name *SectionName
+	Name *SectionName
+
+	// Custom sections of the module, in the order they appear in it
+	Customs []*CustomSection
 
+	// Other holds the custom sections of Customs, without their decoded
+	// values. It is kept for compatibility, and is ignored by WriteModule.
 	Other []Section
+
+	features Features                        // the features enabled by ReadModule
+	decoders map[string]CustomSectionDecoder // the decoders given to ReadModule
+}
+
+// ReadOption configures the decoding of a module by ReadModule.
+type ReadOption func(*Module)
+
+// DecodeCustomSection returns a ReadOption decoding the payload of the
+// custom sections named name with decode, whose result is stored in the
+// Value field of the section's entry in Module.Customs. An error returned
+// by decode aborts ReadModule.
+func DecodeCustomSection(name string, decode CustomSectionDecoder) ReadOption {
+	return func(m *Module) {
+		if m.decoders == nil {
+			m.decoders = make(map[string]CustomSectionDecoder)
+		}
+		m.decoders[name] = decode
+	}
 }
 
 // ResolveFunc is a function that takes a module name and
 // returns a valid resolved module.
+// The returned module may be a regular WebAssembly module, or a module
+// whose FunctionIndexSpace contains host functions (see Function.Host).
 type ResolveFunc func(name string) (*Module, error)
 
+// ErrNoResolveFunc is returned by ReadModule when the module has imports,
+// but no ResolveFunc was provided to resolve them.
+var ErrNoResolveFunc = errors.New("wasm: module has imports, but no ResolveFunc was provided")
+
+// NewModule creates a new empty module, with initialized type, function
+// and export sections. It can be used by embedders to construct modules
+// exporting host functions, to be returned by a ResolveFunc.
+func NewModule() *Module {
+	return &Module{
+		Types:    &SectionTypes{},
+		Function: &SectionFunctions{},
+		Export:   &SectionExports{Entries: make(map[string]ExportEntry)},
+	}
+}
+
 // ReadModule reads a module from the reader r. resolvePath must take a string
 // and a return a reader to the module pointed to by the string.
-func ReadModule(r io.Reader, resolvePath ResolveFunc) (*Module, error) {
+// resolvePath is used to resolve the module's imports, and may be nil
+// if the module has no imports. The encodings of the proposals beyond the
+// MVP are only accepted if their features are enabled.
+func ReadModule(r io.Reader, resolvePath ResolveFunc, features Features, opts ...ReadOption) (*Module, error) {
 	reader := &readpos.ReadPos{
 		R:      r,
 		CurPos: 0,
 	}
-	m := &Module{}
+	m := &Module{features: features}
+	for _, opt := range opts {
+		opt(m)
+	}
 	magic, err := readU32(reader)
 	if err != nil {
 		return nil, err
@@ -88,16 +153,24 @@ func ReadModule(r io.Reader, resolvePath ResolveFunc) (*Module, error) {
 
 	m.LinearMemoryIndexSpace = make([][]byte, 1)
 	if m.Table != nil {
-		m.TableIndexSpace = make([][]uint32, int(len(m.Table.Entries)))
+		for _, table := range m.Table.Entries {
+			m.TableIndexSpace = append(m.TableIndexSpace, newTable(table.Limits.Initial))
+		}
 	}
 
 	if m.Import != nil {
-		return nil, errors.New("Imports aren't supported")
+		if resolvePath == nil {
+			return nil, ErrNoResolveFunc
+		}
+		if err := m.resolveImports(resolvePath); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, fn := range []func() error{
 		m.populateGlobals,
 		m.populateFunctions,
+		m.populateFunctionNames,
 		m.populateTables,
 		m.populateLinearMemory,
 	} {
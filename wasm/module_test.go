@@ -6,8 +6,10 @@ package wasm_test
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/go-interpreter/wagon/wasm"
@@ -27,7 +29,7 @@ func TestReadModule(t *testing.T) {
 			}
 
 			r := bytes.NewReader(raw)
-			m, err := wasm.ReadModule(r, nil)
+			m, err := wasm.ReadModule(r, nil, wasm.MVP)
 			if err != nil {
 				t.Fatalf("error reading module %v", err)
 			}
@@ -37,3 +39,115 @@ func TestReadModule(t *testing.T) {
 		})
 	}
 }
+
+func TestReadNameSection(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "names.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.ReadModule(bytes.NewReader(raw), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name == nil {
+		t.Fatal("missing name section")
+	}
+
+	if m.Name.ModuleName != "names" {
+		t.Errorf("unexpected module name: got=%q, want=%q", m.Name.ModuleName, "names")
+	}
+	if want := (wasm.NameMap{0: "add", 1: "nop"}); !reflect.DeepEqual(m.Name.Functions, want) {
+		t.Errorf("unexpected function names: got=%v, want=%v", m.Name.Functions, want)
+	}
+	if want := map[uint32]wasm.NameMap{0: {0: "a", 1: "b"}, 1: {}}; !reflect.DeepEqual(m.Name.Locals, want) {
+		t.Errorf("unexpected local names: got=%v, want=%v", m.Name.Locals, want)
+	}
+	for i, want := range []string{"add", "nop"} {
+		if got := m.FunctionIndexSpace[i].Name; got != want {
+			t.Errorf("unexpected name for function %d: got=%q, want=%q", i, got, want)
+		}
+	}
+}
+
+func TestReadMalformedNameSection(t *testing.T) {
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic and version
+		0x00, 0x0c, 0x04, 'n', 'a', 'm', 'e', // custom section "name"
+		0x01, 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f, // 0xffffffff function names
+	}
+	m, err := wasm.ReadModule(bytes.NewReader(raw), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != nil {
+		t.Errorf("malformed name section was decoded: %+v", m.Name)
+	}
+	if len(m.Customs) != 1 || m.Customs[0].Name != "name" {
+		t.Errorf("malformed name section was not kept as a custom section")
+	}
+}
+
+func TestCustomSections(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "names.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodeFoo := wasm.DecodeCustomSection("foo", func(payload []byte) (interface{}, error) {
+		return len(payload), nil
+	})
+	m, err := wasm.ReadModule(bytes.NewReader(raw), nil, wasm.MVP, decodeFoo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Customs) != 2 || len(m.Other) != 2 {
+		t.Fatalf("unexpected number of custom sections: got=%d and %d, want=2", len(m.Customs), len(m.Other))
+	}
+	for i, want := range []struct {
+		name  string
+		bytes []byte
+		value interface{}
+	}{
+		{"foo", []byte{1, 2, 3}, 3},
+		{"name", m.Name.Bytes, nil},
+	} {
+		c := m.Customs[i]
+		if c.Name != want.name {
+			t.Errorf("custom section %d: unexpected name: got=%q, want=%q", i, c.Name, want.name)
+		}
+		if !bytes.Equal(c.Bytes, want.bytes) {
+			t.Errorf("custom section %d: unexpected payload: got=%v, want=%v", i, c.Bytes, want.bytes)
+		}
+		if !bytes.Equal(raw[c.Start:c.End], c.Bytes) {
+			t.Errorf("custom section %d: payload doesn't match its position [%d:%d]", i, c.Start, c.End)
+		}
+		if c.Value != want.value {
+			t.Errorf("custom section %d: unexpected value: got=%v, want=%v", i, c.Value, want.value)
+		}
+		if !reflect.DeepEqual(m.Other[i], c.Section) {
+			t.Errorf("custom section %d: Other doesn't match Customs: got=%+v, want=%+v", i, m.Other[i], c.Section)
+		}
+	}
+
+	// the decoders only apply to the call they are given to
+	m, err = wasm.ReadModule(bytes.NewReader(raw), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := m.Customs[0].Value; v != nil {
+		t.Errorf("custom section decoded without decoder: %v", v)
+	}
+
+	code := m.Code.Section
+	if len(code.Bytes) == 0 || !bytes.Equal(raw[code.Start:code.End], code.Bytes) {
+		t.Errorf("code section payload doesn't match its position [%d:%d]", code.Start, code.End)
+	}
+
+	wantErr := errors.New("foo")
+	decodeFoo = wasm.DecodeCustomSection("foo", func([]byte) (interface{}, error) {
+		return nil, wantErr
+	})
+	if _, err = wasm.ReadModule(bytes.NewReader(raw), nil, wasm.MVP, decodeFoo); err != wantErr {
+		t.Errorf("unexpected error: got=%v, want=%v", err, wantErr)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/go-interpreter/wagon/wasm"
 )
 
-var reCvrtOp = regexp.MustCompile(`(.+)\.(?:[a-z]|\_)+\/(.+)`)
+var reCvrtOp = regexp.MustCompile(`(.+)\.(?:[a-z]|\_|:)+\/(.+)`)
 
 func valType(s string) wasm.ValueType {
 	switch s {
@@ -27,18 +27,26 @@ func valType(s string) wasm.ValueType {
 	}
 }
 
-func newConversionOp(code byte, name string) byte {
+// conversionTypes returns the parameter and result types of the conversion
+// operator name.
+func conversionTypes(name string) (wasm.ValueType, wasm.ValueType) {
 	matches := reCvrtOp.FindStringSubmatch(name)
 	if len(matches) == 0 {
 		panic(name + " is not a conversion operator")
 	}
+	return valType(matches[2]), valType(matches[1])
+}
 
-	returns := valType(matches[1])
-	param := valType(matches[2])
-
+func newConversionOp(code byte, name string) byte {
+	param, returns := conversionTypes(name)
 	return newOp(code, name, []wasm.ValueType{param}, returns)
 }
 
+func newMiscConversionOp(code byte, name string) byte {
+	param, returns := conversionTypes(name)
+	return newMiscOp(code, name, []wasm.ValueType{param}, returns)
+}
+
 var (
 	I32WrapI64     = newConversionOp(0xa7, "i32.wrap/i64")
 	I32TruncSF32   = newConversionOp(0xa8, "i32.trunc_s/f32")
@@ -62,3 +70,15 @@ var (
 	F64ConvertUI64 = newConversionOp(0xba, "f64.convert_u/i64")
 	F64PromoteF32  = newConversionOp(0xbb, "f64.promote/f32")
 )
+
+// The saturating float-to-int conversions, whose opcodes follow MiscPrefix.
+var (
+	I32TruncSatSF32 = newMiscConversionOp(0x00, "i32.trunc_s:sat/f32")
+	I32TruncSatUF32 = newMiscConversionOp(0x01, "i32.trunc_u:sat/f32")
+	I32TruncSatSF64 = newMiscConversionOp(0x02, "i32.trunc_s:sat/f64")
+	I32TruncSatUF64 = newMiscConversionOp(0x03, "i32.trunc_u:sat/f64")
+	I64TruncSatSF32 = newMiscConversionOp(0x04, "i64.trunc_s:sat/f32")
+	I64TruncSatUF32 = newMiscConversionOp(0x05, "i64.trunc_u:sat/f32")
+	I64TruncSatSF64 = newMiscConversionOp(0x06, "i64.trunc_s:sat/f64")
+	I64TruncSatUF64 = newMiscConversionOp(0x07, "i64.trunc_u:sat/f64")
+)
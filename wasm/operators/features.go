@@ -0,0 +1,42 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package operators
+
+import (
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// MiscPrefix is the prefix of the operators added by the saturating
+// float-to-int conversions, bulk memory and reference types proposals,
+// which are encoded as the prefix followed by a varuint32 opcode.
+const MiscPrefix byte = 0xfc
+
+// Feature returns the feature of the proposal adding the operator whose
+// opcode is code, or wasm.MVP if it is an operator of the MVP. The
+// feature of the operators prefixed by MiscPrefix is given by MiscFeature.
+func Feature(code byte) wasm.Features {
+	switch {
+	case code >= 0xc0 && code <= 0xc4:
+		return wasm.FeatureSignExtension
+	case code == 0x1c, code == 0x25, code == 0x26, code >= 0xd0 && code <= 0xd2:
+		return wasm.FeatureReferenceTypes
+	}
+	return wasm.MVP
+}
+
+// MiscFeature returns the feature of the proposal adding the operator whose
+// opcode, following MiscPrefix, is code. It returns wasm.MVP if there is
+// no such operator.
+func MiscFeature(code uint32) wasm.Features {
+	switch {
+	case code <= 0x07:
+		return wasm.FeatureSaturatingConv
+	case code <= 0x0e:
+		return wasm.FeatureBulkMemory
+	case code <= 0x11:
+		return wasm.FeatureReferenceTypes
+	}
+	return wasm.MVP
+}
@@ -14,12 +14,13 @@ import (
 
 var (
 	ops      [256]Op // an array of Op values mapped by wasm opcodes, used by New().
+	miscOps  [256]Op // the operators prefixed by MiscPrefix, mapped by the opcode following it, used by NewMisc().
 	noReturn = wasm.ValueType(wasm.BlockTypeEmpty)
 )
 
 // Op describes a WASM operator.
 type Op struct {
-	Code byte   // The single-byte opcode
+	Code byte   // The single-byte opcode, or MiscPrefix for the operators prefixed by it
 	Name string // The name of the operator
 
 	// Whether this operator is polymorphic.
@@ -64,6 +65,22 @@ func newPolymorphicOp(code byte, name string) byte {
 	return code
 }
 
+// newMiscOp registers the operator prefixed by MiscPrefix whose opcode,
+// following the prefix, is code.
+func newMiscOp(code byte, name string, args []wasm.ValueType, returns wasm.ValueType) byte {
+	if miscOps[code].IsValid() {
+		panic(fmt.Errorf("Opcode %#x %#x is already assigned to %s", MiscPrefix, code, miscOps[code].Name))
+	}
+
+	miscOps[code] = Op{
+		Code:    MiscPrefix,
+		Name:    name,
+		Args:    args,
+		Returns: returns,
+	}
+	return code
+}
+
 type InvalidOpcodeError byte
 
 func (e InvalidOpcodeError) Error() string {
@@ -84,3 +101,21 @@ func New(code byte) (Op, error) {
 	}
 	return op, nil
 }
+
+// InvalidMiscOpcodeError is returned by NewMisc when there is no operator
+// with the given opcode following MiscPrefix.
+type InvalidMiscOpcodeError uint32
+
+func (e InvalidMiscOpcodeError) Error() string {
+	return fmt.Sprintf("Invalid opcode: %#x %#x", MiscPrefix, uint32(e))
+}
+
+// NewMisc returns the Op object for the operator prefixed by MiscPrefix
+// whose opcode, following the prefix, is code.
+// If code is invalid, an InvalidMiscOpcodeError is returned.
+func NewMisc(code uint32) (Op, error) {
+	if code >= uint32(len(miscOps)) || !miscOps[code].IsValid() {
+		return Op{}, InvalidMiscOpcodeError(code)
+	}
+	return miscOps[code], nil
+}
@@ -6,6 +6,8 @@ package operators
 
 import (
 	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
 )
 
 func TestNew(t *testing.T) {
@@ -28,3 +30,22 @@ func TestNew(t *testing.T) {
 		t.Fatalf("0xff: operator %v is valid (should be invalid)", op2)
 	}
 }
+
+func TestNewMisc(t *testing.T) {
+	op, err := NewMisc(uint32(I64TruncSatUF64))
+	if err != nil {
+		t.Fatalf("unexpected error from NewMisc: %v", err)
+	}
+	if op.Code != MiscPrefix || op.Name != "i64.trunc_u:sat/f64" {
+		t.Fatalf("0xfc 0x07: unexpected Op: %v", op)
+	}
+	if len(op.Args) != 1 || op.Args[0] != wasm.ValueTypeF64 || op.Returns != wasm.ValueTypeI64 {
+		t.Fatalf("0xfc 0x07: unexpected types: args=%v, returns=%v", op.Args, op.Returns)
+	}
+
+	for _, code := range []uint32{0x08, 0x100} {
+		if _, err := NewMisc(code); err != InvalidMiscOpcodeError(code) {
+			t.Fatalf("%#x: unexpected error: got=%v, want=%v", code, err, InvalidMiscOpcodeError(code))
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"sort"
 
 	"github.com/go-interpreter/wagon/wasm/internal/readpos"
 	"github.com/go-interpreter/wagon/wasm/leb128"
@@ -30,6 +32,10 @@ const (
 	SectionIDElement  SectionID = 9
 	SectionIDCode     SectionID = 10
 	SectionIDData     SectionID = 11
+
+	// SectionIDDataCount is the ID of the data count section of the bulk
+	// memory proposal, which is not supported.
+	SectionIDDataCount SectionID = 12
 )
 
 func (s SectionID) String() string {
@@ -46,6 +52,8 @@ func (s SectionID) String() string {
 		SectionIDElement:  "element",
 		SectionIDCode:     "code",
 		SectionIDData:     "data",
+
+		SectionIDDataCount: "data count",
 	}[s]
 	if !ok {
 		return "unknown"
@@ -80,6 +88,18 @@ func (e InvalidCodeIndexError) Error() string {
 
 var ErrUnsupportedSection = errors.New("wasm: unsupported section")
 
+// SectionSizeMismatchError is returned by ReadModule when the contents of
+// a section don't match the size of its payload.
+type SectionSizeMismatchError struct {
+	ID   SectionID
+	Size uint32 // Size of the section's payload
+	Read uint32 // Number of bytes decoded from the payload
+}
+
+func (e SectionSizeMismatchError) Error() string {
+	return fmt.Sprintf("wasm: %s section has a payload of %d bytes, but %d were decoded", e.ID.String(), e.Size, e.Read)
+}
+
 type MissingSectionError SectionID
 
 func (e MissingSectionError) Error() string {
@@ -103,7 +123,7 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 
 	logger.Println("Reading payload length")
 	if s.PayloadLen, err = leb128.ReadVarUint32(r); err != nil {
-		return false, nil
+		return false, err
 	}
 
 	payloadDataLen := s.PayloadLen
@@ -113,6 +133,9 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+		if uint64(nameLenSize)+uint64(nameLen) > uint64(payloadDataLen) {
+			return false, SectionSizeMismatchError{ID: s.ID, Size: s.PayloadLen, Read: uint32(nameLenSize) + nameLen}
+		}
 		payloadDataLen -= uint32(nameLenSize)
 		if s.Name, err = readString(r, int(nameLen)); err != nil {
 			return false, err
@@ -127,76 +150,93 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 
 	sectionBytes := new(bytes.Buffer)
 	sectionBytes.Grow(int(payloadDataLen))
-	sectionReader := io.LimitReader(io.TeeReader(r, sectionBytes), int64(payloadDataLen))
+	sectionReader := &io.LimitedReader{R: io.TeeReader(r, sectionBytes), N: int64(payloadDataLen)}
 
+	var sec *Section // where to store s once it's completely read
 	switch s.ID {
 	case SectionIDCustom:
 		logger.Println("section custom")
-		// TODO: Read custom sections
+		// custom sections are decoded from s.Bytes, see readSectionCustom
+		_, err = io.Copy(ioutil.Discard, sectionReader)
 	case SectionIDType:
 		logger.Println("section type")
 		if err = m.readSectionTypes(sectionReader); err == nil {
-			m.Types.Section = s
+			sec = &m.Types.Section
 		}
 	case SectionIDImport:
 		logger.Println("section import")
 		if err = m.readSectionImports(sectionReader); err == nil {
-			m.Import.Section = s
+			sec = &m.Import.Section
 		}
 	case SectionIDFunction:
 		logger.Println("section function")
 		if err = m.readSectionFunctions(sectionReader); err == nil {
-			m.Function.Section = s
+			sec = &m.Function.Section
 		}
 	case SectionIDTable:
 		logger.Println("section table")
 		if err = m.readSectionTables(sectionReader); err == nil {
-			m.Table.Section = s
+			sec = &m.Table.Section
 		}
 	case SectionIDMemory:
 		logger.Println("section memory")
 		if err = m.readSectionMemories(sectionReader); err == nil {
-			m.Memory.Section = s
+			sec = &m.Memory.Section
 		}
 	case SectionIDGlobal:
 		logger.Println("section global")
 		if err = m.readSectionGlobals(sectionReader); err == nil {
-			m.Global.Section = s
+			sec = &m.Global.Section
 		}
 	case SectionIDExport:
 		logger.Println("section export")
 		if err = m.readSectionExports(sectionReader); err == nil {
-			m.Export.Section = s
+			sec = &m.Export.Section
 		}
 	case SectionIDStart:
 		logger.Println("section start")
 		if err = m.readSectionStart(sectionReader); err == nil {
-			m.Start.Section = s
+			sec = &m.Start.Section
 		}
 	case SectionIDElement:
 		logger.Println("section element")
 		if err = m.readSectionElements(sectionReader); err == nil {
-			m.Elements.Section = s
+			sec = &m.Elements.Section
 		}
 	case SectionIDCode:
 		logger.Println("section code")
-		if err = m.readSectionCode(sectionReader); err == nil {
-			m.Code.Section = s
+		// the offsets of the function bodies are relative to the module.
+		codeReader := &readpos.ReadPos{R: sectionReader, CurPos: s.Start}
+		if err = m.readSectionCode(codeReader); err == nil {
+			sec = &m.Code.Section
 		}
 	case SectionIDData:
 		logger.Println("section data")
 		if err = m.readSectionData(sectionReader); err == nil {
-			m.Data.Section = s
+			sec = &m.Data.Section
 		}
+	case SectionIDDataCount:
+		return false, m.features.Check(FeatureBulkMemory)
 	default:
 		return false, InvalidSectionIDError(s.ID)
 	}
 
+	if err != nil {
+		logger.Println(err)
+		return false, err
+	}
+	if sectionReader.N != 0 {
+		return false, SectionSizeMismatchError{ID: s.ID, Size: payloadDataLen, Read: payloadDataLen - uint32(sectionReader.N)}
+	}
+
 	s.End = r.CurPos
 	s.Bytes = sectionBytes.Bytes()
 
-	logger.Println(err)
-	return false, err
+	if s.ID == SectionIDCustom {
+		return false, m.readSectionCustom(s)
+	}
+	*sec = s
+	return false, nil
 }
 
 // SectionTypes declares all function signatures that will be used in a module.
@@ -218,7 +258,11 @@ func (m *Module) readSectionTypes(r io.Reader) error {
 		if s.Entries[i], err = readFunction(r); err != nil {
 			return err
 		}
-
+		if len(s.Entries[i].ReturnTypes) > 1 {
+			if err := m.features.Check(FeatureMultiValue); err != nil {
+				return err
+			}
+		}
 	}
 
 	m.Types = s
@@ -245,6 +289,11 @@ func (m *Module) readSectionImports(r io.Reader) error {
 		if err != nil {
 			return err
 		}
+		if g, ok := s.Entries[i].Type.(GlobalVarImport); ok && g.Type.Mutable {
+			if err := m.features.Check(FeatureMutableGlobals); err != nil {
+				return err
+			}
+		}
 	}
 
 	m.Import = s
@@ -446,12 +495,15 @@ func readGlobalEntry(r io.Reader) (e GlobalEntry, err error) {
 type SectionExports struct {
 	Section
 	Entries map[string]ExportEntry
+	// Names of the entries, in the order they appear in the section.
+	// WriteModule writes the entries in this order.
+	Names []string
 }
 
 type DuplicateExportError string
 
 func (e DuplicateExportError) Error() string {
-	return fmt.Sprintf("Duplicate export entry: %s", e)
+	return fmt.Sprintf("Duplicate export entry: %s", string(e))
 }
 
 func (m *Module) readSectionExports(r io.Reader) error {
@@ -472,12 +524,37 @@ func (m *Module) readSectionExports(r io.Reader) error {
 			return DuplicateExportError(entry.FieldStr)
 		}
 		s.Entries[entry.FieldStr] = entry
+		s.Names = append(s.Names, entry.FieldStr)
 	}
 
 	m.Export = s
 	return nil
 }
 
+// OrderedNames returns the names of the exported entries, in the order
+// given by s.Names, followed by the names missing from s.Names in lexical
+// order. This is the order in which WriteModule encodes the entries.
+func (s *SectionExports) OrderedNames() []string {
+	names := make([]string, 0, len(s.Entries))
+	listed := make(map[string]bool, len(s.Names))
+	for _, name := range s.Names {
+		if _, ok := s.Entries[name]; ok && !listed[name] {
+			names = append(names, name)
+			listed[name] = true
+		}
+	}
+
+	var missing []string
+	for name := range s.Entries {
+		if !listed[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+
+	return append(names, missing...)
+}
+
 // ExportEntry represents an exported entry by the module
 type ExportEntry struct {
 	FieldStr string
@@ -587,7 +664,7 @@ type SectionCode struct {
 	Bodies []FunctionBody
 }
 
-func (m *Module) readSectionCode(r io.Reader) error {
+func (m *Module) readSectionCode(r *readpos.ReadPos) error {
 	s := &SectionCode{}
 
 	count, err := leb128.ReadVarUint32(r)
@@ -626,9 +703,13 @@ type FunctionBody struct {
 	Module *Module // The parent module containing this function body, for execution purposes
 	Locals []LocalEntry
 	Code   []byte
+
+	// Offset is the byte offset of Code from the start of the module's
+	// binary, if the body was read by ReadModule.
+	Offset int64
 }
 
-func readFunctionBody(r io.Reader) (FunctionBody, error) {
+func readFunctionBody(r *readpos.ReadPos) (FunctionBody, error) {
 	f := FunctionBody{}
 
 	bodySize, err := leb128.ReadVarUint32(r)
@@ -666,6 +747,7 @@ func readFunctionBody(r io.Reader) (FunctionBody, error) {
 	}
 
 	f.Code = code[:len(code)-1]
+	f.Offset = r.CurPos - int64(len(code))
 
 	return f, nil
 }
@@ -743,3 +825,156 @@ func readDataSegment(r io.Reader) (DataSegment, error) {
 
 	return s, err
 }
+
+// CustomSection is a custom section of a module.
+type CustomSection struct {
+	Section
+	// Value is the value returned by the decoder given to ReadModule for
+	// the section's name (see DecodeCustomSection), if any.
+	Value interface{}
+}
+
+// CustomSectionDecoder decodes the payload of a custom section.
+type CustomSectionDecoder func(payload []byte) (interface{}, error)
+
+func (m *Module) readSectionCustom(s Section) error {
+	c := &CustomSection{Section: s}
+
+	// The name section is only used for debugging: as any custom section,
+	// it is dropped when malformed rather than failing the whole module.
+	if s.Name == "name" {
+		if err := m.readSectionName(s.Bytes); err != nil {
+			logger.Printf("ignoring malformed name section: %v", err)
+		} else {
+			m.Name.Section = s
+		}
+	}
+
+	if decode := m.decoders[s.Name]; decode != nil {
+		v, err := decode(s.Bytes)
+		if err != nil {
+			return err
+		}
+		c.Value = v
+	}
+
+	m.Customs = append(m.Customs, c)
+	m.Other = append(m.Other, s)
+	return nil
+}
+
+// SectionName is the custom section named "name", which assigns names to
+// the module, its functions and their local variables, for debugging
+// purposes. See https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#name-section
+type SectionName struct {
+	Section
+	ModuleName string
+	Functions  NameMap            // names of functions, by index into the function index space
+	Locals     map[uint32]NameMap // names of local variables, by function index
+}
+
+// NameMap maps indices to names.
+type NameMap map[uint32]string
+
+// Subsection IDs of the name section
+const (
+	nameModule   = 0
+	nameFunction = 1
+	nameLocal    = 2
+)
+
+// readSectionName decodes the payload of the name section. Counts and
+// lengths are checked against the bytes left in the payload, so that a
+// malformed section can not make it allocate more than its own size.
+func (m *Module) readSectionName(payload []byte) error {
+	s := &SectionName{}
+	r := bytes.NewReader(payload)
+
+	for r.Len() > 0 {
+		id, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return err
+		}
+		size, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return err
+		}
+		if int64(size) > int64(r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+
+		sub := bytes.NewReader(payload[len(payload)-r.Len():][:size])
+		switch id {
+		case nameModule:
+			s.ModuleName, err = readName(sub)
+		case nameFunction:
+			s.Functions, err = readNameMap(sub)
+		case nameLocal:
+			s.Locals, err = readLocalNames(sub)
+		}
+		if err != nil {
+			return err
+		}
+
+		// skip to the next subsection, ignoring unknown ones
+		if _, err = r.Seek(int64(size), io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+
+	m.Name = s
+	return nil
+}
+
+// readName reads a name of the name section, prefixed by its length.
+func readName(r *bytes.Reader) (string, error) {
+	nameLen, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return "", err
+	}
+	if int64(nameLen) > int64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	return readString(r, int(nameLen))
+}
+
+func readNameMap(r *bytes.Reader) (NameMap, error) {
+	count, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// count is read from the input, don't size the map after it
+	names := make(NameMap)
+	for i := uint32(0); i < count; i++ {
+		index, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if names[index], err = readName(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+func readLocalNames(r *bytes.Reader) (map[uint32]NameMap, error) {
+	count, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	locals := make(map[uint32]NameMap)
+	for i := uint32(0); i < count; i++ {
+		index, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if locals[index], err = readNameMap(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return locals, nil
+}
@@ -0,0 +1,7 @@
+(module
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (get_local 0) (get_local 1)))
+  (func (export "fmul") (param f32 f32) (result f32)
+    (f32.mul (get_local 0) (get_local 1)))
+  (global (export "g") i32 (i32.const 42))
+)
@@ -0,0 +1,7 @@
+(module $names
+  (func $add (export "add") (param $a i32) (param $b i32) (result i32)
+    (i32.add (get_local $a) (get_local $b)))
+  (func $nop (nop))
+  ;; followed by a custom section "foo", and an unknown subsection in the
+  ;; name section.
+)
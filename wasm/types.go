@@ -44,22 +44,67 @@ func readValueType(r io.Reader) (ValueType, error) {
 	return ValueType(v), err
 }
 
-// BlockType represents the signature of a structured block
-type BlockType ValueType // varint7
+// BlockType represents the signature of a structured block. It is either
+// BlockTypeEmpty, the ValueType of the single result of the block, or, with
+// the multi-value extension, a non-negative index into the type section
+// whose function type gives the parameters and results of the block.
+type BlockType int32 // varint33
+
+// BlockTypeEmpty is the signature of blocks with no parameter and no result.
 const BlockTypeEmpty BlockType = -0x40
 
-func readBlockType(r io.Reader) (BlockType, error) {
+// ReadBlockType reads the signature of a structured block from r.
+func ReadBlockType(r io.Reader) (BlockType, error) {
 	b, err := leb128.ReadVarint32(r)
 	return BlockType(b), err
 }
 
+// TypeIndex returns the index into the type section of a multi-value block
+// signature. ok is false if b is not a type index.
+func (b BlockType) TypeIndex() (index uint32, ok bool) {
+	if b < 0 {
+		return 0, false
+	}
+	return uint32(b), true
+}
+
 func (b BlockType) String() string {
 	if b == BlockTypeEmpty {
 		return "<empty block>"
 	}
+	if index, ok := b.TypeIndex(); ok {
+		return fmt.Sprintf("type %d", index)
+	}
 	return ValueType(b).String()
 }
 
+// InvalidBlockTypeError is returned when a block signature is neither empty,
+// a value type nor a type index.
+type InvalidBlockTypeError BlockType
+
+func (e InvalidBlockTypeError) Error() string {
+	return fmt.Sprintf("wasm: invalid block type %d", int32(e))
+}
+
+// BlockSignature returns the function type of the block signature b: the
+// type at the index b for multi-value blocks, or a type with no parameter
+// and zero or one result otherwise.
+func (m *Module) BlockSignature(b BlockType) (FunctionSig, error) {
+	if index, ok := b.TypeIndex(); ok {
+		if m.Types == nil || int(index) >= len(m.Types.Entries) {
+			return FunctionSig{}, InvalidTypeIndexError(index)
+		}
+		return m.Types.Entries[index], nil
+	}
+	switch t := ValueType(b); {
+	case b == BlockTypeEmpty:
+		return FunctionSig{Form: int8(TypeFunc)}, nil
+	case BlockType(t) == b && valueTypeStrMap[t] != "":
+		return FunctionSig{Form: int8(TypeFunc), ReturnTypes: []ValueType{t}}, nil
+	}
+	return FunctionSig{}, InvalidBlockTypeError(b)
+}
+
 // ElemType describes the type of a table's elements
 type ElemType int // varint7
 // ElemTypeAnyFunc descibres an any_func value
@@ -91,6 +136,24 @@ func (f FunctionSig) String() string {
 	return fmt.Sprintf("<func %v -> %v>", f.ParamTypes, f.ReturnTypes)
 }
 
+// Equal reports whether f and g have the same parameter and return types.
+func (f FunctionSig) Equal(g FunctionSig) bool {
+	if len(f.ParamTypes) != len(g.ParamTypes) || len(f.ReturnTypes) != len(g.ReturnTypes) {
+		return false
+	}
+	for i := range f.ParamTypes {
+		if f.ParamTypes[i] != g.ParamTypes[i] {
+			return false
+		}
+	}
+	for i := range f.ReturnTypes {
+		if f.ReturnTypes[i] != g.ReturnTypes[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type InvalidTypeConstructorError struct {
 	Wanted int
 	Got    int
@@ -0,0 +1,260 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/go-interpreter/wagon/wasm/leb128"
+)
+
+// WriteModule writes the binary encoding of the module m to w.
+// The known sections are written in the order mandated by the spec.
+// Custom sections read by ReadModule are written back at their original
+// position, relative to the known sections, while custom sections added
+// to Module.Customs are written last. Custom sections, including the name
+// section, are written from their raw payload (Section.Bytes).
+func WriteModule(w io.Writer, m *Module) error {
+	buf := new(bytes.Buffer)
+	writeU32(buf, Magic)
+	writeU32(buf, m.Version)
+
+	customs := m.Customs
+	for _, s := range m.knownSections() {
+		// custom sections preceding this section in the original module
+		for len(customs) > 0 && customs[0].End != 0 && s.section.Start != 0 && customs[0].Start < s.section.Start {
+			writeSectionCustom(buf, customs[0])
+			customs = customs[1:]
+		}
+
+		payload := new(bytes.Buffer)
+		s.write(payload)
+		writeVarUint32(buf, uint32(s.id))
+		writeVarUint32(buf, uint32(payload.Len()))
+		buf.Write(payload.Bytes())
+	}
+	for _, c := range customs {
+		writeSectionCustom(buf, c)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+type sectionWriter struct {
+	id      SectionID
+	section *Section
+	write   func(buf *bytes.Buffer)
+}
+
+// knownSections returns the known sections of m, in the order they must
+// appear in the binary encoding.
+func (m *Module) knownSections() []sectionWriter {
+	var sections []sectionWriter
+	if m.Types != nil {
+		sections = append(sections, sectionWriter{SectionIDType, &m.Types.Section, m.writeSectionTypes})
+	}
+	if m.Import != nil {
+		sections = append(sections, sectionWriter{SectionIDImport, &m.Import.Section, m.writeSectionImports})
+	}
+	if m.Function != nil {
+		sections = append(sections, sectionWriter{SectionIDFunction, &m.Function.Section, m.writeSectionFunctions})
+	}
+	if m.Table != nil {
+		sections = append(sections, sectionWriter{SectionIDTable, &m.Table.Section, m.writeSectionTables})
+	}
+	if m.Memory != nil {
+		sections = append(sections, sectionWriter{SectionIDMemory, &m.Memory.Section, m.writeSectionMemories})
+	}
+	if m.Global != nil {
+		sections = append(sections, sectionWriter{SectionIDGlobal, &m.Global.Section, m.writeSectionGlobals})
+	}
+	if m.Export != nil {
+		sections = append(sections, sectionWriter{SectionIDExport, &m.Export.Section, m.writeSectionExports})
+	}
+	if m.Start != nil {
+		sections = append(sections, sectionWriter{SectionIDStart, &m.Start.Section, m.writeSectionStart})
+	}
+	if m.Elements != nil {
+		sections = append(sections, sectionWriter{SectionIDElement, &m.Elements.Section, m.writeSectionElements})
+	}
+	if m.Code != nil {
+		sections = append(sections, sectionWriter{SectionIDCode, &m.Code.Section, m.writeSectionCode})
+	}
+	if m.Data != nil {
+		sections = append(sections, sectionWriter{SectionIDData, &m.Data.Section, m.writeSectionData})
+	}
+	return sections
+}
+
+func writeSectionCustom(buf *bytes.Buffer, c *CustomSection) {
+	payload := new(bytes.Buffer)
+	writeString(payload, c.Name)
+	payload.Write(c.Bytes)
+
+	writeVarUint32(buf, uint32(SectionIDCustom))
+	writeVarUint32(buf, uint32(payload.Len()))
+	buf.Write(payload.Bytes())
+}
+
+func (m *Module) writeSectionTypes(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Types.Entries)))
+	for _, sig := range m.Types.Entries {
+		writeVarint(buf, int64(sig.Form))
+		writeVarUint32(buf, uint32(len(sig.ParamTypes)))
+		for _, t := range sig.ParamTypes {
+			writeVarint(buf, int64(t))
+		}
+		writeVarUint32(buf, uint32(len(sig.ReturnTypes)))
+		for _, t := range sig.ReturnTypes {
+			writeVarint(buf, int64(t))
+		}
+	}
+}
+
+func (m *Module) writeSectionImports(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Import.Entries)))
+	for _, entry := range m.Import.Entries {
+		writeString(buf, entry.ModuleName)
+		writeString(buf, entry.FieldName)
+		buf.WriteByte(byte(entry.Kind))
+		switch typ := entry.Type.(type) {
+		case FuncImport:
+			writeVarUint32(buf, typ.Type)
+		case TableImport:
+			writeTable(buf, typ.Type)
+		case MemoryImport:
+			writeResizableLimits(buf, typ.Type.Limits)
+		case GlobalVarImport:
+			writeGlobalVar(buf, typ.Type)
+		}
+	}
+}
+
+func (m *Module) writeSectionFunctions(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Function.Types)))
+	for _, t := range m.Function.Types {
+		writeVarUint32(buf, t)
+	}
+}
+
+func (m *Module) writeSectionTables(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Table.Entries)))
+	for _, t := range m.Table.Entries {
+		writeTable(buf, t)
+	}
+}
+
+func (m *Module) writeSectionMemories(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Memory.Entries)))
+	for _, mem := range m.Memory.Entries {
+		writeResizableLimits(buf, mem.Limits)
+	}
+}
+
+func (m *Module) writeSectionGlobals(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Global.Globals)))
+	for _, g := range m.Global.Globals {
+		writeGlobalVar(buf, *g.Type)
+		buf.Write(g.Init)
+	}
+}
+
+func (m *Module) writeSectionExports(buf *bytes.Buffer) {
+	names := m.Export.OrderedNames()
+	writeVarUint32(buf, uint32(len(names)))
+	for _, name := range names {
+		entry := m.Export.Entries[name]
+		writeString(buf, name)
+		buf.WriteByte(byte(entry.Kind))
+		writeVarUint32(buf, entry.Index)
+	}
+}
+
+func (m *Module) writeSectionStart(buf *bytes.Buffer) {
+	writeVarUint32(buf, m.Start.Index)
+}
+
+func (m *Module) writeSectionElements(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Elements.Entries)))
+	for _, e := range m.Elements.Entries {
+		writeVarUint32(buf, e.Index)
+		buf.Write(e.Offset)
+		writeVarUint32(buf, uint32(len(e.Elems)))
+		for _, index := range e.Elems {
+			writeVarUint32(buf, index)
+		}
+	}
+}
+
+func (m *Module) writeSectionCode(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Code.Bodies)))
+	for _, b := range m.Code.Bodies {
+		body := new(bytes.Buffer)
+		writeVarUint32(body, uint32(len(b.Locals)))
+		for _, l := range b.Locals {
+			writeVarUint32(body, l.Count)
+			writeVarint(body, int64(l.Type))
+		}
+		body.Write(b.Code)
+		body.WriteByte(end)
+
+		writeVarUint32(buf, uint32(body.Len()))
+		buf.Write(body.Bytes())
+	}
+}
+
+func (m *Module) writeSectionData(buf *bytes.Buffer) {
+	writeVarUint32(buf, uint32(len(m.Data.Entries)))
+	for _, d := range m.Data.Entries {
+		writeVarUint32(buf, d.Index)
+		buf.Write(d.Offset)
+		writeVarUint32(buf, uint32(len(d.Data)))
+		buf.Write(d.Data)
+	}
+}
+
+func writeTable(buf *bytes.Buffer, t Table) {
+	writeVarint(buf, int64(t.ElementType))
+	writeResizableLimits(buf, t.Limits)
+}
+
+func writeGlobalVar(buf *bytes.Buffer, g GlobalVar) {
+	writeVarint(buf, int64(g.Type))
+	if g.Mutable {
+		writeVarUint32(buf, 1)
+	} else {
+		writeVarUint32(buf, 0)
+	}
+}
+
+func writeResizableLimits(buf *bytes.Buffer, l ResizableLimits) {
+	writeVarUint32(buf, l.Flags)
+	writeVarUint32(buf, l.Initial)
+	if l.Flags&0x1 != 0 {
+		writeVarUint32(buf, l.Maximum)
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeVarUint32(buf, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func writeU32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeVarUint32(buf *bytes.Buffer, v uint32) {
+	buf.Write(leb128.AppendVarUint32(nil, v))
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	buf.Write(leb128.AppendVarint64(nil, v))
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestWriteModule(t *testing.T) {
+	var fnames []string
+	for _, dir := range []string{"testdata", filepath.Join("..", "exec", "testdata")} {
+		names, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fnames = append(fnames, names...)
+	}
+
+	// env.wasm exports the functions and global imported by the modules
+	// of exec/testdata.
+	env, err := ioutil.ReadFile(filepath.Join("testdata", "env.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(string) (*wasm.Module, error) {
+		return wasm.ReadModule(bytes.NewReader(env), nil, wasm.AllFeatures)
+	}
+
+	for _, fname := range fnames {
+		name := fname
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			raw, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := wasm.ReadModule(bytes.NewReader(raw), resolve, wasm.AllFeatures)
+			if err != nil {
+				t.Fatalf("error reading module: %v", err)
+			}
+
+			buf := new(bytes.Buffer)
+			if err = wasm.WriteModule(buf, m); err != nil {
+				t.Fatalf("error writing module: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), raw) {
+				t.Fatalf("written module differs from the original:\ngot= %x\nwant=%x", buf.Bytes(), raw)
+			}
+		})
+	}
+}
+
+func TestWriteModifiedModule(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "names.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.ReadModule(bytes.NewReader(raw), nil, wasm.AllFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Export.Entries["nop"] = wasm.ExportEntry{FieldStr: "nop", Kind: wasm.ExternalFunction, Index: 1}
+	m.Customs = append(m.Customs, &wasm.CustomSection{Section: wasm.Section{Name: "bar", Bytes: []byte{4}}})
+
+	buf := new(bytes.Buffer)
+	if err = wasm.WriteModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.ReadModule(buf, nil, wasm.AllFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"add", "nop"}; len(m.Export.Names) != 2 || m.Export.Names[0] != want[0] || m.Export.Names[1] != want[1] {
+		t.Errorf("unexpected exports: got=%v, want=%v", m.Export.Names, want)
+	}
+	var customs []string
+	for _, c := range m.Customs {
+		customs = append(customs, c.Name)
+	}
+	if len(customs) != 3 || customs[2] != "bar" || !bytes.Equal(m.Customs[2].Bytes, []byte{4}) {
+		t.Errorf("unexpected custom sections: %v", customs)
+	}
+}
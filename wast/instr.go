@@ -0,0 +1,555 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// opcodes maps the names of the operators to their opcode.
+var opcodes = make(map[string]byte)
+
+// miscOpcodes maps the names of the operators prefixed by ops.MiscPrefix
+// to their opcode following the prefix.
+var miscOpcodes = make(map[string]uint32)
+
+// renamed maps the operator names used by wagon to their names in the
+// current version of the text format, when they can't be derived from the
+// old name.
+var renamed = map[string]string{
+	"get_local":      "local.get",
+	"set_local":      "local.set",
+	"tee_local":      "local.tee",
+	"get_global":     "global.get",
+	"set_global":     "global.set",
+	"current_memory": "memory.size",
+	"grow_memory":    "memory.grow",
+}
+
+func init() {
+	for code := 0; code < 256; code++ {
+		op, err := ops.New(byte(code))
+		if err != nil {
+			continue
+		}
+		opcodes[op.Name] = op.Code
+		if name := newName(op.Name); name != "" {
+			opcodes[name] = op.Code
+		}
+	}
+	for code := uint32(0); code < 256; code++ {
+		op, err := ops.NewMisc(code)
+		if err != nil {
+			continue
+		}
+		miscOpcodes[op.Name] = code
+		if name := newName(op.Name); name != "" {
+			miscOpcodes[name] = code
+		}
+	}
+}
+
+// opcode returns the encoding of the opcode of the operator name, and
+// its first byte.
+func opcode(name string) ([]byte, byte, bool) {
+	if code, ok := opcodes[name]; ok {
+		return []byte{code}, code, true
+	}
+	if code, ok := miscOpcodes[name]; ok {
+		return leb128.AppendVarUint32([]byte{ops.MiscPrefix}, code), ops.MiscPrefix, true
+	}
+	return nil, 0, false
+}
+
+// newName returns the current name of the operator with the old name
+// name, or "" if it wasn't renamed. Conversion operators, like
+// i32.trunc_s/f32 and i32.trunc_s:sat/f32, are now named i32.trunc_f32_s
+// and i32.trunc_sat_f32_s.
+func newName(name string) string {
+	if n, ok := renamed[name]; ok {
+		return n
+	}
+	slash := strings.IndexByte(name, '/')
+	if slash < 0 {
+		return ""
+	}
+	op, from := name[:slash], name[slash+1:]
+	sat := ""
+	if strings.HasSuffix(op, ":sat") {
+		op, sat = op[:len(op)-len(":sat")], "_sat"
+	}
+	if strings.HasSuffix(op, "_s") || strings.HasSuffix(op, "_u") {
+		return op[:len(op)-2] + sat + "_" + from + op[len(op)-2:]
+	}
+	return op + "_" + from
+}
+
+// blockTypeEmpty is the encoding of wasm.BlockTypeEmpty.
+const blockTypeEmpty = 0x40
+
+// label is a structured control instruction enclosing the instructions
+// being compiled.
+type label struct {
+	name string // identifier of the label, may be empty
+	pos  Pos
+	op   byte // block, loop or if
+	els  bool // whether the else instruction of an if was seen
+}
+
+// compiler compiles instructions to their binary encoding.
+type compiler struct {
+	b      *builder
+	locals *space
+	labels []label
+	base   int // number of labels that can't be ended by a plain end
+	code   []byte
+}
+
+func (b *builder) newCompiler() *compiler {
+	return &compiler{b: b, locals: newSpace("local")}
+}
+
+// compileFunc compiles the body of the function fn.
+func (b *builder) compileFunc(fn *funcDef) (*wasm.FunctionBody, error) {
+	c := b.newCompiler()
+	for _, p := range fn.params {
+		if _, err := c.locals.add(p); err != nil {
+			return nil, err
+		}
+	}
+
+	var locals []wasm.LocalEntry
+	addLocal := func(name, n *node) error {
+		t, err := valueType(n)
+		if err != nil {
+			return err
+		}
+		if _, err := c.locals.add(name); err != nil {
+			return err
+		}
+		if l := len(locals); l > 0 && locals[l-1].Type == t {
+			locals[l-1].Count++
+		} else {
+			locals = append(locals, wasm.LocalEntry{Count: 1, Type: t})
+		}
+		return nil
+	}
+
+	items := fn.body
+	i := 0
+	for ; i < len(items) && items[i].head() == "local"; i++ {
+		args := items[i].args()
+		if name, _ := id(args, 0); name != nil {
+			if len(args) != 2 {
+				return nil, errorf(items[i].pos, "expected one type for named local")
+			}
+			if err := addLocal(name, args[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, a := range args {
+			if err := addLocal(nil, a); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := c.instrs(items[i:]); err != nil {
+		return nil, err
+	}
+	if err := c.closed(); err != nil {
+		return nil, err
+	}
+	return &wasm.FunctionBody{Locals: locals, Code: c.code}, nil
+}
+
+// constExpr compiles the initializer expression expr, terminated by end.
+func (b *builder) constExpr(expr []*node, pos Pos) ([]byte, error) {
+	if len(expr) == 0 {
+		return nil, errorf(pos, "expected initializer expression")
+	}
+	c := b.newCompiler()
+	if err := c.instrs(expr); err != nil {
+		return nil, err
+	}
+	if err := c.closed(); err != nil {
+		return nil, err
+	}
+	return append(c.code, ops.End), nil
+}
+
+// closed returns an error if a block is still open.
+func (c *compiler) closed() error {
+	if len(c.labels) > c.base {
+		l := c.labels[len(c.labels)-1]
+		return errorf(l.pos, "unclosed %s", opName(l.op))
+	}
+	return nil
+}
+
+func opName(code byte) string {
+	op, _ := ops.New(code)
+	return op.Name
+}
+
+// instrs compiles a sequence of instructions, in flat or folded form.
+func (c *compiler) instrs(items []*node) error {
+	for i := 0; i < len(items); {
+		n := items[i]
+		i++
+		if n.isList {
+			if err := c.folded(n); err != nil {
+				return err
+			}
+			continue
+		}
+		if !n.isAtom() {
+			return errorf(n.pos, "expected instruction, got %v", n)
+		}
+
+		var err error
+		switch name := n.tok.text; name {
+		case "block", "loop", "if":
+			var l label
+			var bt []byte
+			if l, bt, i, err = c.blockType(items, i); err != nil {
+				return err
+			}
+			l.pos, l.op = n.pos, opcodes[name]
+			c.labels = append(c.labels, l)
+			c.code = append(append(c.code, l.op), bt...)
+		case "else", "end":
+			if len(c.labels) <= c.base {
+				return errorf(n.pos, "unexpected %s", name)
+			}
+			l := &c.labels[len(c.labels)-1]
+			if name == "else" {
+				if l.op != ops.If || l.els {
+					return errorf(n.pos, "unexpected else")
+				}
+				l.els = true
+			}
+			if end, next := id(items, i); end != nil {
+				if end.tok.text != l.name {
+					return errorf(end.pos, "mismatching label %s", end.tok.text)
+				}
+				i = next
+			}
+			if name == "end" {
+				c.labels = c.labels[:len(c.labels)-1]
+			}
+			c.code = append(c.code, opcodes[name])
+		default:
+			enc, code, ok := opcode(name)
+			if !ok {
+				return errorf(n.pos, "unknown operator %s", name)
+			}
+			var imm []byte
+			if imm, i, err = c.immediates(code, items, i, n.pos); err != nil {
+				return err
+			}
+			c.code = append(append(c.code, enc...), imm...)
+		}
+	}
+	return nil
+}
+
+// folded compiles the folded instruction n.
+func (c *compiler) folded(n *node) error {
+	items := n.args()
+	switch name := n.head(); name {
+	case "block", "loop":
+		l, bt, i, err := c.blockType(items, 0)
+		if err != nil {
+			return err
+		}
+		l.pos, l.op = n.pos, opcodes[name]
+		c.code = append(append(c.code, l.op), bt...)
+		return c.nested(l, items[i:], nil)
+	case "if":
+		l, bt, i, err := c.blockType(items, 0)
+		if err != nil {
+			return err
+		}
+		l.pos, l.op = n.pos, ops.If
+
+		cond := i
+		for i < len(items) && items[i].head() != "then" {
+			i++
+		}
+		if i == len(items) {
+			return errorf(n.pos, "expected (then ...) in if")
+		}
+		if err := c.instrs(items[cond:i]); err != nil {
+			return err
+		}
+		then := items[i].args()
+		var els []*node
+		if i++; i < len(items) && items[i].head() == "else" {
+			els = items[i].args()
+			i++
+		}
+		if i != len(items) {
+			return errorf(items[i].pos, "unexpected %v in if", items[i])
+		}
+		c.code = append(append(c.code, ops.If), bt...)
+		return c.nested(l, then, els)
+	case "", "else", "end", "then":
+		return errorf(n.pos, "expected instruction, got %v", n)
+	default:
+		enc, code, ok := opcode(name)
+		if !ok {
+			return errorf(n.list[0].pos, "unknown operator %s", name)
+		}
+		imm, i, err := c.immediates(code, items, 0, n.pos)
+		if err != nil {
+			return err
+		}
+		for _, operand := range items[i:] {
+			if !operand.isList {
+				return errorf(operand.pos, "unexpected %v in folded %s", operand, name)
+			}
+		}
+		if err := c.instrs(items[i:]); err != nil {
+			return err
+		}
+		c.code = append(append(c.code, enc...), imm...)
+		return nil
+	}
+}
+
+// nested compiles the body of the folded block l, and its else branch
+// for if blocks.
+func (c *compiler) nested(l label, body, els []*node) error {
+	base := c.base
+	c.labels = append(c.labels, l)
+	c.base = len(c.labels)
+	defer func() { c.base = base }()
+
+	if err := c.instrs(body); err != nil {
+		return err
+	}
+	if err := c.closed(); err != nil {
+		return err
+	}
+	if els != nil {
+		c.code = append(c.code, ops.Else)
+		if err := c.instrs(els); err != nil {
+			return err
+		}
+		if err := c.closed(); err != nil {
+			return err
+		}
+	}
+	c.labels = c.labels[:len(c.labels)-1]
+	c.code = append(c.code, ops.End)
+	return nil
+}
+
+// blockType parses the optional label and the type of a block starting
+// at items[i], and returns its encoding. Blocks with parameters or
+// multiple results are encoded with the index of their function type.
+func (c *compiler) blockType(items []*node, i int) (label, []byte, int, error) {
+	var l label
+	if name, next := id(items, i); name != nil {
+		l.name, i = name.tok.text, next
+	}
+
+	if i < len(items) && (items[i].head() == "type" || items[i].head() == "param") {
+		index, _, next, err := c.b.typeUse(items, i)
+		if err != nil {
+			return l, nil, 0, err
+		}
+		return l, leb128.AppendVarint64(nil, int64(index)), next, nil
+	}
+
+	var results []*node
+	next := i
+	for ; next < len(items) && items[next].head() == "result"; next++ {
+		results = append(results, items[next].args()...)
+	}
+	switch len(results) {
+	case 0:
+		return l, []byte{blockTypeEmpty}, next, nil
+	case 1:
+		t, err := valueType(results[0])
+		if err != nil {
+			return l, nil, 0, err
+		}
+		return l, []byte{byte(t) & 0x7f}, next, nil // varint7
+	}
+	index, _, next, err := c.b.typeUse(items, i)
+	if err != nil {
+		return l, nil, 0, err
+	}
+	return l, leb128.AppendVarint64(nil, int64(index)), next, nil
+}
+
+// isIndex reports whether n may be a numeric or symbolic index.
+func isIndex(n *node) bool {
+	return n.isID() || (n.isAtom() && n.tok.text[0] >= '0' && n.tok.text[0] <= '9')
+}
+
+// label resolves the label n to its relative depth.
+func (c *compiler) label(n *node) (uint32, error) {
+	if !n.isID() {
+		return newSpace("label").index(n)
+	}
+	for i := len(c.labels) - 1; i >= 0; i-- {
+		if c.labels[i].name == n.tok.text {
+			return uint32(len(c.labels) - 1 - i), nil
+		}
+	}
+	return 0, errorf(n.pos, "unknown label %s", n.tok.text)
+}
+
+// immediates parses the immediate arguments of the operator code starting
+// at items[i], and returns their encoding.
+func (c *compiler) immediates(code byte, items []*node, i int, pos Pos) ([]byte, int, error) {
+	arg := func() (*node, error) {
+		if i >= len(items) || !items[i].isAtom() {
+			return nil, errorf(posAt(items, i, pos), "missing argument to %s", opName(code))
+		}
+		i++
+		return items[i-1], nil
+	}
+	index := func(s *space) ([]byte, int, error) {
+		n, err := arg()
+		if err != nil {
+			return nil, 0, err
+		}
+		v, err := s.index(n)
+		return leb128.AppendVarUint32(nil, v), i, err
+	}
+
+	switch {
+	case code == ops.Br || code == ops.BrIf:
+		n, err := arg()
+		if err != nil {
+			return nil, 0, err
+		}
+		depth, err := c.label(n)
+		return leb128.AppendVarUint32(nil, depth), i, err
+	case code == ops.BrTable:
+		var depths []uint32
+		for ; i < len(items) && isIndex(items[i]); i++ {
+			depth, err := c.label(items[i])
+			if err != nil {
+				return nil, 0, err
+			}
+			depths = append(depths, depth)
+		}
+		if len(depths) == 0 {
+			return nil, 0, errorf(posAt(items, i, pos), "missing argument to br_table")
+		}
+		imm := leb128.AppendVarUint32(nil, uint32(len(depths)-1))
+		for _, d := range depths {
+			imm = leb128.AppendVarUint32(imm, d)
+		}
+		return imm, i, nil
+	case code == ops.Call:
+		return index(c.b.funcs)
+	case code == ops.CallIndirect:
+		typ, _, next, err := c.b.typeUse(items, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		return append(leb128.AppendVarUint32(nil, typ), 0), next, nil
+	case code == ops.GetLocal || code == ops.SetLocal || code == ops.TeeLocal:
+		return index(c.locals)
+	case code == ops.GetGlobal || code == ops.SetGlobal:
+		return index(c.b.globals)
+	case code >= ops.I32Load && code <= ops.I64Store32:
+		return memarg(code, items, i)
+	case code == ops.CurrentMemory || code == ops.GrowMemory:
+		return []byte{0}, i, nil
+	}
+
+	var bits int
+	switch code {
+	case ops.I32Const, ops.F32Const:
+		bits = 32
+	case ops.I64Const, ops.F64Const:
+		bits = 64
+	default:
+		return nil, i, nil
+	}
+	n, err := arg()
+	if err != nil {
+		return nil, 0, err
+	}
+	switch code {
+	case ops.I32Const, ops.I64Const:
+		v, err := parseInt(n.tok.text, bits)
+		if err != nil {
+			return nil, 0, errorf(n.pos, "invalid i%d constant %s", bits, n.tok.text)
+		}
+		if bits == 32 {
+			return leb128.AppendVarint32(nil, int32(v)), i, nil
+		}
+		return leb128.AppendVarint64(nil, int64(v)), i, nil
+	default:
+		v, err := parseFloat(n.tok.text, bits)
+		if err != nil {
+			return nil, 0, errorf(n.pos, "invalid f%d constant %s", bits, n.tok.text)
+		}
+		imm := make([]byte, bits/8)
+		if bits == 32 {
+			binary.LittleEndian.PutUint32(imm, uint32(v))
+		} else {
+			binary.LittleEndian.PutUint64(imm, v)
+		}
+		return imm, i, nil
+	}
+}
+
+// memarg parses the optional offset= and align= arguments of the memory
+// operator code, starting at items[i].
+func memarg(code byte, items []*node, i int) ([]byte, int, error) {
+	var offset uint64
+	align := naturalAlignment(code)
+
+	if i < len(items) && items[i].isAtom() && strings.HasPrefix(items[i].tok.text, "offset=") {
+		var err error
+		if offset, err = parseUint(items[i].tok.text[len("offset="):], 32); err != nil {
+			return nil, 0, errorf(items[i].pos, "invalid offset %s", items[i].tok.text)
+		}
+		i++
+	}
+	if i < len(items) && items[i].isAtom() && strings.HasPrefix(items[i].tok.text, "align=") {
+		a, err := parseUint(items[i].tok.text[len("align="):], 32)
+		if err != nil || a == 0 || a&(a-1) != 0 {
+			return nil, 0, errorf(items[i].pos, "alignment must be a power of two")
+		}
+		for align = 0; a > 1; a >>= 1 {
+			align++
+		}
+		i++
+	}
+
+	imm := leb128.AppendVarUint32(nil, align)
+	return leb128.AppendVarUint32(imm, uint32(offset)), i, nil
+}
+
+// naturalAlignment returns the log2 of the number of bytes accessed by the
+// memory operator code.
+func naturalAlignment(code byte) uint32 {
+	switch code {
+	case ops.I32Load8s, ops.I32Load8u, ops.I64Load8s, ops.I64Load8u, ops.I32Store8, ops.I64Store8:
+		return 0
+	case ops.I32Load16s, ops.I32Load16u, ops.I64Load16s, ops.I64Load16u, ops.I32Store16, ops.I64Store16:
+		return 1
+	case ops.I64Load, ops.F64Load, ops.I64Store, ops.F64Store:
+		return 3
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Pos is a position in a source file.
+type Pos struct {
+	Line int // 1-based line number
+	Col  int // 1-based column number, in bytes
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Error is a syntax or semantic error in a source file.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("wast: %v: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos Pos, format string, args ...interface{}) error {
+	return Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokString
+	tokAtom // keywords, identifiers, numbers and reserved tokens
+)
+
+type token struct {
+	kind tokenKind
+	text string // for strings, the decoded value of the string
+	pos  Pos
+}
+
+type lexer struct {
+	src  []byte
+	off  int
+	line int
+	col  int
+}
+
+func newLexer(src []byte) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Line: l.line, Col: l.col}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if l.src[l.off] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.off++
+	}
+}
+
+func (l *lexer) peek(i int) byte {
+	if l.off+i >= len(l.src) {
+		return 0
+	}
+	return l.src[l.off+i]
+}
+
+// skip skips white space and comments.
+func (l *lexer) skip() error {
+	for l.off < len(l.src) {
+		switch c := l.src[l.off]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.advance(1)
+		case c == ';' && l.peek(1) == ';':
+			for l.off < len(l.src) && l.src[l.off] != '\n' {
+				l.advance(1)
+			}
+		case c == '(' && l.peek(1) == ';':
+			start := l.pos()
+			l.advance(2)
+			for depth := 1; depth > 0; {
+				switch {
+				case l.off >= len(l.src):
+					return errorf(start, "unterminated block comment")
+				case l.src[l.off] == '(' && l.peek(1) == ';':
+					depth++
+					l.advance(2)
+				case l.src[l.off] == ';' && l.peek(1) == ')':
+					depth--
+					l.advance(2)
+				default:
+					l.advance(1)
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// isIDChar reports whether c may appear in a keyword, identifier or
+// number.
+func isIDChar(c byte) bool {
+	switch {
+	case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '/', ':', '<', '=', '>', '?', '@', '\\', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	pos := l.pos()
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	switch c := l.src[l.off]; {
+	case c == '(':
+		l.advance(1)
+		return token{kind: tokLParen, pos: pos}, nil
+	case c == ')':
+		l.advance(1)
+		return token{kind: tokRParen, pos: pos}, nil
+	case c == '"':
+		s, err := l.string()
+		return token{kind: tokString, text: s, pos: pos}, err
+	case isIDChar(c):
+		start := l.off
+		for l.off < len(l.src) && isIDChar(l.src[l.off]) {
+			l.advance(1)
+		}
+		return token{kind: tokAtom, text: string(l.src[start:l.off]), pos: pos}, nil
+	default:
+		return token{}, errorf(pos, "unexpected character %q", c)
+	}
+}
+
+// string reads a string literal, and returns its decoded value.
+func (l *lexer) string() (string, error) {
+	start := l.pos()
+	l.advance(1) // opening quote
+
+	var b []byte
+	for {
+		if l.off >= len(l.src) || l.src[l.off] == '\n' {
+			return "", errorf(start, "unterminated string")
+		}
+		c := l.src[l.off]
+		switch {
+		case c == '"':
+			l.advance(1)
+			return string(b), nil
+		case c == '\\':
+			pos := l.pos()
+			l.advance(1)
+			switch e := l.peek(0); e {
+			case 'n':
+				b = append(b, '\n')
+				l.advance(1)
+			case 't':
+				b = append(b, '\t')
+				l.advance(1)
+			case 'r':
+				b = append(b, '\r')
+				l.advance(1)
+			case '\\', '\'', '"':
+				b = append(b, e)
+				l.advance(1)
+			case 'u':
+				l.advance(1)
+				end := l.off
+				for end < len(l.src) && l.src[end] != '}' && l.src[end] != '\n' {
+					end++
+				}
+				if l.peek(0) != '{' || end >= len(l.src) || l.src[end] != '}' {
+					return "", errorf(pos, "invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(string(l.src[l.off+1:end]), 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", errorf(pos, "invalid unicode escape")
+				}
+				var buf [utf8.UTFMax]byte
+				b = append(b, buf[:utf8.EncodeRune(buf[:], rune(r))]...)
+				l.advance(end + 1 - l.off)
+			default:
+				v, err := strconv.ParseUint(string(l.src[l.off:min(l.off+2, len(l.src))]), 16, 8)
+				if err != nil || l.off+2 > len(l.src) {
+					return "", errorf(pos, "invalid escape sequence")
+				}
+				b = append(b, byte(v))
+				l.advance(2)
+			}
+		default:
+			b = append(b, c)
+			l.advance(1)
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
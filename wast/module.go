@@ -0,0 +1,883 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+const wasmPageSize = 65536
+
+// space is an index space of the module (functions, tables, ...), mapping
+// symbolic identifiers to indices.
+type space struct {
+	what string // name of the indexed entities, for error messages
+	ids  map[string]uint32
+	n    uint32 // number of entities in the space
+}
+
+func newSpace(what string) *space {
+	return &space{what: what, ids: make(map[string]uint32)}
+}
+
+// add adds a new entity to the space, with the identifier id if it isn't
+// nil, and returns its index.
+func (s *space) add(id *node) (uint32, error) {
+	if id != nil {
+		if _, ok := s.ids[id.tok.text]; ok {
+			return 0, errorf(id.pos, "duplicate %s %s", s.what, id.tok.text)
+		}
+		s.ids[id.tok.text] = s.n
+	}
+	s.n++
+	return s.n - 1, nil
+}
+
+// index resolves the index n, which is either numeric or symbolic.
+func (s *space) index(n *node) (uint32, error) {
+	if !n.isAtom() {
+		return 0, errorf(n.pos, "expected %s index, got %v", s.what, n)
+	}
+	if n.isID() {
+		i, ok := s.ids[n.tok.text]
+		if !ok {
+			return 0, errorf(n.pos, "unknown %s %s", s.what, n.tok.text)
+		}
+		return i, nil
+	}
+	i, err := parseUint(n.tok.text, 32)
+	if err != nil {
+		return 0, errorf(n.pos, "invalid %s index %s", s.what, n.tok.text)
+	}
+	return uint32(i), nil
+}
+
+// builder builds a wasm.Module from the fields of a module in the text
+// format.
+type builder struct {
+	m   *wasm.Module
+	pos Pos // position of the module
+
+	types, funcs, tables, mems, globals *space
+
+	defined bool // whether a function, table, memory or global was defined
+
+	bodies      []*funcDef
+	globalInits [][]*node // initializer expressions of the defined globals
+	exports     []export
+	inline      map[*node]uint32 // index of tables and memories with inline segments
+}
+
+// funcDef is a function defined in the module, whose body is compiled
+// once all the module's identifiers are known.
+type funcDef struct {
+	params []*node // identifiers of the parameters, nil for anonymous ones
+	body   []*node // locals and instructions
+}
+
+// export is an export whose index is resolved once all the module's
+// identifiers are known.
+type export struct {
+	name  *node
+	kind  wasm.External
+	idx   *node // the exported entity, nil if index is already known
+	index uint32
+}
+
+// buildModule builds a module from its fields.
+func buildModule(pos Pos, fields []*node) (*wasm.Module, error) {
+	b := &builder{
+		m:       &wasm.Module{Version: wasm.Version},
+		pos:     pos,
+		types:   newSpace("type"),
+		funcs:   newSpace("function"),
+		tables:  newSpace("table"),
+		mems:    newSpace("memory"),
+		globals: newSpace("global"),
+		inline:  make(map[*node]uint32),
+	}
+
+	// explicit types come first in the type section, followed by
+	// the types implicitly defined by type uses
+	for _, f := range fields {
+		if f.head() == "type" {
+			if err := b.typeField(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, f := range fields {
+		var err error
+		switch f.head() {
+		case "type", "start", "elem", "data":
+			// handled separately
+		case "import":
+			err = b.importField(f)
+		case "func":
+			err = b.funcField(f)
+		case "table":
+			err = b.tableField(f)
+		case "memory":
+			err = b.memoryField(f)
+		case "global":
+			err = b.globalField(f)
+		case "export":
+			err = b.exportField(f)
+		default:
+			err = errorf(f.pos, "unexpected module field %v", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// segments, exports and the start function may refer to entities
+	// defined later in the module
+	for _, f := range fields {
+		var err error
+		switch f.head() {
+		case "table":
+			err = b.inlineElem(f)
+		case "memory":
+			err = b.inlineData(f)
+		case "start":
+			err = b.startField(f)
+		case "elem":
+			err = b.elemField(f)
+		case "data":
+			err = b.dataField(f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.resolveExports(); err != nil {
+		return nil, err
+	}
+	if err := b.compileGlobals(); err != nil {
+		return nil, err
+	}
+	for _, fn := range b.bodies {
+		body, err := b.compileFunc(fn)
+		if err != nil {
+			return nil, err
+		}
+		b.m.Code.Bodies = append(b.m.Code.Bodies, *body)
+	}
+	return b.m, nil
+}
+
+// id returns the identifier at items[i] if any, and the index of the
+// following item.
+func id(items []*node, i int) (*node, int) {
+	if i < len(items) && items[i].isID() {
+		return items[i], i + 1
+	}
+	return nil, i
+}
+
+// str returns the string at items[i], or an error.
+func str(items []*node, i int, pos Pos) (string, error) {
+	if i >= len(items) || !items[i].isString() {
+		return "", errorf(posAt(items, i, pos), "expected string")
+	}
+	return items[i].tok.text, nil
+}
+
+// posAt returns the position of items[i], or pos if i is out of range.
+func posAt(items []*node, i int, pos Pos) Pos {
+	if i < len(items) {
+		return items[i].pos
+	}
+	return pos
+}
+
+func valueType(n *node) (wasm.ValueType, error) {
+	if n.isAtom() {
+		switch n.tok.text {
+		case "i32":
+			return wasm.ValueTypeI32, nil
+		case "i64":
+			return wasm.ValueTypeI64, nil
+		case "f32":
+			return wasm.ValueTypeF32, nil
+		case "f64":
+			return wasm.ValueTypeF64, nil
+		}
+	}
+	return 0, errorf(n.pos, "expected value type, got %v", n)
+}
+
+// typeField parses (type $id? (func (param ...)* (result ...)*)).
+func (b *builder) typeField(f *node) error {
+	items := f.args()
+	name, i := id(items, 0)
+	if i != len(items)-1 || items[i].head() != "func" {
+		return errorf(f.pos, "expected function type")
+	}
+	sig, _, next, err := signature(items[i].args(), 0)
+	if err != nil {
+		return err
+	}
+	if rest := items[i].args(); next != len(rest) {
+		return errorf(rest[next].pos, "unexpected %v in function type", rest[next])
+	}
+	if _, err := b.types.add(name); err != nil {
+		return err
+	}
+	if b.m.Types == nil {
+		b.m.Types = &wasm.SectionTypes{}
+	}
+	b.m.Types.Entries = append(b.m.Types.Entries, sig)
+	return nil
+}
+
+// signature parses the (param ...) and (result ...) lists starting at
+// items[i]. It also returns the identifiers of the parameters.
+func signature(items []*node, i int) (wasm.FunctionSig, []*node, int, error) {
+	sig := wasm.FunctionSig{Form: -0x20} // func
+	var ids []*node
+	for ; i < len(items) && items[i].head() == "param"; i++ {
+		args := items[i].args()
+		if name, _ := id(args, 0); name != nil {
+			if len(args) != 2 {
+				return sig, nil, 0, errorf(items[i].pos, "expected one type for named parameter")
+			}
+			t, err := valueType(args[1])
+			if err != nil {
+				return sig, nil, 0, err
+			}
+			sig.ParamTypes = append(sig.ParamTypes, t)
+			ids = append(ids, name)
+			continue
+		}
+		for _, a := range args {
+			t, err := valueType(a)
+			if err != nil {
+				return sig, nil, 0, err
+			}
+			sig.ParamTypes = append(sig.ParamTypes, t)
+			ids = append(ids, nil)
+		}
+	}
+	for ; i < len(items) && items[i].head() == "result"; i++ {
+		for _, a := range items[i].args() {
+			t, err := valueType(a)
+			if err != nil {
+				return sig, nil, 0, err
+			}
+			sig.ReturnTypes = append(sig.ReturnTypes, t)
+		}
+	}
+	return sig, ids, i, nil
+}
+
+// typeUse parses a type use starting at items[i]: an optional (type x)
+// followed by an optional signature. It returns the index of the type,
+// adding an implicit type definition if necessary, the identifiers of the
+// parameters, and the index of the following item.
+func (b *builder) typeUse(items []*node, i int) (uint32, []*node, int, error) {
+	var ref *node
+	if i < len(items) && items[i].head() == "type" {
+		ref = items[i]
+		i++
+	}
+	hasSig := i < len(items) && (items[i].head() == "param" || items[i].head() == "result")
+	sig, params, next, err := signature(items, i)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	if ref != nil {
+		args := ref.args()
+		if len(args) != 1 {
+			return 0, nil, 0, errorf(ref.pos, "expected type index")
+		}
+		index, err := b.types.index(args[0])
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		if b.m.Types == nil || int(index) >= len(b.m.Types.Entries) {
+			return 0, nil, 0, errorf(args[0].pos, "unknown type %v", args[0])
+		}
+		if typ := b.m.Types.Entries[index]; !hasSig {
+			params = make([]*node, len(typ.ParamTypes))
+		} else if !typ.Equal(sig) {
+			return 0, nil, 0, errorf(ref.pos, "inline function type does not match type %v", args[0])
+		}
+		return index, params, next, nil
+	}
+
+	if b.m.Types == nil {
+		b.m.Types = &wasm.SectionTypes{}
+	}
+	for index, typ := range b.m.Types.Entries {
+		if typ.Equal(sig) {
+			return uint32(index), params, next, nil
+		}
+	}
+	b.m.Types.Entries = append(b.m.Types.Entries, sig)
+	b.types.n++
+	return uint32(len(b.m.Types.Entries) - 1), params, next, nil
+}
+
+// inlineExports parses the (export "name") lists of a definition starting
+// at items[i], and returns the index of the following item.
+func (b *builder) inlineExports(items []*node, i int, kind wasm.External, index uint32) (int, error) {
+	for ; i < len(items) && items[i].head() == "export"; i++ {
+		args := items[i].args()
+		if len(args) != 1 || !args[0].isString() {
+			return 0, errorf(items[i].pos, "expected export name")
+		}
+		b.exports = append(b.exports, export{name: args[0], kind: kind, index: index})
+	}
+	return i, nil
+}
+
+// inlineImport parses the (import "module" "name") list of a definition
+// at items[i] if any, and returns the index of the following item.
+func inlineImport(items []*node, i int) (*wasm.ImportEntry, int, error) {
+	if i >= len(items) || items[i].head() != "import" {
+		return nil, i, nil
+	}
+	if len(items[i].args()) != 2 {
+		return nil, 0, errorf(items[i].pos, "expected module and field names")
+	}
+	imp, err := importNames(items[i])
+	return imp, i + 1, err
+}
+
+func importNames(n *node) (*wasm.ImportEntry, error) {
+	args := n.args()
+	mod, err := str(args, 0, n.pos)
+	if err != nil {
+		return nil, err
+	}
+	field, err := str(args, 1, n.pos)
+	if err != nil {
+		return nil, err
+	}
+	return &wasm.ImportEntry{ModuleName: mod, FieldName: field}, nil
+}
+
+func (b *builder) addImport(pos Pos, imp *wasm.ImportEntry) error {
+	if b.defined {
+		return errorf(pos, "import after function, table, memory or global definition")
+	}
+	if b.m.Import == nil {
+		b.m.Import = &wasm.SectionImports{}
+	}
+	b.m.Import.Entries = append(b.m.Import.Entries, *imp)
+	return nil
+}
+
+// importField parses (import "module" "name" desc).
+func (b *builder) importField(f *node) error {
+	items := f.args()
+	imp, err := importNames(f)
+	if err != nil {
+		return err
+	}
+	if len(items) != 3 || !items[2].isList {
+		return errorf(f.pos, "expected import description")
+	}
+
+	desc := items[2]
+	args := desc.args()
+	name, i := id(args, 0)
+	switch desc.head() {
+	case "func":
+		if _, err = b.funcs.add(name); err != nil {
+			return err
+		}
+		var index uint32
+		index, _, i, err = b.typeUse(args, i)
+		imp.Kind, imp.Type = wasm.ExternalFunction, wasm.FuncImport{Type: index}
+	case "table":
+		if _, err = b.tables.add(name); err != nil {
+			return err
+		}
+		var t wasm.Table
+		t, i, err = table(args, i, desc.pos)
+		imp.Kind, imp.Type = wasm.ExternalTable, wasm.TableImport{Type: t}
+	case "memory":
+		if _, err = b.mems.add(name); err != nil {
+			return err
+		}
+		var limits wasm.ResizableLimits
+		limits, i, err = resizableLimits(args, i, desc.pos)
+		imp.Kind, imp.Type = wasm.ExternalMemory, wasm.MemoryImport{Type: wasm.Memory{Limits: limits}}
+	case "global":
+		if _, err = b.globals.add(name); err != nil {
+			return err
+		}
+		var g wasm.GlobalVar
+		g, err = globalType(args, i, desc.pos)
+		i++
+		imp.Kind, imp.Type = wasm.ExternalGlobal, wasm.GlobalVarImport{Type: g}
+	default:
+		return errorf(desc.pos, "unexpected import description %v", desc)
+	}
+	if err != nil {
+		return err
+	}
+	if i < len(args) {
+		return errorf(args[i].pos, "unexpected %v in import", args[i])
+	}
+	return b.addImport(f.pos, imp)
+}
+
+// funcField parses (func $id? (export "name")* (import "module" "name")?
+// typeuse local* instr*).
+func (b *builder) funcField(f *node) error {
+	items := f.args()
+	name, i := id(items, 0)
+	index, err := b.funcs.add(name)
+	if err != nil {
+		return err
+	}
+	if i, err = b.inlineExports(items, i, wasm.ExternalFunction, index); err != nil {
+		return err
+	}
+	imp, i, err := inlineImport(items, i)
+	if err != nil {
+		return err
+	}
+	typ, params, i, err := b.typeUse(items, i)
+	if err != nil {
+		return err
+	}
+
+	if imp != nil {
+		if i != len(items) {
+			return errorf(items[i].pos, "unexpected %v in imported function", items[i])
+		}
+		imp.Kind, imp.Type = wasm.ExternalFunction, wasm.FuncImport{Type: typ}
+		return b.addImport(f.pos, imp)
+	}
+
+	b.defined = true
+	if b.m.Function == nil {
+		b.m.Function = &wasm.SectionFunctions{}
+		b.m.Code = &wasm.SectionCode{}
+	}
+	b.m.Function.Types = append(b.m.Function.Types, typ)
+	b.bodies = append(b.bodies, &funcDef{params: params, body: items[i:]})
+	return nil
+}
+
+// resizableLimits parses the limits "min max?" starting at items[i].
+func resizableLimits(items []*node, i int, pos Pos) (wasm.ResizableLimits, int, error) {
+	var limits wasm.ResizableLimits
+	if i >= len(items) || !items[i].isAtom() {
+		return limits, 0, errorf(posAt(items, i, pos), "expected limits")
+	}
+	min, err := parseUint(items[i].tok.text, 32)
+	if err != nil {
+		return limits, 0, errorf(items[i].pos, "invalid limit %s", items[i].tok.text)
+	}
+	limits.Initial = uint32(min)
+	i++
+
+	if i < len(items) && items[i].isAtom() && isIndex(items[i]) {
+		max, err := parseUint(items[i].tok.text, 32)
+		if err != nil {
+			return limits, 0, errorf(items[i].pos, "invalid limit %s", items[i].tok.text)
+		}
+		limits.Flags, limits.Maximum = 1, uint32(max)
+		i++
+	}
+	return limits, i, nil
+}
+
+func elemType(n *node) error {
+	if !n.isAtom() || (n.tok.text != "anyfunc" && n.tok.text != "funcref") {
+		return errorf(n.pos, "expected element type, got %v", n)
+	}
+	return nil
+}
+
+// table parses the table type "min max? anyfunc" starting at items[i].
+func table(items []*node, i int, pos Pos) (wasm.Table, int, error) {
+	t := wasm.Table{ElementType: wasm.ElemTypeAnyFunc}
+	limits, i, err := resizableLimits(items, i, pos)
+	if err != nil {
+		return t, 0, err
+	}
+	t.Limits = limits
+	if i >= len(items) {
+		return t, 0, errorf(pos, "expected element type")
+	}
+	return t, i + 1, elemType(items[i])
+}
+
+// globalType parses the global type at items[i], either a value type or
+// (mut valtype).
+func globalType(items []*node, i int, pos Pos) (wasm.GlobalVar, error) {
+	if i >= len(items) {
+		return wasm.GlobalVar{}, errorf(pos, "expected global type")
+	}
+	n := items[i]
+	if n.head() == "mut" {
+		if len(n.list) != 2 {
+			return wasm.GlobalVar{}, errorf(n.pos, "expected value type")
+		}
+		t, err := valueType(n.list[1])
+		return wasm.GlobalVar{Type: t, Mutable: true}, err
+	}
+	t, err := valueType(n)
+	return wasm.GlobalVar{Type: t}, err
+}
+
+// tableField parses (table $id? (export "name")* (import "module" "name")?
+// tabletype) or (table $id? (export "name")* anyfunc (elem funcidx*)).
+func (b *builder) tableField(f *node) error {
+	items := f.args()
+	name, i := id(items, 0)
+	index, err := b.tables.add(name)
+	if err != nil {
+		return err
+	}
+	if i, err = b.inlineExports(items, i, wasm.ExternalTable, index); err != nil {
+		return err
+	}
+	imp, i, err := inlineImport(items, i)
+	if err != nil {
+		return err
+	}
+
+	var t wasm.Table
+	if i == len(items)-2 && items[i+1].head() == "elem" && imp == nil {
+		// the table is sized by its inline element segment
+		if err := elemType(items[i]); err != nil {
+			return err
+		}
+		n := uint32(len(items[i+1].args()))
+		t = wasm.Table{ElementType: wasm.ElemTypeAnyFunc, Limits: wasm.ResizableLimits{Flags: 1, Initial: n, Maximum: n}}
+		b.inline[f] = index
+		i += 2
+	} else if t, i, err = table(items, i, f.pos); err != nil {
+		return err
+	}
+	if i != len(items) {
+		return errorf(items[i].pos, "unexpected %v in table", items[i])
+	}
+
+	if imp != nil {
+		imp.Kind, imp.Type = wasm.ExternalTable, wasm.TableImport{Type: t}
+		return b.addImport(f.pos, imp)
+	}
+	b.defined = true
+	if b.m.Table == nil {
+		b.m.Table = &wasm.SectionTables{}
+	}
+	b.m.Table.Entries = append(b.m.Table.Entries, t)
+	return nil
+}
+
+// memoryField parses (memory $id? (export "name")* (import "module"
+// "name")? limits) or (memory $id? (export "name")* (data string*)).
+func (b *builder) memoryField(f *node) error {
+	items := f.args()
+	name, i := id(items, 0)
+	index, err := b.mems.add(name)
+	if err != nil {
+		return err
+	}
+	if i, err = b.inlineExports(items, i, wasm.ExternalMemory, index); err != nil {
+		return err
+	}
+	imp, i, err := inlineImport(items, i)
+	if err != nil {
+		return err
+	}
+
+	var limits wasm.ResizableLimits
+	if i == len(items)-1 && items[i].head() == "data" && imp == nil {
+		// the memory is sized by its inline data segment
+		data, err := dataString(items[i].args())
+		if err != nil {
+			return err
+		}
+		n := uint32((len(data) + wasmPageSize - 1) / wasmPageSize)
+		limits = wasm.ResizableLimits{Flags: 1, Initial: n, Maximum: n}
+		b.inline[f] = index
+		i++
+	} else if limits, i, err = resizableLimits(items, i, f.pos); err != nil {
+		return err
+	}
+	if i != len(items) {
+		return errorf(items[i].pos, "unexpected %v in memory", items[i])
+	}
+
+	if imp != nil {
+		imp.Kind, imp.Type = wasm.ExternalMemory, wasm.MemoryImport{Type: wasm.Memory{Limits: limits}}
+		return b.addImport(f.pos, imp)
+	}
+	b.defined = true
+	if b.m.Memory == nil {
+		b.m.Memory = &wasm.SectionMemories{}
+	}
+	b.m.Memory.Entries = append(b.m.Memory.Entries, wasm.Memory{Limits: limits})
+	return nil
+}
+
+// globalField parses (global $id? (export "name")* (import "module"
+// "name")? globaltype instr*).
+func (b *builder) globalField(f *node) error {
+	items := f.args()
+	name, i := id(items, 0)
+	index, err := b.globals.add(name)
+	if err != nil {
+		return err
+	}
+	if i, err = b.inlineExports(items, i, wasm.ExternalGlobal, index); err != nil {
+		return err
+	}
+	imp, i, err := inlineImport(items, i)
+	if err != nil {
+		return err
+	}
+	g, err := globalType(items, i, f.pos)
+	if err != nil {
+		return err
+	}
+	i++
+
+	if imp != nil {
+		if i != len(items) {
+			return errorf(items[i].pos, "unexpected %v in imported global", items[i])
+		}
+		imp.Kind, imp.Type = wasm.ExternalGlobal, wasm.GlobalVarImport{Type: g}
+		return b.addImport(f.pos, imp)
+	}
+	b.defined = true
+	if b.m.Global == nil {
+		b.m.Global = &wasm.SectionGlobals{}
+	}
+	// the initializer may refer to globals imported later in the module
+	b.m.Global.Globals = append(b.m.Global.Globals, wasm.GlobalEntry{Type: &g})
+	b.globalInits = append(b.globalInits, items[i:])
+	return nil
+}
+
+// exportField parses (export "name" (kind index)).
+func (b *builder) exportField(f *node) error {
+	items := f.args()
+	if len(items) != 2 || !items[0].isString() || len(items[1].list) != 2 {
+		return errorf(f.pos, "expected export name and description")
+	}
+	var kind wasm.External
+	switch items[1].head() {
+	case "func":
+		kind = wasm.ExternalFunction
+	case "table":
+		kind = wasm.ExternalTable
+	case "memory":
+		kind = wasm.ExternalMemory
+	case "global":
+		kind = wasm.ExternalGlobal
+	default:
+		return errorf(items[1].pos, "unexpected export description %v", items[1])
+	}
+	b.exports = append(b.exports, export{name: items[0], kind: kind, idx: items[1].list[1]})
+	return nil
+}
+
+func (b *builder) resolveExports() error {
+	if len(b.exports) == 0 {
+		return nil
+	}
+	b.m.Export = &wasm.SectionExports{Entries: make(map[string]wasm.ExportEntry)}
+	for _, e := range b.exports {
+		name := e.name.tok.text
+		if _, ok := b.m.Export.Entries[name]; ok {
+			return errorf(e.name.pos, "duplicate export %q", name)
+		}
+		index := e.index
+		if e.idx != nil {
+			var err error
+			if index, err = b.space(e.kind).index(e.idx); err != nil {
+				return err
+			}
+		}
+		b.m.Export.Entries[name] = wasm.ExportEntry{FieldStr: name, Kind: e.kind, Index: index}
+		b.m.Export.Names = append(b.m.Export.Names, name)
+	}
+	return nil
+}
+
+func (b *builder) space(kind wasm.External) *space {
+	switch kind {
+	case wasm.ExternalFunction:
+		return b.funcs
+	case wasm.ExternalTable:
+		return b.tables
+	case wasm.ExternalMemory:
+		return b.mems
+	default:
+		return b.globals
+	}
+}
+
+// startField parses (start funcidx).
+func (b *builder) startField(f *node) error {
+	if b.m.Start != nil {
+		return errorf(f.pos, "multiple start functions")
+	}
+	items := f.args()
+	if len(items) != 1 {
+		return errorf(f.pos, "expected start function index")
+	}
+	index, err := b.funcs.index(items[0])
+	if err != nil {
+		return err
+	}
+	b.m.Start = &wasm.SectionStartFunction{Index: index}
+	return nil
+}
+
+// offset parses the offset expression of a segment at items[i], either
+// (offset instr*) or a single folded instruction.
+func (b *builder) offset(items []*node, i int, pos Pos) ([]byte, error) {
+	if i >= len(items) || !items[i].isList {
+		return nil, errorf(posAt(items, i, pos), "expected offset expression")
+	}
+	expr := items[i : i+1]
+	if items[i].head() == "offset" {
+		expr = items[i].args()
+	}
+	return b.constExpr(expr, items[i].pos)
+}
+
+// segmentIndex parses the optional table or memory index of a segment at
+// items[0].
+func segmentIndex(s *space, items []*node) (uint32, int, error) {
+	if len(items) > 0 && items[0].isAtom() {
+		index, err := s.index(items[0])
+		return index, 1, err
+	}
+	return 0, 0, nil
+}
+
+func (b *builder) addElem(e wasm.ElementSegment) {
+	if b.m.Elements == nil {
+		b.m.Elements = &wasm.SectionElements{}
+	}
+	b.m.Elements.Entries = append(b.m.Elements.Entries, e)
+}
+
+func (b *builder) addData(d wasm.DataSegment) {
+	if b.m.Data == nil {
+		b.m.Data = &wasm.SectionData{}
+	}
+	b.m.Data.Entries = append(b.m.Data.Entries, d)
+}
+
+// elemField parses (elem tableidx? offset funcidx*).
+func (b *builder) elemField(f *node) error {
+	items := f.args()
+	table, i, err := segmentIndex(b.tables, items)
+	if err != nil {
+		return err
+	}
+	offset, err := b.offset(items, i, f.pos)
+	if err != nil {
+		return err
+	}
+	elems, err := b.funcIndices(items[i+1:])
+	if err != nil {
+		return err
+	}
+	b.addElem(wasm.ElementSegment{Index: table, Offset: offset, Elems: elems})
+	return nil
+}
+
+func (b *builder) funcIndices(items []*node) ([]uint32, error) {
+	elems := make([]uint32, len(items))
+	for i, n := range items {
+		index, err := b.funcs.index(n)
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = index
+	}
+	return elems, nil
+}
+
+// dataField parses (data memidx? offset string*).
+func (b *builder) dataField(f *node) error {
+	items := f.args()
+	mem, i, err := segmentIndex(b.mems, items)
+	if err != nil {
+		return err
+	}
+	offset, err := b.offset(items, i, f.pos)
+	if err != nil {
+		return err
+	}
+	data, err := dataString(items[i+1:])
+	if err != nil {
+		return err
+	}
+	b.addData(wasm.DataSegment{Index: mem, Offset: offset, Data: data})
+	return nil
+}
+
+// dataString concatenates the strings in items.
+func dataString(items []*node) ([]byte, error) {
+	var data []byte
+	for _, n := range items {
+		if !n.isString() {
+			return nil, errorf(n.pos, "expected string, got %v", n)
+		}
+		data = append(data, n.tok.text...)
+	}
+	return data, nil
+}
+
+// zeroOffset is the offset expression of inline segments.
+var zeroOffset = []byte{0x41, 0x00, 0x0b} // i32.const 0; end
+
+// inlineElem adds the element segment defined inline in the table field f.
+func (b *builder) inlineElem(f *node) error {
+	index, ok := b.inline[f]
+	if !ok {
+		return nil
+	}
+	elems, err := b.funcIndices(f.list[len(f.list)-1].args())
+	if err != nil {
+		return err
+	}
+	b.addElem(wasm.ElementSegment{Index: index, Offset: zeroOffset, Elems: elems})
+	return nil
+}
+
+// inlineData adds the data segment defined inline in the memory field f.
+func (b *builder) inlineData(f *node) error {
+	index, ok := b.inline[f]
+	if !ok {
+		return nil
+	}
+	data, err := dataString(f.list[len(f.list)-1].args())
+	if err != nil {
+		return err
+	}
+	b.addData(wasm.DataSegment{Index: index, Offset: zeroOffset, Data: data})
+	return nil
+}
+
+func (b *builder) compileGlobals() error {
+	for i, expr := range b.globalInits {
+		init, err := b.constExpr(expr, b.pos)
+		if err != nil {
+			return err
+		}
+		b.m.Global.Globals[i].Init = init
+	}
+	return nil
+}
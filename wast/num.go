@@ -0,0 +1,211 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var errInvalidNumber = errors.New("invalid number")
+
+// splitSign splits the optional sign of the number s from its digits.
+func splitSign(s string) (neg, signed bool, digits string) {
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		return s[0] == '-', true, s[1:]
+	}
+	return false, false, s
+}
+
+// stripUnderscores removes the underscores separating the digits of s.
+func stripUnderscores(s string) (string, error) {
+	if !strings.Contains(s, "_") {
+		return s, nil
+	}
+	if strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") || strings.Contains(s, "__") {
+		return "", errInvalidNumber
+	}
+	return strings.Replace(s, "_", "", -1), nil
+}
+
+// parseUint parses the unsigned integer s, of the given size in bits.
+func parseUint(s string, bits int) (uint64, error) {
+	digits, err := stripUnderscores(s)
+	if err != nil {
+		return 0, err
+	}
+	base := 10
+	if strings.HasPrefix(digits, "0x") {
+		digits, base = digits[2:], 16
+	}
+	if digits == "" || digits[0] == '+' || digits[0] == '-' {
+		return 0, errInvalidNumber
+	}
+	return strconv.ParseUint(digits, base, bits)
+}
+
+// parseInt parses the integer s of the given size in bits, which may be
+// either signed or unsigned, and returns its two's complement bits.
+func parseInt(s string, bits int) (uint64, error) {
+	neg, signed, digits := splitSign(s)
+	v, err := parseUint(digits, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case !signed:
+		if bits < 64 && v >= 1<<uint(bits) {
+			return 0, errInvalidNumber
+		}
+	case neg:
+		if v > 1<<uint(bits-1) {
+			return 0, errInvalidNumber
+		}
+		v = -v
+	default:
+		if v >= 1<<uint(bits-1) {
+			return 0, errInvalidNumber
+		}
+	}
+
+	if bits < 64 {
+		v &= 1<<uint(bits) - 1
+	}
+	return v, nil
+}
+
+// parseFloat parses the floating point number s of the given size in
+// bits, and returns its IEEE 754 bits.
+func parseFloat(s string, bits int) (uint64, error) {
+	neg, _, digits := splitSign(s)
+
+	var signBit, expMask, quietBit uint64 = 1 << 63, 0x7ff << 52, 1 << 51
+	if bits == 32 {
+		signBit, expMask, quietBit = 1<<31, 0xff<<23, 1<<22
+	}
+	if !neg {
+		signBit = 0
+	}
+
+	switch {
+	case digits == "inf":
+		return signBit | expMask, nil
+	case digits == "nan":
+		return signBit | expMask | quietBit, nil
+	case strings.HasPrefix(digits, "nan:0x"):
+		payload, err := parseUint(digits[4:], 64)
+		if err != nil || payload == 0 || payload >= quietBit<<1 {
+			return 0, errInvalidNumber
+		}
+		return signBit | expMask | payload, nil
+	case strings.HasPrefix(digits, "0x"):
+		return parseHexFloat(digits[2:], bits, signBit)
+	}
+
+	digits, err := stripUnderscores(digits)
+	if err != nil || digits == "" || !isDecimalFloat(digits) {
+		return 0, errInvalidNumber
+	}
+	f, err := strconv.ParseFloat(digits, bits)
+	if err != nil {
+		return 0, err
+	}
+	if bits == 32 {
+		return signBit | uint64(math.Float32bits(float32(f))), nil
+	}
+	return signBit | math.Float64bits(f), nil
+}
+
+// isDecimalFloat reports whether s only contains the characters of a
+// decimal floating point number.
+func isDecimalFloat(s string) bool {
+	if s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9', c == '.', c == 'e', c == 'E', c == '+', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseHexFloat parses the hexadecimal floating point number s, without
+// its 0x prefix.
+func parseHexFloat(s string, bits int, signBit uint64) (uint64, error) {
+	s, err := stripUnderscores(s)
+	if err != nil {
+		return 0, err
+	}
+
+	mant := new(big.Int)
+	exp := 0
+	seenDigit, seenDot := false, false
+	i := 0
+digits:
+	for ; i < len(s); i++ {
+		c := s[i]
+		var d int64
+		switch {
+		case c >= '0' && c <= '9':
+			d = int64(c - '0')
+		case c >= 'a' && c <= 'f':
+			d = int64(c-'a') + 10
+		case c >= 'A' && c <= 'F':
+			d = int64(c-'A') + 10
+		case c == '.' && !seenDot:
+			seenDot = true
+			continue
+		default:
+			break digits
+		}
+		seenDigit = true
+		mant.Lsh(mant, 4).Or(mant, big.NewInt(d))
+		if seenDot {
+			exp -= 4
+		}
+	}
+
+	if !seenDigit {
+		return 0, errInvalidNumber
+	}
+	if i < len(s) {
+		if s[i] != 'p' && s[i] != 'P' {
+			return 0, errInvalidNumber
+		}
+		e, err := strconv.Atoi(strings.TrimPrefix(s[i+1:], "+"))
+		if err != nil {
+			return 0, errInvalidNumber
+		}
+		// larger exponents overflow or underflow anyway
+		if e > 100000 {
+			e = 100000
+		} else if e < -100000 {
+			e = -100000
+		}
+		exp += e
+	}
+
+	f := new(big.Float).SetInt(mant)
+	f.SetMantExp(f, exp)
+	if bits == 32 {
+		v, _ := f.Float32()
+		if math.IsInf(float64(v), 0) {
+			return 0, errInvalidNumber
+		}
+		return signBit | uint64(math.Float32bits(v)), nil
+	}
+	v, _ := f.Float64()
+	if math.IsInf(v, 0) {
+		return 0, errInvalidNumber
+	}
+	return signBit | math.Float64bits(v), nil
+}
@@ -0,0 +1,526 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// WriteModule writes the module m to w in the text format.
+// Functions and locals are named after the module's name section, if
+// any. Custom sections are not written. The operators of all the features
+// are written, the module being printed as is.
+func WriteModule(w io.Writer, m *wasm.Module) error {
+	p := newPrinter(m)
+	if err := p.module(); err != nil {
+		return err
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// WriteInstrs writes the disassembled instructions instrs to w in the
+// flat text format, one instruction per line, indenting the instructions
+// nested in blocks.
+func WriteInstrs(w io.Writer, instrs []disasm.Instr) error {
+	p := newPrinter(&wasm.Module{})
+	p.instrs(instrs, 0, nil)
+	p.buf.WriteByte('\n')
+	_, err := w.Write(p.buf.Bytes()[1:]) // skip the leading newline
+	return err
+}
+
+// printer renders a module in the text format.
+type printer struct {
+	buf bytes.Buffer
+	m   *wasm.Module
+
+	// view is m with its function index space populated from its
+	// sections, for disassembling its functions.
+	view *wasm.Module
+
+	funcNames  map[uint32]string // identifiers of the functions, by index
+	localNames map[uint32]map[uint32]string
+}
+
+func newPrinter(m *wasm.Module) *printer {
+	p := &printer{
+		m:          m,
+		funcNames:  make(map[uint32]string),
+		localNames: make(map[uint32]map[uint32]string),
+	}
+	if m.Name != nil {
+		p.funcNames = identifiers(m.Name.Functions)
+		for index, locals := range m.Name.Locals {
+			p.localNames[index] = identifiers(locals)
+		}
+	}
+
+	view := *m
+	view.FunctionIndexSpace = nil
+	if m.Import != nil {
+		for _, imp := range m.Import.Entries {
+			if imp, ok := imp.Type.(wasm.FuncImport); ok {
+				view.FunctionIndexSpace = append(view.FunctionIndexSpace, wasm.Function{Sig: p.sig(imp.Type)})
+			}
+		}
+	}
+	if m.Function != nil {
+		for _, t := range m.Function.Types {
+			view.FunctionIndexSpace = append(view.FunctionIndexSpace, wasm.Function{Sig: p.sig(t)})
+		}
+	}
+	p.view = &view
+	return p
+}
+
+// identifiers turns the names into identifiers of the text format.
+// Characters that can't appear in identifiers are replaced with
+// underscores, and duplicate names are dropped.
+func identifiers(names wasm.NameMap) map[uint32]string {
+	indices := make([]int, 0, len(names))
+	for index := range names {
+		indices = append(indices, int(index))
+	}
+	sort.Ints(indices)
+
+	ids := make(map[uint32]string, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, index := range indices {
+		name := names[uint32(index)]
+		if name == "" {
+			continue
+		}
+		id := []byte("$" + name)
+		for i, c := range id {
+			if !isIDChar(c) {
+				id[i] = '_'
+			}
+		}
+		if !seen[string(id)] {
+			ids[uint32(index)] = string(id)
+			seen[string(id)] = true
+		}
+	}
+	return ids
+}
+
+// sig returns the function signature with the type index t, or an empty
+// signature if t is out of range.
+func (p *printer) sig(t uint32) *wasm.FunctionSig {
+	if p.m.Types == nil || int(t) >= len(p.m.Types.Entries) {
+		return &wasm.FunctionSig{}
+	}
+	return &p.m.Types.Entries[t]
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&p.buf, format, args...)
+}
+
+// newline starts a new line, indented by depth levels.
+func (p *printer) newline(depth int) {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat("  ", depth))
+}
+
+// index writes the identifier of the entity with the given index if it has
+// one, or the index in a comment, as in the definition of entities.
+func (p *printer) index(ids map[uint32]string, index uint32) {
+	if id, ok := ids[index]; ok {
+		p.printf(" %s", id)
+	} else {
+		p.printf(" (;%d;)", index)
+	}
+}
+
+// ref writes a reference to the entity with the given index.
+func (p *printer) ref(ids map[uint32]string, index uint32) {
+	if id, ok := ids[index]; ok {
+		p.printf(" %s", id)
+	} else {
+		p.printf(" %d", index)
+	}
+}
+
+func (p *printer) module() error {
+	m := p.m
+	p.buf.WriteString("(module")
+	if m.Name != nil && m.Name.ModuleName != "" {
+		p.printf(" %s", identifiers(wasm.NameMap{0: m.Name.ModuleName})[0])
+	}
+
+	if m.Types != nil {
+		for i, sig := range m.Types.Entries {
+			p.newline(1)
+			p.printf("(type (;%d;) (func", i)
+			p.signature(sig, nil)
+			p.buf.WriteString("))")
+		}
+	}
+
+	var nfuncs, ntables, nmems, nglobals uint32
+	if m.Import != nil {
+		for _, imp := range m.Import.Entries {
+			p.newline(1)
+			p.printf("(import %s %s ", quote([]byte(imp.ModuleName)), quote([]byte(imp.FieldName)))
+			switch t := imp.Type.(type) {
+			case wasm.FuncImport:
+				p.buf.WriteString("(func")
+				p.index(p.funcNames, nfuncs)
+				p.printf(" (type %d)", t.Type)
+				p.signature(*p.sig(t.Type), nil)
+				nfuncs++
+			case wasm.TableImport:
+				p.printf("(table (;%d;)", ntables)
+				p.table(t.Type)
+				ntables++
+			case wasm.MemoryImport:
+				p.printf("(memory (;%d;)", nmems)
+				p.limits(t.Type.Limits)
+				nmems++
+			case wasm.GlobalVarImport:
+				p.printf("(global (;%d;)", nglobals)
+				p.globalType(t.Type)
+				nglobals++
+			}
+			p.buf.WriteString("))")
+		}
+	}
+
+	if m.Function != nil {
+		for i, t := range m.Function.Types {
+			if m.Code == nil || i >= len(m.Code.Bodies) {
+				return wasm.MissingSectionError(wasm.SectionIDCode)
+			}
+			if err := p.function(nfuncs+uint32(i), t, &m.Code.Bodies[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.Table != nil {
+		for i, t := range m.Table.Entries {
+			p.newline(1)
+			p.printf("(table (;%d;)", ntables+uint32(i))
+			p.table(t)
+			p.buf.WriteByte(')')
+		}
+	}
+
+	if m.Memory != nil {
+		for i, mem := range m.Memory.Entries {
+			p.newline(1)
+			p.printf("(memory (;%d;)", nmems+uint32(i))
+			p.limits(mem.Limits)
+			p.buf.WriteByte(')')
+		}
+	}
+
+	if m.Global != nil {
+		for i, g := range m.Global.Globals {
+			p.newline(1)
+			p.printf("(global (;%d;)", nglobals+uint32(i))
+			p.globalType(*g.Type)
+			if err := p.initExpr(g.Init); err != nil {
+				return err
+			}
+			p.buf.WriteByte(')')
+		}
+	}
+
+	if m.Export != nil {
+		for _, name := range m.Export.OrderedNames() {
+			e := m.Export.Entries[name]
+			p.newline(1)
+			p.printf("(export %s (%s", quote([]byte(name)), externalKeywords[e.Kind])
+			if e.Kind == wasm.ExternalFunction {
+				p.ref(p.funcNames, e.Index)
+			} else {
+				p.printf(" %d", e.Index)
+			}
+			p.buf.WriteString("))")
+		}
+	}
+
+	if m.Start != nil {
+		p.newline(1)
+		p.buf.WriteString("(start")
+		p.ref(p.funcNames, m.Start.Index)
+		p.buf.WriteByte(')')
+	}
+
+	if m.Elements != nil {
+		for i, e := range m.Elements.Entries {
+			p.newline(1)
+			p.printf("(elem (;%d;)", i)
+			if e.Index != 0 {
+				p.printf(" %d", e.Index)
+			}
+			if err := p.initExpr(e.Offset); err != nil {
+				return err
+			}
+			for _, index := range e.Elems {
+				p.ref(p.funcNames, index)
+			}
+			p.buf.WriteByte(')')
+		}
+	}
+
+	if m.Data != nil {
+		for i, d := range m.Data.Entries {
+			p.newline(1)
+			p.printf("(data (;%d;)", i)
+			if d.Index != 0 {
+				p.printf(" %d", d.Index)
+			}
+			if err := p.initExpr(d.Offset); err != nil {
+				return err
+			}
+			p.printf(" %s)", quote(d.Data))
+		}
+	}
+
+	p.buf.WriteString(")\n")
+	return nil
+}
+
+var externalKeywords = map[wasm.External]string{
+	wasm.ExternalFunction: "func",
+	wasm.ExternalTable:    "table",
+	wasm.ExternalMemory:   "memory",
+	wasm.ExternalGlobal:   "global",
+}
+
+// signature writes the parameters and results of sig, naming the
+// parameters after locals if it isn't nil.
+func (p *printer) signature(sig wasm.FunctionSig, locals map[uint32]string) {
+	if len(sig.ParamTypes) > 0 {
+		unnamed := false
+		for i, t := range sig.ParamTypes {
+			if id, ok := locals[uint32(i)]; ok {
+				if unnamed {
+					p.buf.WriteByte(')')
+					unnamed = false
+				}
+				p.printf(" (param %s %s)", id, t)
+				continue
+			}
+			if !unnamed {
+				p.buf.WriteString(" (param")
+				unnamed = true
+			}
+			p.printf(" %s", t)
+		}
+		if unnamed {
+			p.buf.WriteByte(')')
+		}
+	}
+	if len(sig.ReturnTypes) > 0 {
+		p.buf.WriteString(" (result")
+		for _, t := range sig.ReturnTypes {
+			p.printf(" %s", t)
+		}
+		p.buf.WriteByte(')')
+	}
+}
+
+func (p *printer) limits(l wasm.ResizableLimits) {
+	p.printf(" %d", l.Initial)
+	if l.Flags&1 != 0 {
+		p.printf(" %d", l.Maximum)
+	}
+}
+
+func (p *printer) table(t wasm.Table) {
+	p.limits(t.Limits)
+	p.printf(" %s", t.ElementType)
+}
+
+func (p *printer) globalType(g wasm.GlobalVar) {
+	if g.Mutable {
+		p.printf(" (mut %s)", g.Type)
+	} else {
+		p.printf(" %s", g.Type)
+	}
+}
+
+// function writes the function with the given index, of type t.
+func (p *printer) function(index, t uint32, body *wasm.FunctionBody) error {
+	sig := p.sig(t)
+	locals := p.localNames[index]
+
+	p.newline(1)
+	p.buf.WriteString("(func")
+	p.index(p.funcNames, index)
+	p.printf(" (type %d)", t)
+	p.signature(*sig, locals)
+
+	local := uint32(len(sig.ParamTypes))
+	for _, entry := range body.Locals {
+		for i := uint32(0); i < entry.Count; i++ {
+			if id, ok := locals[local]; ok {
+				p.newline(2)
+				p.printf("(local %s %s)", id, entry.Type)
+			} else {
+				p.newline(2)
+				p.printf("(local %s)", entry.Type)
+			}
+			local++
+		}
+	}
+
+	d, err := disasm.Disassemble(wasm.Function{Sig: sig, Body: body}, p.view, wasm.AllFeatures)
+	if err != nil {
+		return err
+	}
+	p.instrs(d.Code, 2, locals)
+	p.buf.WriteByte(')')
+	return nil
+}
+
+// initExpr writes the initializer expression expr, in the folded form.
+func (p *printer) initExpr(expr []byte) error {
+	if len(expr) > 0 && expr[len(expr)-1] == ops.End {
+		expr = expr[:len(expr)-1]
+	}
+	if len(expr) == 0 {
+		return wasm.ErrEmptyInitExpr
+	}
+	fn := wasm.Function{
+		Sig:  &wasm.FunctionSig{},
+		Body: &wasm.FunctionBody{Code: expr},
+	}
+	d, err := disasm.Disassemble(fn, p.view, wasm.AllFeatures)
+	if err != nil {
+		return err
+	}
+	for _, instr := range d.Code {
+		p.printf(" (%s", instr.Op.Name)
+		p.immediates(instr, nil)
+		p.buf.WriteByte(')')
+	}
+	return nil
+}
+
+// instrs writes the instructions in the flat form, each on a new line
+// indented by depth levels plus its nesting level in the instructions.
+func (p *printer) instrs(instrs []disasm.Instr, depth int, locals map[uint32]string) {
+	for _, instr := range instrs {
+		switch instr.Op.Code {
+		case ops.Else, ops.End:
+			depth--
+		}
+		p.newline(depth)
+		p.buf.WriteString(instr.Op.Name)
+		p.immediates(instr, locals)
+		switch instr.Op.Code {
+		case ops.Block, ops.Loop, ops.If, ops.Else:
+			depth++
+		}
+	}
+}
+
+// immediates writes the immediate arguments of instr.
+func (p *printer) immediates(instr disasm.Instr, locals map[uint32]string) {
+	imms := instr.Immediates
+	switch code := instr.Op.Code; {
+	case code == ops.Block || code == ops.Loop || code == ops.If:
+		bt := imms[0].(wasm.BlockType)
+		if index, ok := bt.TypeIndex(); ok {
+			p.printf(" (type %d)", index)
+		} else if bt != wasm.BlockTypeEmpty {
+			p.printf(" (result %s)", wasm.ValueType(bt))
+		}
+	case code == ops.BrTable:
+		for _, target := range imms[1:] {
+			p.printf(" %d", target)
+		}
+	case code == ops.Call:
+		p.ref(p.funcNames, imms[0].(uint32))
+	case code == ops.CallIndirect:
+		p.printf(" (type %d)", imms[0])
+	case code == ops.GetLocal || code == ops.SetLocal || code == ops.TeeLocal:
+		p.ref(locals, imms[0].(uint32))
+	case code >= ops.I32Load && code <= ops.I64Store32:
+		align, offset := imms[0].(uint32), imms[1].(uint32)
+		if offset != 0 {
+			p.printf(" offset=%d", offset)
+		}
+		if align != naturalAlignment(code) {
+			p.printf(" align=%d", uint64(1)<<align)
+		}
+	case code == ops.CurrentMemory || code == ops.GrowMemory:
+		// reserved immediate
+	case code == ops.MiscPrefix:
+		// opcode following the prefix
+	case code == ops.F32Const:
+		p.printf(" %s", formatFloat(uint64(math.Float32bits(imms[0].(float32))), 32))
+	case code == ops.F64Const:
+		p.printf(" %s", formatFloat(math.Float64bits(imms[0].(float64)), 64))
+	default:
+		for _, imm := range imms {
+			p.printf(" %v", imm)
+		}
+	}
+}
+
+// formatFloat formats the floating point number with the IEEE 754 bits v
+// of the given size, so that it is parsed back to the same bits.
+func formatFloat(v uint64, bits int) string {
+	var signBit, expMask, quietBit uint64 = 1 << 63, 0x7ff << 52, 1 << 51
+	if bits == 32 {
+		signBit, expMask, quietBit = 1<<31, 0xff<<23, 1<<22
+	}
+
+	sign := ""
+	if v&signBit != 0 {
+		sign = "-"
+	}
+	payload := v & (quietBit<<1 - 1)
+	switch {
+	case v&expMask != expMask:
+		if bits == 32 {
+			return strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32)
+		}
+		return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+	case payload == 0:
+		return sign + "inf"
+	case payload == quietBit:
+		return sign + "nan"
+	default:
+		return fmt.Sprintf("%snan:%#x", sign, payload)
+	}
+}
+
+// quote returns the string literal for b. Printable ASCII characters are
+// written as is, others are escaped.
+func quote(b []byte) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "\\%02x", c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,677 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// Result is the outcome of a command of a script.
+type Result struct {
+	Pos     Pos    // Position of the command in the script
+	Command string // Name of the command, like assert_return
+	Err     error  // Why the command failed, nil if it passed
+}
+
+// Passed reports whether the command passed.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%v: %s: FAIL: %v", r.Pos, r.Command, r.Err)
+	}
+	return fmt.Sprintf("%v: %s: ok", r.Pos, r.Command)
+}
+
+// RunScript runs the commands of the script src, in the format of the .wast
+// files of the WebAssembly specification tests, and returns the result of
+// each of them.
+//
+// Modules are decoded with wasm.ReadModule, validated with
+// validate.VerifyModule and executed by an exec.VM created with opts, all
+// of them using the given features.
+// They may import the instances registered by the script, with which they
+// share memories, tables and globals (see exec.Import), and an instance of
+// the spectest module of the specification tests, whose functions print
+// nothing.
+//
+// The supported commands are module, register, invoke, get, assert_return
+// (including the nan:canonical and nan:arithmetic patterns), assert_trap,
+// assert_exhaustion, assert_invalid, assert_malformed and
+// assert_unlinkable, whose module must be rejected at the stage named by
+// the assertion. An error is returned only if src isn't a well-formed list
+// of commands.
+func RunScript(src []byte, features wasm.Features, opts ...exec.VMOption) ([]Result, error) {
+	nodes, err := parseNodes(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		if n.head() == "" {
+			return nil, errorf(n.pos, "expected command, got %v", n)
+		}
+	}
+
+	r := &runner{
+		features:   features,
+		opts:       opts,
+		instances:  make(map[string]*instance),
+		registered: make(map[string]*instance),
+	}
+	m := spectest()
+	vm, err := exec.NewVM(m, features, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.registered["spectest"] = &instance{m: m, vm: vm}
+	results := make([]Result, len(nodes))
+	for i, n := range nodes {
+		results[i] = Result{Pos: n.pos, Command: n.head(), Err: r.run(n)}
+	}
+	return results, nil
+}
+
+// instance is a module instantiated by a script.
+type instance struct {
+	m  *wasm.Module
+	vm *exec.VM
+}
+
+type runner struct {
+	features   wasm.Features
+	opts       []exec.VMOption
+	current    *instance            // the last module defined
+	instances  map[string]*instance // modules defined with an identifier
+	registered map[string]*instance
+}
+
+// errNoModule is the failure of the commands using a module when the
+// script didn't define one, or failed to.
+var errNoModule = errors.New("no module defined")
+
+// run runs the command n, and returns why it failed.
+func (r *runner) run(n *node) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	items := n.args()
+	switch cmd := n.head(); cmd {
+	case "module":
+		r.current = nil
+		inst, err := r.instantiate(n)
+		if err != nil {
+			return err
+		}
+		r.current = inst
+		if id, _ := id(items, 0); id != nil {
+			r.instances[id.tok.text] = inst
+		}
+		return nil
+	case "register":
+		name, err := str(items, 0, n.pos)
+		if err != nil {
+			return err
+		}
+		inst, err := r.instance(items, 1)
+		if err != nil {
+			return err
+		}
+		r.registered[name] = inst
+		return nil
+	case "invoke", "get":
+		_, err := r.action(n)
+		return err
+	case "assert_return", "assert_return_canonical_nan", "assert_return_arithmetic_nan":
+		if len(items) == 0 {
+			return errorf(n.pos, "missing action")
+		}
+		want, err := expectedResults(cmd, items[1:])
+		if err != nil {
+			return err
+		}
+		got, err := r.action(items[0])
+		if err != nil {
+			return err
+		}
+		if len(got) != len(want) {
+			return fmt.Errorf("got %d results, want %d", len(got), len(want))
+		}
+		for i := range got {
+			if !want[i].matches(got[i]) {
+				return fmt.Errorf("got %v, want %v", got[i], want[i])
+			}
+		}
+		return nil
+	case "assert_trap":
+		if len(items) != 2 || !items[1].isString() {
+			return errorf(n.pos, "expected action or module, and message")
+		}
+		var err error
+		if items[0].head() == "module" {
+			_, err = r.instantiate(items[0])
+		} else {
+			_, err = r.action(items[0])
+		}
+		return checkTrap(err, items[1].tok.text)
+	case "assert_exhaustion":
+		if len(items) != 2 || !items[1].isString() {
+			return errorf(n.pos, "expected action and message")
+		}
+		_, err := r.action(items[0])
+		if err == nil {
+			return fmt.Errorf("no trap, want %q", items[1].tok.text)
+		}
+		if trap, ok := err.(exec.Trap); !ok || trap.Kind != exec.TrapStackExhausted {
+			return fmt.Errorf("got %v, want %q", err, items[1].tok.text)
+		}
+		return nil
+	case "assert_invalid", "assert_malformed", "assert_unlinkable":
+		if len(items) != 2 || items[0].head() != "module" || !items[1].isString() {
+			return errorf(n.pos, "expected module and message")
+		}
+		var err error
+		if cmd == "assert_unlinkable" {
+			_, err = r.instantiate(items[0])
+		} else {
+			_, err = r.compile(items[0])
+		}
+		if err == nil {
+			return fmt.Errorf("module was accepted, want %q", items[1].tok.text)
+		}
+		if e, ok := err.(rejection); !ok || "assert_"+e.kind != cmd {
+			return fmt.Errorf("%v, want %s %q", err, cmd, items[1].tok.text)
+		}
+		return nil
+	default:
+		return errorf(n.pos, "unknown command %s", cmd)
+	}
+}
+
+// moduleBinary returns the binary encoding of the module n, defined like
+// in moduleNode.
+func moduleBinary(n *node) ([]byte, error) {
+	items := n.args()
+	_, i := id(items, 0)
+	if i < len(items) && items[i].isAtom() && items[i].tok.text == "binary" {
+		return dataString(items[i+1:])
+	}
+	m, err := moduleNode(n)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.WriteModule(buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rejection is the error rejecting a module. kind tells the stage at which
+// it was rejected, as named by the assertions: "malformed" if it couldn't
+// be decoded, "invalid" if it didn't validate, and "unlinkable" if it
+// couldn't be instantiated.
+type rejection struct {
+	kind string
+	err  error
+}
+
+func (e rejection) Error() string {
+	return fmt.Sprintf("%s module: %v", e.kind, e.err)
+}
+
+// readError returns the rejection of a module by wasm.ReadModule, which
+// also checks some of the rules of the validation and resolves the
+// imports.
+func readError(err error) error {
+	switch err.(type) {
+	case rejection:
+		return err
+	case wasm.ExportNotFoundError, wasm.KindMismatchError,
+		wasm.ImportSigMismatchError, wasm.ImportGlobalMismatchError,
+		wasm.ElementSegmentOutOfBoundsError:
+		return rejection{"unlinkable", err}
+	case wasm.InvalidTypeIndexError, wasm.InvalidFunctionIndexError,
+		wasm.InvalidGlobalIndexError, wasm.InvalidTableIndexError,
+		wasm.InvalidLinearMemoryIndexError, wasm.InvalidValueTypeInitExprError,
+		wasm.InvalidInitExprOpError, wasm.DuplicateExportError:
+		return rejection{"invalid", err}
+	}
+	if err == wasm.ErrNoExportsInImportedModule {
+		return rejection{"unlinkable", err}
+	}
+	return rejection{"malformed", err}
+}
+
+// compile decodes and validates the module n.
+func (r *runner) compile(n *node) (*wasm.Module, error) {
+	b, err := moduleBinary(n)
+	if err != nil {
+		return nil, rejection{"malformed", err}
+	}
+	m, err := wasm.ReadModule(bytes.NewReader(b), r.resolve, r.features)
+	if err != nil {
+		return nil, readError(err)
+	}
+	if err := validate.VerifyModule(m, r.features); err != nil {
+		return nil, rejection{"invalid", err}
+	}
+	return m, nil
+}
+
+// instantiate compiles the module n, and creates a VM for it. The traps of
+// its start function are returned as is.
+func (r *runner) instantiate(n *node) (*instance, error) {
+	m, err := r.compile(n)
+	if err != nil {
+		return nil, err
+	}
+	opts := r.opts
+	for name, inst := range r.registered {
+		opts = append(opts[:len(opts):len(opts)], exec.Import(name, inst.vm))
+	}
+	vm, err := exec.NewVM(m, r.features, opts...)
+	if err != nil {
+		if _, ok := err.(exec.Trap); ok {
+			return nil, err
+		}
+		return nil, rejection{"unlinkable", err}
+	}
+	return &instance{m: m, vm: vm}, nil
+}
+
+// resolve resolves the imports of the modules of the script.
+func (r *runner) resolve(name string) (*wasm.Module, error) {
+	if inst, ok := r.registered[name]; ok {
+		return inst.m, nil
+	}
+	return nil, rejection{"unlinkable", fmt.Errorf("unknown module %q", name)}
+}
+
+// instance returns the module named by the optional identifier at
+// items[i], or the current module.
+func (r *runner) instance(items []*node, i int) (*instance, error) {
+	if id, _ := id(items, i); id != nil {
+		inst, ok := r.instances[id.tok.text]
+		if !ok {
+			return nil, errorf(id.pos, "unknown module %s", id.tok.text)
+		}
+		return inst, nil
+	}
+	if r.current == nil {
+		return nil, errNoModule
+	}
+	return r.current, nil
+}
+
+// action performs the action n, (invoke $id? name const*) or
+// (get $id? name), and returns its results.
+func (r *runner) action(n *node) ([]value, error) {
+	items := n.args()
+	inst, err := r.instance(items, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, i := id(items, 0)
+	name, err := str(items, i, n.pos)
+	if err != nil {
+		return nil, err
+	}
+	args := items[i+1:]
+
+	var res interface{}
+	switch n.head() {
+	case "invoke":
+		fn, err := inst.vm.Export(name)
+		if err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := constValue(arg)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v.goValue()
+		}
+		if res, err = fn.Call(vals...); err != nil {
+			return nil, err
+		}
+	case "get":
+		if len(args) != 0 {
+			return nil, errorf(args[0].pos, "unexpected %v in get", args[0])
+		}
+		if res, err = inst.vm.Global(name); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errorf(n.pos, "expected action, got %v", n)
+	}
+
+	switch res := res.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		vals := make([]value, len(res))
+		for i, v := range res {
+			vals[i] = valueOf(v)
+		}
+		return vals, nil
+	}
+	return []value{valueOf(res)}, nil
+}
+
+// trapMessages maps the messages of assert_trap to the corresponding
+// kinds of traps.
+var trapMessages = map[string]exec.TrapKind{
+	"unreachable":                      exec.TrapUnreachable,
+	"out of bounds memory access":      exec.TrapOutOfBoundsMemory,
+	"integer overflow":                 exec.TrapIntegerOverflow,
+	"integer divide by zero":           exec.TrapIntegerDivideByZero,
+	"invalid conversion to integer":    exec.TrapInvalidConversion,
+	"indirect call type mismatch":      exec.TrapIndirectCallMismatch,
+	"indirect call signature mismatch": exec.TrapIndirectCallMismatch,
+	"undefined element":                exec.TrapUndefinedElement,
+	"uninitialized element":            exec.TrapUndefinedElement,
+	"call stack exhausted":             exec.TrapStackExhausted,
+}
+
+// checkTrap checks that err is a trap matching the message msg of
+// assert_trap, which must be known to trapMessages.
+func checkTrap(err error, msg string) error {
+	kind, ok := trapMessages[msg]
+	if !ok {
+		return fmt.Errorf("unknown trap %q", msg)
+	}
+	if err == nil {
+		return fmt.Errorf("no trap, want %q", msg)
+	}
+	trap, ok := err.(exec.Trap)
+	if !ok {
+		return err
+	}
+	if kind != trap.Kind {
+		return fmt.Errorf("got trap %q, want %q", trap.Kind, msg)
+	}
+	return nil
+}
+
+// value is a WebAssembly value.
+type value struct {
+	typ  wasm.ValueType
+	bits uint64
+}
+
+// valueOf returns the value of v, an int32, int64, float32 or float64.
+func valueOf(v interface{}) value {
+	switch v := v.(type) {
+	case int32:
+		return value{wasm.ValueTypeI32, uint64(uint32(v))}
+	case int64:
+		return value{wasm.ValueTypeI64, uint64(v)}
+	case float32:
+		return value{wasm.ValueTypeF32, uint64(math.Float32bits(v))}
+	case float64:
+		return value{wasm.ValueTypeF64, math.Float64bits(v)}
+	}
+	panic(fmt.Sprintf("wast: invalid value type %v", reflect.TypeOf(v)))
+}
+
+// goValue returns the Go value of v, as passed to exec.ExportedFunction.
+func (v value) goValue() interface{} {
+	switch v.typ {
+	case wasm.ValueTypeI32:
+		return int32(v.bits)
+	case wasm.ValueTypeI64:
+		return int64(v.bits)
+	case wasm.ValueTypeF32:
+		return math.Float32frombits(uint32(v.bits))
+	default:
+		return math.Float64frombits(v.bits)
+	}
+}
+
+func (v value) String() string {
+	var s string
+	switch v.typ {
+	case wasm.ValueTypeI32:
+		s = fmt.Sprint(int32(v.bits))
+	case wasm.ValueTypeI64:
+		s = fmt.Sprint(int64(v.bits))
+	case wasm.ValueTypeF32:
+		s = formatFloat(v.bits, 32)
+	default:
+		s = formatFloat(v.bits, 64)
+	}
+	return fmt.Sprintf("(%v.const %s)", v.typ, s)
+}
+
+// initExpr returns the initializer expression yielding v.
+func (v value) initExpr() []byte {
+	var expr []byte
+	switch v.typ {
+	case wasm.ValueTypeI32:
+		expr = leb128.AppendVarint32([]byte{ops.I32Const}, int32(v.bits))
+	case wasm.ValueTypeI64:
+		expr = leb128.AppendVarint64([]byte{ops.I64Const}, int64(v.bits))
+	case wasm.ValueTypeF32:
+		expr = make([]byte, 5)
+		expr[0] = ops.F32Const
+		binary.LittleEndian.PutUint32(expr[1:], uint32(v.bits))
+	default:
+		expr = make([]byte, 9)
+		expr[0] = ops.F64Const
+		binary.LittleEndian.PutUint64(expr[1:], v.bits)
+	}
+	return append(expr, ops.End)
+}
+
+// constTypes maps the const operators to the type of their value.
+var constTypes = map[string]wasm.ValueType{
+	"i32.const": wasm.ValueTypeI32,
+	"i64.const": wasm.ValueTypeI64,
+	"f32.const": wasm.ValueTypeF32,
+	"f64.const": wasm.ValueTypeF64,
+}
+
+// constNode returns the type and the literal of the constant n, of the form
+// (t.const literal).
+func constNode(n *node) (wasm.ValueType, *node, error) {
+	typ, ok := constTypes[n.head()]
+	if !ok || len(n.list) != 2 || !n.list[1].isAtom() {
+		return 0, nil, errorf(n.pos, "expected constant, got %v", n)
+	}
+	return typ, n.list[1], nil
+}
+
+// constValue returns the value of the constant n.
+func constValue(n *node) (value, error) {
+	typ, lit, err := constNode(n)
+	if err != nil {
+		return value{}, err
+	}
+	bits, text := 32, lit.tok.text
+	if typ == wasm.ValueTypeI64 || typ == wasm.ValueTypeF64 {
+		bits = 64
+	}
+	var v uint64
+	if typ == wasm.ValueTypeI32 || typ == wasm.ValueTypeI64 {
+		v, err = parseInt(text, bits)
+	} else {
+		v, err = parseFloat(text, bits)
+	}
+	if err != nil {
+		return value{}, errorf(lit.pos, "invalid %v constant %s", typ, text)
+	}
+	return value{typ, v}, nil
+}
+
+// expected is a result expected by assert_return.
+type expected struct {
+	value
+	nan string // "canonical" or "arithmetic" for NaN patterns, "" otherwise
+}
+
+// expectedResults parses the results expected by the assertion cmd.
+func expectedResults(cmd string, items []*node) ([]expected, error) {
+	var nan string
+	switch cmd {
+	case "assert_return_canonical_nan":
+		nan = "canonical"
+	case "assert_return_arithmetic_nan":
+		nan = "arithmetic"
+	}
+	if nan != "" {
+		// The legacy forms expect a single NaN, of the type of the result.
+		if len(items) != 0 {
+			return nil, errorf(items[0].pos, "unexpected %v in %s", items[0], cmd)
+		}
+		return []expected{{nan: nan}}, nil
+	}
+
+	want := make([]expected, len(items))
+	for i, n := range items {
+		typ, lit, err := constNode(n)
+		if err != nil {
+			return nil, err
+		}
+		if text := lit.tok.text; (typ == wasm.ValueTypeF32 || typ == wasm.ValueTypeF64) && strings.HasPrefix(text, "nan:") {
+			if p := text[len("nan:"):]; p == "canonical" || p == "arithmetic" {
+				want[i] = expected{value{typ: typ}, p}
+				continue
+			}
+		}
+		v, err := constValue(n)
+		if err != nil {
+			return nil, err
+		}
+		want[i] = expected{value: v}
+	}
+	return want, nil
+}
+
+// matches reports whether v is the expected value, or matches the expected
+// NaN pattern.
+func (e expected) matches(v value) bool {
+	if e.nan == "" {
+		return v == e.value
+	}
+	if e.typ != 0 && v.typ != e.typ {
+		return false
+	}
+
+	var expMask, quietBit uint64 = 0x7ff << 52, 1 << 51
+	switch v.typ {
+	case wasm.ValueTypeF32:
+		expMask, quietBit = 0xff<<23, 1<<22
+	case wasm.ValueTypeF64:
+	default:
+		return false
+	}
+	payload := v.bits & (quietBit<<1 - 1)
+	if v.bits&expMask != expMask || payload&quietBit == 0 {
+		return false
+	}
+	return e.nan == "arithmetic" || payload == quietBit
+}
+
+func (e expected) String() string {
+	if e.nan == "" {
+		return e.value.String()
+	}
+	if e.typ == 0 {
+		return "nan:" + e.nan
+	}
+	return fmt.Sprintf("(%v.const nan:%s)", e.typ, e.nan)
+}
+
+// spectest returns the spectest module imported by the specification
+// tests, which RunScript instantiates and registers before running the
+// commands.
+func spectest() *wasm.Module {
+	m := wasm.NewModule()
+	export := func(name string, kind wasm.External, index uint32) {
+		m.Export.Entries[name] = wasm.ExportEntry{FieldStr: name, Kind: kind, Index: index}
+	}
+
+	for _, fn := range []struct {
+		name string
+		host interface{}
+	}{
+		{"print", func() {}},
+		{"print_i32", func(int32) {}},
+		{"print_i64", func(int64) {}},
+		{"print_f32", func(float32) {}},
+		{"print_f64", func(float64) {}},
+		{"print_i32_f32", func(int32, float32) {}},
+		{"print_f64_f64", func(float64, float64) {}},
+	} {
+		host := reflect.ValueOf(fn.host)
+		sig := wasm.FunctionSig{Form: -0x20}
+		for i := 0; i < host.Type().NumIn(); i++ {
+			sig.ParamTypes = append(sig.ParamTypes, goValueTypes[host.Type().In(i).Kind()])
+		}
+		export(fn.name, wasm.ExternalFunction, uint32(len(m.FunctionIndexSpace)))
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, wasm.Function{
+			Sig:  &sig,
+			Host: host,
+			Body: &wasm.FunctionBody{},
+			Name: fn.name,
+		})
+	}
+
+	for _, v := range []value{
+		valueOf(int32(666)),
+		valueOf(int64(666)),
+		valueOf(float32(666.6)),
+		valueOf(float64(666.6)),
+	} {
+		name := fmt.Sprintf("global_%v", v.typ)
+		export(name, wasm.ExternalGlobal, uint32(len(m.GlobalIndexSpace)))
+		m.GlobalIndexSpace = append(m.GlobalIndexSpace, wasm.GlobalEntry{
+			Type: &wasm.GlobalVar{Type: v.typ},
+			Init: v.initExpr(),
+		})
+	}
+
+	table := wasm.Table{ElementType: wasm.ElemTypeAnyFunc, Limits: wasm.ResizableLimits{Flags: 1, Initial: 10, Maximum: 20}}
+	m.Table = &wasm.SectionTables{Entries: []wasm.Table{table}}
+	m.TableIndexSpace = [][]uint32{make([]uint32, table.Limits.Initial)}
+	for i := range m.TableIndexSpace[0] {
+		m.TableIndexSpace[0][i] = wasm.UninitializedElement
+	}
+	export("table", wasm.ExternalTable, 0)
+
+	memory := wasm.Memory{Limits: wasm.ResizableLimits{Flags: 1, Initial: 1, Maximum: 2}}
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{memory}}
+	m.LinearMemoryIndexSpace = [][]byte{make([]byte, wasmPageSize*memory.Limits.Initial)}
+	export("memory", wasm.ExternalMemory, 0)
+	return m
+}
+
+// goValueTypes maps the kinds of the parameters of the spectest host
+// functions to their WebAssembly type.
+var goValueTypes = map[reflect.Kind]wasm.ValueType{
+	reflect.Int32:   wasm.ValueTypeI32,
+	reflect.Int64:   wasm.ValueTypeI64,
+	reflect.Float32: wasm.ValueTypeF32,
+	reflect.Float64: wasm.ValueTypeF64,
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+// node is an S-expression: either a list of nodes, or a single token.
+type node struct {
+	pos    Pos
+	isList bool
+	list   []*node // elements of the list
+	tok    token   // for atoms and strings
+}
+
+// parseNodes parses all the S-expressions in src.
+func parseNodes(src []byte) ([]*node, error) {
+	l := newLexer(src)
+	var stack [][]*node // elements of the enclosing lists
+	var starts []Pos
+	var nodes []*node
+
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.kind {
+		case tokEOF:
+			if len(stack) != 0 {
+				return nil, errorf(starts[len(starts)-1], "unclosed parenthesis")
+			}
+			return nodes, nil
+		case tokLParen:
+			stack = append(stack, nodes)
+			starts = append(starts, tok.pos)
+			nodes = nil
+		case tokRParen:
+			if len(stack) == 0 {
+				return nil, errorf(tok.pos, "unexpected )")
+			}
+			list := &node{pos: starts[len(starts)-1], isList: true, list: nodes}
+			nodes = append(stack[len(stack)-1], list)
+			stack = stack[:len(stack)-1]
+			starts = starts[:len(starts)-1]
+		default:
+			nodes = append(nodes, &node{pos: tok.pos, tok: tok})
+		}
+	}
+}
+
+// isAtom reports whether n is an atom.
+func (n *node) isAtom() bool {
+	return !n.isList && n.tok.kind == tokAtom
+}
+
+// isString reports whether n is a string.
+func (n *node) isString() bool {
+	return !n.isList && n.tok.kind == tokString
+}
+
+// isID reports whether n is a symbolic identifier, like $foo.
+func (n *node) isID() bool {
+	return n.isAtom() && len(n.tok.text) > 1 && n.tok.text[0] == '$'
+}
+
+// head returns the keyword starting the list n, or "" if n isn't a list
+// starting with an atom.
+func (n *node) head() string {
+	if !n.isList || len(n.list) == 0 || !n.list[0].isAtom() {
+		return ""
+	}
+	return n.list[0].tok.text
+}
+
+// args returns the elements of the list n following its head.
+func (n *node) args() []*node {
+	if len(n.list) == 0 {
+		return nil
+	}
+	return n.list[1:]
+}
+
+func (n *node) String() string {
+	switch {
+	case n.isList:
+		if h := n.head(); h != "" {
+			return "(" + h + " ...)"
+		}
+		return "(...)"
+	case n.isString():
+		return "string"
+	default:
+		return n.tok.text
+	}
+}
@@ -0,0 +1,65 @@
+(module
+  (memory 1)
+
+  ;; the stack is polymorphic after unreachable, br, br_table and return
+  (func (export "unreachable") (result i32) (unreachable))
+  (func (export "unreachable-add") (result i64) (unreachable) (i64.add))
+  (func (export "br") (result i32)
+    (block (result i32) (br 0 (i32.const 1)) (i64.add) (i64.eqz)))
+  (func (export "br_table") (param i32) (result i32)
+    (block (result i32)
+      (block (result i32)
+        (br_table 0 1 (i32.const 2) (get_local 0))
+        (select))
+      (i32.const 3)
+      (i32.add)))
+  (func (export "return") (result f64)
+    (return (f64.const 4)) (drop) (drop))
+  (func (export "select") (param i32) (result f32)
+    (block (result f32) (unreachable) (select (f32.const 5) (get_local 0))))
+
+  (func (export "if") (param i32) (result i32)
+    (if (result i32) (get_local 0)
+      (then (return (i32.const 6)))
+      (else (i32.const 7))))
+  (func (export "store") (param i32)
+    (i32.store8 (get_local 0) (i32.const 8)))
+  (func (export "load") (param i32) (result i32)
+    (i32.load8_u (get_local 0)))
+)
+
+(assert_return (invoke "br") (i32.const 1))
+(assert_return (invoke "br_table" (i32.const 0)) (i32.const 5))
+(assert_return (invoke "br_table" (i32.const 1)) (i32.const 2))
+(assert_return (invoke "return") (f64.const 4))
+(assert_return (invoke "if" (i32.const 1)) (i32.const 6))
+(assert_return (invoke "if" (i32.const 0)) (i32.const 7))
+(assert_return (invoke "store" (i32.const 9)))
+(assert_return (invoke "load" (i32.const 9)) (i32.const 8))
+
+(assert_invalid (module (func (result i32) (i64.const 0))) "type mismatch")
+(assert_invalid (module (func (result i32))) "type mismatch")
+(assert_invalid (module (func (i32.const 0))) "type mismatch")
+(assert_invalid (module (func (i32.const 0) (block (drop)))) "type mismatch")
+(assert_invalid (module (func (result i32) (i32.const 0) (block (result i32)))) "type mismatch")
+(assert_invalid (module (func (block (i32.const 0)))) "type mismatch")
+(assert_invalid (module (func (unreachable) (i64.add (i32.const 0)))) "type mismatch")
+(assert_invalid (module (func (result i32) (block (result i32) (br 0 (i64.const 0))))) "type mismatch")
+(assert_invalid (module (func (block (br 1)) (br 2))) "unknown label")
+(assert_invalid
+  (module (func (param i32)
+    (block (result i32) (block (br_table 0 1 (i32.const 0) (get_local 0))) (i32.const 0))
+    (drop)))
+  "type mismatch")
+(assert_invalid
+  (module (func (param i32) (result i32)
+    (block (result f32) (br_table 0 1 (i32.const 0) (get_local 0)))
+    (drop) (i32.const 0)))
+  "type mismatch")
+(assert_invalid (module (func (if (i32.const 0) (then (i32.const 0))))) "type mismatch")
+(assert_invalid (module (func (result i32) (if (result i32) (i32.const 0) (then (i32.const 0))))) "type mismatch")
+(assert_invalid (module (func (select (i32.const 0) (i64.const 0) (i32.const 0)) (drop))) "type mismatch")
+(assert_invalid (module (global i32 (i32.const 0)) (func (set_global 0 (i32.const 1)))) "global is immutable")
+(assert_invalid (module (func (drop (i32.load (i32.const 0))))) "unknown memory")
+(assert_invalid (module (memory 1) (func (drop (i32.load align=8 (i32.const 0))))) "alignment")
+(assert_invalid (module (memory 1) (func (i32.store (i32.const 0)))) "type mismatch")
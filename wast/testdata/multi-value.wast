@@ -0,0 +1,73 @@
+(module
+  (type $pair (func (param i32 i32) (result i32 i32)))
+
+  (func $swap (type $pair) (get_local 1) (get_local 0))
+  (func (export "swap") (param i32 i32) (result i32 i32)
+    (call $swap (get_local 0) (get_local 1)))
+
+  (func (export "block") (result i32 i64)
+    (block (result i32 i64) (i32.const 1) (i64.const 2)))
+  (func (export "block-params") (result i32)
+    (i32.const 3) (i32.const 4)
+    (block (param i32 i32) (result i32) (i32.sub)))
+  (func (export "block-type") (result i32 i32)
+    (i32.const 5) (i32.const 6)
+    (block (type $pair) (call $swap)))
+
+  (func (export "br") (result i32 i64)
+    (block (result i32 i64)
+      (i32.const 7) (i32.const 1) (i64.const 2) (br 0)))
+  (func (export "br_if") (param i32) (result i32 i32)
+    (block (result i32 i32)
+      (i32.const 8) (i32.const 1) (i32.const 2) (get_local 0) (br_if 0)
+      (drop) (drop) (drop) (i32.const 3) (i32.const 4)))
+  (func (export "br_table") (param i32) (result i32 i32)
+    (block (result i32 i32)
+      (block (result i32 i32)
+        (i32.const 9) (i32.const 10) (i32.const 11) (get_local 0)
+        (br_table 0 1 2))
+      (i32.add) (i32.const 100))
+    (i32.add) (i32.const 1000))
+  (func (export "br-func") (result i32 f64)
+    (block (i32.const 12) (f64.const 1.5) (br 1)) (i32.const 0) (f64.const 0))
+  (func (export "return") (result i64 i32)
+    (i64.const 13) (i64.const 14) (i32.const 15) (return))
+
+  (func (export "if") (param i32) (result i32 i32)
+    (i32.const 16) (i32.const 17) (get_local 0)
+    (if (param i32 i32) (result i32 i32)
+      (then (call $swap))
+      (else (i32.add) (i32.const 0))))
+  (func (export "loop") (param i32) (result i32)
+    (i32.const 0) (get_local 0)
+    (loop (param i32 i32) (result i32)
+      (set_local 0)
+      (i32.add (get_local 0))
+      (get_local 0) (i32.sub (i32.const 1))
+      (tee_local 0)
+      (br_if 0 (get_local 0))
+      (drop)))
+)
+
+(assert_return (invoke "swap" (i32.const 1) (i32.const 2)) (i32.const 2) (i32.const 1))
+(assert_return (invoke "block") (i32.const 1) (i64.const 2))
+(assert_return (invoke "block-params") (i32.const -1))
+(assert_return (invoke "block-type") (i32.const 6) (i32.const 5))
+(assert_return (invoke "br") (i32.const 1) (i64.const 2))
+(assert_return (invoke "br_if" (i32.const 1)) (i32.const 1) (i32.const 2))
+(assert_return (invoke "br_if" (i32.const 0)) (i32.const 3) (i32.const 4))
+(assert_return (invoke "br_table" (i32.const 0)) (i32.const 121) (i32.const 1000))
+(assert_return (invoke "br_table" (i32.const 1)) (i32.const 21) (i32.const 1000))
+(assert_return (invoke "br_table" (i32.const 2)) (i32.const 10) (i32.const 11))
+(assert_return (invoke "br-func") (i32.const 12) (f64.const 1.5))
+(assert_return (invoke "return") (i64.const 14) (i32.const 15))
+(assert_return (invoke "if" (i32.const 1)) (i32.const 17) (i32.const 16))
+(assert_return (invoke "if" (i32.const 0)) (i32.const 33) (i32.const 0))
+(assert_return (invoke "loop" (i32.const 4)) (i32.const 10))
+
+(assert_invalid
+  (module (func (result i32 i32) (block (result i32 i32) (i32.const 1) (i64.const 2))))
+  "type mismatch")
+(assert_invalid
+  (module (func (result i32) (block (param i64) (drop)) (i32.const 0)))
+  "type mismatch")
@@ -0,0 +1,45 @@
+(module
+  (func (export "i32.trunc_s:sat/f32") (param f32) (result i32) (i32.trunc_s:sat/f32 (get_local 0)))
+  (func (export "i32.trunc_u:sat/f32") (param f32) (result i32) (i32.trunc_u:sat/f32 (get_local 0)))
+  (func (export "i32.trunc_s:sat/f64") (param f64) (result i32) (i32.trunc_s:sat/f64 (get_local 0)))
+  (func (export "i32.trunc_u:sat/f64") (param f64) (result i32) (i32.trunc_u:sat/f64 (get_local 0)))
+  (func (export "i64.trunc_s:sat/f32") (param f32) (result i64) (i64.trunc_s:sat/f32 (get_local 0)))
+  (func (export "i64.trunc_u:sat/f32") (param f32) (result i64) (i64.trunc_u:sat/f32 (get_local 0)))
+  (func (export "i64.trunc_s:sat/f64") (param f64) (result i64) (i64.trunc_s:sat/f64 (get_local 0)))
+  (func (export "i64.trunc_u:sat/f64") (param f64) (result i64) (i64.trunc_u:sat/f64 (get_local 0)))
+  (func (export "new-name") (param f32) (result i32) (i32.trunc_sat_f32_s (get_local 0)))
+)
+
+(assert_return (invoke "i32.trunc_s:sat/f32" (f32.const -1.9)) (i32.const -1))
+(assert_return (invoke "i32.trunc_s:sat/f32" (f32.const 2147483520)) (i32.const 2147483520))
+(assert_return (invoke "i32.trunc_s:sat/f32" (f32.const 2147483648)) (i32.const 0x7fffffff))
+(assert_return (invoke "i32.trunc_s:sat/f32" (f32.const -2147483904)) (i32.const 0x80000000))
+(assert_return (invoke "i32.trunc_s:sat/f32" (f32.const inf)) (i32.const 0x7fffffff))
+(assert_return (invoke "i32.trunc_s:sat/f32" (f32.const nan)) (i32.const 0))
+(assert_return (invoke "i32.trunc_u:sat/f32" (f32.const 4294967040)) (i32.const -256))
+(assert_return (invoke "i32.trunc_u:sat/f32" (f32.const 4294967296)) (i32.const 0xffffffff))
+(assert_return (invoke "i32.trunc_u:sat/f32" (f32.const -0.9)) (i32.const 0))
+(assert_return (invoke "i32.trunc_u:sat/f32" (f32.const -inf)) (i32.const 0))
+(assert_return (invoke "i32.trunc_s:sat/f64" (f64.const 2147483647.9)) (i32.const 0x7fffffff))
+(assert_return (invoke "i32.trunc_s:sat/f64" (f64.const -2147483648.9)) (i32.const 0x80000000))
+(assert_return (invoke "i32.trunc_s:sat/f64" (f64.const -2147483649)) (i32.const 0x80000000))
+(assert_return (invoke "i32.trunc_s:sat/f64" (f64.const -nan)) (i32.const 0))
+(assert_return (invoke "i32.trunc_u:sat/f64" (f64.const 4294967295.9)) (i32.const 0xffffffff))
+(assert_return (invoke "i32.trunc_u:sat/f64" (f64.const 1e10)) (i32.const 0xffffffff))
+(assert_return (invoke "i32.trunc_u:sat/f64" (f64.const -1)) (i32.const 0))
+(assert_return (invoke "i64.trunc_s:sat/f32" (f32.const 9223371487098961920)) (i64.const 9223371487098961920))
+(assert_return (invoke "i64.trunc_s:sat/f32" (f32.const 9223372036854775808)) (i64.const 0x7fffffffffffffff))
+(assert_return (invoke "i64.trunc_s:sat/f32" (f32.const -9223373136366403584)) (i64.const 0x8000000000000000))
+(assert_return (invoke "i64.trunc_u:sat/f32" (f32.const 18446742974197923840)) (i64.const -1099511627776))
+(assert_return (invoke "i64.trunc_u:sat/f32" (f32.const 18446744073709551616)) (i64.const 0xffffffffffffffff))
+(assert_return (invoke "i64.trunc_u:sat/f32" (f32.const nan)) (i64.const 0))
+(assert_return (invoke "i64.trunc_s:sat/f64" (f64.const -4.5)) (i64.const -4))
+(assert_return (invoke "i64.trunc_s:sat/f64" (f64.const 9223372036854775808)) (i64.const 0x7fffffffffffffff))
+(assert_return (invoke "i64.trunc_s:sat/f64" (f64.const -inf)) (i64.const 0x8000000000000000))
+(assert_return (invoke "i64.trunc_u:sat/f64" (f64.const 18446744073709549568)) (i64.const -2048))
+(assert_return (invoke "i64.trunc_u:sat/f64" (f64.const 18446744073709551616)) (i64.const 0xffffffffffffffff))
+(assert_return (invoke "i64.trunc_u:sat/f64" (f64.const -0.5)) (i64.const 0))
+(assert_return (invoke "new-name" (f32.const 1e10)) (i32.const 0x7fffffff))
+
+(assert_invalid (module (func (result i32) (i32.trunc_s:sat/f32 (f64.const 0)))) "type mismatch")
+(assert_invalid (module (func (result i32) (i64.trunc_u:sat/f64 (f64.const 0)))) "type mismatch")
@@ -0,0 +1,76 @@
+;; Commands of the specification test scripts, which should all pass.
+
+(module $M1
+  (func $print (import "spectest" "print_i32") (param i32))
+  (global (export "g") i32 (i32.const 42))
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (get_local 0) (get_local 1)))
+  (func (export "div") (param i32 i32) (result i32)
+    (i32.div_s (get_local 0) (get_local 1)))
+  (func (export "f32") (param f32) (result f32) (get_local 0))
+  (func (export "nan") (result f64) (f64.div (f64.const 0) (f64.const 0)))
+  (func (export "print") (call $print (i32.const 1)))
+)
+
+(assert_return (invoke "add" (i32.const 1) (i32.const -3)) (i32.const -2))
+(assert_return (invoke "f32" (f32.const -0x1p-149)) (f32.const -0x1p-149))
+(assert_return (invoke "f32" (f32.const nan:0x600000)) (f32.const nan:arithmetic))
+(assert_return (invoke "nan") (f64.const nan:canonical))
+(assert_return_canonical_nan (invoke "nan"))
+(assert_return (get "g") (i32.const 42))
+(assert_trap (invoke "div" (i32.const 1) (i32.const 0)) "integer divide by zero")
+(assert_trap (invoke "div" (i32.const 0x80000000) (i32.const -1)) "integer overflow")
+(invoke "print")
+
+(register "M1" $M1)
+
+(module $M2
+  (import "M1" "add" (func $add (param i32 i32) (result i32)))
+  (import "spectest" "global_i32" (global i32))
+  (func (export "add") (result i32) (call $add (get_global 0) (i32.const 1)))
+  (func (export "unreachable") unreachable)
+  (func $loop (export "loop") (call $loop))
+)
+
+(assert_return (invoke $M2 "add") (i32.const 667))
+(assert_return (invoke $M1 "add" (i32.const 2) (i32.const 3)) (i32.const 5))
+(assert_trap (invoke "unreachable") "unreachable")
+(assert_exhaustion (invoke "loop") "call stack exhausted")
+
+(assert_trap (module (func $f unreachable) (start $f)) "unreachable")
+(assert_invalid (module (func (result i32) (i32.add (i32.const 0) (i64.const 0)))) "type mismatch")
+(assert_malformed (module quote "(func (i32.foo))") "unknown operator")
+(assert_malformed (module binary "\00asm\01\00") "unexpected end")
+(assert_unlinkable (module (import "M1" "missing" (func))) "unknown import")
+(assert_unlinkable (module (import "spectest" "print_i32" (func (param i64)))) "incompatible import type")
+
+(module
+  (table 10 anyfunc)
+  (elem (i32.const 5) $f)
+  (func $f (result i32) (i32.const 5))
+  (func (export "call") (param i32) (result i32) (call_indirect (result i32) (get_local 0)))
+)
+
+(assert_return (invoke "call" (i32.const 5)) (i32.const 5))
+(assert_trap (invoke "call" (i32.const 0)) "uninitialized element")
+(assert_trap (invoke "call" (i32.const 9)) "uninitialized element")
+(assert_trap (invoke "call" (i32.const 10)) "undefined element")
+(assert_unlinkable (module (table 1 anyfunc) (elem (i32.const 1) $f) (func $f)) "elements segment does not fit")
+
+;; the table and memory of spectest are shared by the modules importing them
+(module $S
+  (import "spectest" "table" (table 10 anyfunc))
+  (import "spectest" "memory" (memory 1))
+  (func (export "call") (param i32) (result i32) (call_indirect (result i32) (get_local 0)))
+  (func (export "load") (result i32) (i32.load8_u (i32.const 0)))
+)
+(assert_trap (invoke "call" (i32.const 0)) "uninitialized element")
+(module
+  (import "spectest" "table" (table 10 anyfunc))
+  (import "spectest" "memory" (memory 1))
+  (elem (i32.const 0) $f)
+  (data (i32.const 0) "\07")
+  (func $f (result i32) (i32.const 6))
+)
+(assert_return (invoke $S "call" (i32.const 0)) (i32.const 6))
+(assert_return (invoke $S "load") (i32.const 7))
@@ -0,0 +1,25 @@
+(module
+  (func (export "i32.extend8_s") (param i32) (result i32) (i32.extend8_s (get_local 0)))
+  (func (export "i32.extend16_s") (param i32) (result i32) (i32.extend16_s (get_local 0)))
+  (func (export "i64.extend8_s") (param i64) (result i64) (i64.extend8_s (get_local 0)))
+  (func (export "i64.extend16_s") (param i64) (result i64) (i64.extend16_s (get_local 0)))
+  (func (export "i64.extend32_s") (param i64) (result i64) (i64.extend32_s (get_local 0)))
+)
+
+(assert_return (invoke "i32.extend8_s" (i32.const 0x7f)) (i32.const 127))
+(assert_return (invoke "i32.extend8_s" (i32.const 0x80)) (i32.const -128))
+(assert_return (invoke "i32.extend8_s" (i32.const 0x12345678)) (i32.const 0x78))
+(assert_return (invoke "i32.extend8_s" (i32.const 0xfedcba80)) (i32.const -0x80))
+(assert_return (invoke "i32.extend16_s" (i32.const 0x7fff)) (i32.const 32767))
+(assert_return (invoke "i32.extend16_s" (i32.const 0x8000)) (i32.const -32768))
+(assert_return (invoke "i32.extend16_s" (i32.const 0xfedc8000)) (i32.const -0x8000))
+(assert_return (invoke "i64.extend8_s" (i64.const 0x80)) (i64.const -128))
+(assert_return (invoke "i64.extend8_s" (i64.const 0x0123456789abcd7f)) (i64.const 0x7f))
+(assert_return (invoke "i64.extend16_s" (i64.const 0x8000)) (i64.const -32768))
+(assert_return (invoke "i64.extend16_s" (i64.const -1)) (i64.const -1))
+(assert_return (invoke "i64.extend32_s" (i64.const 0x7fffffff)) (i64.const 2147483647))
+(assert_return (invoke "i64.extend32_s" (i64.const 0x80000000)) (i64.const -2147483648))
+(assert_return (invoke "i64.extend32_s" (i64.const 0x0123456789abcdef)) (i64.const 0xffffffff89abcdef))
+
+(assert_invalid (module (func (result i32) (i32.extend8_s (i64.const 0)))) "type mismatch")
+(assert_invalid (module (func (result i64) (i64.extend32_s (i32.const 0)))) "type mismatch")
@@ -0,0 +1,46 @@
+(module
+  (import "spectest" "global_i32" (global $g i32))
+  (import "spectest" "table" (table 10 anyfunc))
+  (import "spectest" "memory" (memory 1))
+  (global i32 (get_global $g))
+  (global (mut f64) (f64.const 1))
+  (func $start)
+  (start $start)
+  (elem (i32.const 0) $start)
+  (data (get_global $g) "a")
+  (export "f" (func $start))
+  (export "g" (global $g))
+  (export "t" (table 0))
+  (export "m" (memory 0))
+)
+
+(assert_invalid (module (func $f (param i32)) (start $f)) "start function")
+(assert_invalid (module (func $f (result i32) (i32.const 0)) (start $f)) "start function")
+(assert_invalid (module (start 1) (func)) "unknown function")
+(assert_invalid (module (memory 2 1)) "size minimum must not be greater than maximum")
+(assert_invalid (module (memory 65537)) "memory size must be at most 65536 pages (4GiB)")
+(assert_invalid (module (table 2 1 anyfunc)) "size minimum must not be greater than maximum")
+(assert_invalid (module (memory 0) (memory 0)) "multiple memories")
+(assert_invalid (module (import "spectest" "memory" (memory 0)) (memory 0)) "multiple memories")
+(assert_invalid (module (table 0 anyfunc) (table 0 anyfunc)) "multiple tables")
+(assert_invalid (module (global i32 (i64.const 0))) "type mismatch")
+(assert_invalid (module (global i32 (i32.const 0) (i32.const 0))) "type mismatch")
+(assert_invalid (module (global i32 (i32.add (i32.const 0) (i32.const 0)))) "constant expression required")
+(assert_invalid (module (global i32 (i32.const 0)) (global i32 (get_global 0))) "unknown global")
+(assert_invalid (module (memory 1) (data (i64.const 0) "a")) "type mismatch")
+(assert_invalid (module (memory 1) (data (f32.const 0) "a")) "type mismatch")
+(assert_invalid (module (table 1 anyfunc) (elem (i64.const 0))) "type mismatch")
+(assert_invalid (module (table 1 anyfunc) (elem (i32.const 0) 0)) "unknown function")
+(assert_invalid (module (export "f" (func 0))) "unknown function")
+(assert_invalid (module (export "g" (global 0))) "unknown global")
+(assert_invalid (module (export "t" (table 0))) "unknown table")
+(assert_invalid (module (export "m" (memory 0))) "unknown memory")
+;; the text format can't express duplicate exports
+(assert_invalid
+  (module binary
+    "\00asm" "\01\00\00\00"
+    "\01\04\01\60\00\00"                 ;; type section
+    "\03\02\01\00"                         ;; function section
+    "\07\09\02\01a\00\00\01a\00\00"     ;; export section, "a" twice
+    "\0a\04\01\02\00\0b")                 ;; code section
+  "duplicate export name")
@@ -0,0 +1,98 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wast implements a parser for the WebAssembly text format.
+//
+// Both the flat and folded forms of instructions are supported, as well as
+// symbolic identifiers, inline imports, exports and segments, and the
+// operator names of the current version of the text format (like
+// local.get or i32.trunc_f32_s) alongside the older ones used by the
+// operators package.
+package wast
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ParseModule parses the module in the text format src. src either
+// contains a single module, (module ...), or the fields of a module.
+// The returned module isn't decoded: its index spaces aren't populated.
+// Use ReadModule to get a module that can be executed.
+func ParseModule(src []byte) (*wasm.Module, error) {
+	nodes, err := parseNodes(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 1 && nodes[0].head() == "module" {
+		return moduleNode(nodes[0])
+	}
+	for _, n := range nodes {
+		if n.head() == "module" {
+			return nil, errorf(n.pos, "unexpected module")
+		}
+	}
+	return buildModule(Pos{Line: 1, Col: 1}, nodes)
+}
+
+// moduleNode builds the module defined by n, of the form
+// (module $id? field*), (module $id? binary string*) or
+// (module $id? quote string*).
+func moduleNode(n *node) (*wasm.Module, error) {
+	items := n.args()
+	_, i := id(items, 0)
+	if i < len(items) && items[i].isAtom() {
+		switch kw := items[i]; kw.tok.text {
+		case "binary":
+			b, err := dataString(items[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			// the features are checked when the module is decoded
+			// again from its binary encoding.
+			return wasm.ReadModule(bytes.NewReader(b), nil, wasm.AllFeatures)
+		case "quote":
+			src, err := dataString(items[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			return ParseModule(src)
+		default:
+			return nil, errorf(kw.pos, "unexpected %v in module", kw)
+		}
+	}
+	return buildModule(n.pos, items[i:])
+}
+
+// Assemble parses the module in the text format src, and returns its
+// binary encoding.
+func Assemble(src []byte) ([]byte, error) {
+	m, err := ParseModule(src)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := wasm.WriteModule(buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadModule reads a module in the text format from r, and decodes it like
+// wasm.ReadModule, using resolve to resolve its imports and accepting the
+// encodings of the given features.
+func ReadModule(r io.Reader, resolve wasm.ResolveFunc, features wasm.Features) (*wasm.Module, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := Assemble(src)
+	if err != nil {
+		return nil, err
+	}
+	return wasm.ReadModule(bytes.NewReader(b), resolve, features)
+}
@@ -0,0 +1,533 @@
+// Copyright 2017 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/disasm"
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func TestAssemble(t *testing.T) {
+	// names.wast doesn't include the custom sections of names.wasm
+	for _, name := range []string{"empty", "env", "f64", "globals", "i64", "int_exprs"} {
+		src, err := ioutil.ReadFile(filepath.Join("../wasm/testdata", name+".wast"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ioutil.ReadFile(filepath.Join("../wasm/testdata", name+".wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Assemble(src)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: assembled module differs from %s.wasm", name, name)
+		}
+	}
+}
+
+const envSource = `
+(module
+  (global (export "g") i32 (i32.const 41))
+  (func (export "neg") (param i32) (result i32)
+    (i32.sub (i32.const 0) (get_local 0))))
+`
+
+const testSource = `
+(module $test
+  (import "env" "g" (global $g i32))
+  (func $neg (import "env" "neg") (param i32) (result i32))
+  (type $binop (func (param i32 i32) (result i32)))
+  (memory (export "mem") (data "hello" "\00\ff"))
+  (table anyfunc (elem $add $sub))
+  (global $counter (mut i32) (get_global $g))
+
+  (func $add (type $binop) (i32.add (get_local 0) (get_local 1)))
+  (func $sub (type $binop)
+    get_local 0
+    get_local 1
+    i32.sub)
+
+  (func (export "dispatch") (param $op i32) (param $a i32) (param $b i32) (result i32)
+    (call_indirect (type $binop) (get_local $a) (get_local $b) (get_local $op)))
+
+  (func (export "negate") (param i32) (result i32) (call $neg (get_local 0)))
+
+  (func (export "load") (param $addr i32) (result i32)
+    (i32.load8_u offset=1 (get_local $addr)))
+
+  ;; flat control flow, with labels
+  (func (export "classify") (param $x i32) (result i32)
+    block $c
+      block $b
+        block $a
+          get_local $x
+          br_table $a $b $c
+        end
+        i32.const 10
+        return
+      end $b
+      i32.const 20
+      return
+    end
+    i32.const 30)
+
+  (func (export "fac") (param $n i64) (result i64) (local $acc i64)
+    (set_local $acc (i64.const 1))
+    (block $done
+      (loop $loop
+        (br_if $done (i64.eqz (get_local $n)))
+        (set_local $acc (i64.mul (get_local $acc) (get_local $n)))
+        (set_local $n (i64.sub (get_local $n) (i64.const 1)))
+        (br $loop)))
+    (get_local $acc))
+
+  (func (export "max") (param f64 f64) (result f64)
+    (if (result f64) (f64.gt (local.get 0) (local.get 1))
+      (then (local.get 0))
+      (else (local.get 1))))
+
+  (func (export "count") (result i32)
+    (global.set $counter (i32.add (global.get $counter) (i32.const 1)))
+    (global.get $counter))
+
+  (func (export "trunc") (param f32) (result i32) (i32.trunc_f32_s (local.get 0)))
+)
+`
+
+func TestReadModule(t *testing.T) {
+	env, err := ReadModule(strings.NewReader(envSource), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envVM, err := exec.NewVM(env, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(name string) (*wasm.Module, error) {
+		return env, nil
+	}
+	m, err := ReadModule(strings.NewReader(testSource), resolve, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m, wasm.MVP, exec.Import("env", envVM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want interface{}
+	}{
+		{"dispatch", []interface{}{int32(0), int32(7), int32(3)}, int32(10)},
+		{"dispatch", []interface{}{int32(1), int32(7), int32(3)}, int32(4)},
+		{"negate", []interface{}{int32(5)}, int32(-5)},
+		{"load", []interface{}{int32(0)}, int32('e')},
+		{"load", []interface{}{int32(5)}, int32(0xff)},
+		{"classify", []interface{}{int32(0)}, int32(10)},
+		{"classify", []interface{}{int32(1)}, int32(20)},
+		{"classify", []interface{}{int32(7)}, int32(30)},
+		{"fac", []interface{}{int64(10)}, int64(3628800)},
+		{"max", []interface{}{float64(1.5), float64(-2)}, float64(1.5)},
+		{"max", []interface{}{float64(1.5), float64(2)}, float64(2)},
+		{"count", nil, int32(42)},
+		{"count", nil, int32(43)},
+		{"trunc", []interface{}{float32(-3.9)}, int32(-3)},
+	} {
+		fn, err := vm.Export(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := fn.Call(tc.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tc.name, tc.args, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s%v: got=%#v, want=%#v", tc.name, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{"(module (func (i32.const 1x)))", "wast: 1:26: invalid i32 constant 1x"},
+		{"(module (func (i32.const 4294967296)))", "wast: 1:26: invalid i32 constant 4294967296"},
+		{"(module\n  (func (i32.foo)))", "wast: 2:10: unknown operator i32.foo"},
+		{"(module (func (param $x i32) (get_local $y)))", "wast: 1:41: unknown local $y"},
+		{"(module (func block $l br $m end))", "wast: 1:27: unknown label $m"},
+		{"(module (func block i32.const 0))", "wast: 1:15: unclosed block"},
+		{"(module (func end))", "wast: 1:15: unexpected end"},
+		{"(module (func (block end)))", "wast: 1:22: unexpected end"},
+		{"(module (func (i32.add) foo))", "wast: 1:25: unknown operator foo"},
+		{"(module (func (export \"f\")) (func (export \"f\")))", "wast: 1:43: duplicate export \"f\""},
+		{"(module (func) (import \"m\" \"f\" (func)))", "wast: 1:16: import after function, table, memory or global definition"},
+		{"(module (func $f) (func $f))", "wast: 1:25: duplicate function $f"},
+		{"(module (func (call $g)))", "wast: 1:21: unknown function $g"},
+		{"(module (memory 1) (data (i32.const 0) \"abc))", "wast: 1:40: unterminated string"},
+		{"(module (func)", "wast: 1:1: unclosed parenthesis"},
+		{"(module (func (i32.load align=3 (i32.const 0))))", "wast: 1:25: alignment must be a power of two"},
+		{"(module (foo))", "wast: 1:9: unexpected module field (foo ...)"},
+	} {
+		_, err := ParseModule([]byte(tc.src))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: unexpected error: got=%v, want=%s", tc.src, err, tc.err)
+		}
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		bits int
+		want uint64
+	}{
+		{"0", 32, 0},
+		{"-1", 32, 0xffffffff},
+		{"4294967295", 32, 0xffffffff},
+		{"-2147483648", 32, 0x80000000},
+		{"0xffff_ffff", 32, 0xffffffff},
+		{"+0x7fffffffffffffff", 64, 0x7fffffffffffffff},
+		{"-0x8000000000000000", 64, 0x8000000000000000},
+		{"1_000_000", 64, 1000000},
+	} {
+		got, err := parseInt(tc.s, tc.bits)
+		if err != nil || got != tc.want {
+			t.Errorf("parseInt(%q, %d): got=%#x, %v, want=%#x", tc.s, tc.bits, got, err, tc.want)
+		}
+	}
+
+	for _, s := range []string{"", "-", "0x", "1__0", "_1", "2147483648+", "-2147483649", "4294967296", "+2147483648"} {
+		if _, err := parseInt(s, 32); err == nil {
+			t.Errorf("parseInt(%q, 32): expected an error", s)
+		}
+	}
+
+	for _, tc := range []struct {
+		s    string
+		bits int
+		want uint64
+	}{
+		{"0.5", 32, 0x3f000000},
+		{"-0", 32, 0x80000000},
+		{"1e10", 64, 0x4202a05f20000000},
+		{"inf", 32, 0x7f800000},
+		{"-inf", 64, 0xfff0000000000000},
+		{"nan", 32, 0x7fc00000},
+		{"-nan:0x1", 64, 0xfff0000000000001},
+		{"0x1p-1", 32, 0x3f000000},
+		{"0x1.8p1", 64, 0x4008000000000000},
+		{"-0x1.fffffep127", 32, 0xff7fffff},
+		{"0x1p-149", 32, 0x00000001},
+		{"1_000.000_1", 64, 0x408f4000346dc5d6},
+	} {
+		got, err := parseFloat(tc.s, tc.bits)
+		if err != nil || got != tc.want {
+			t.Errorf("parseFloat(%q, %d): got=%#x, %v, want=%#x", tc.s, tc.bits, got, err, tc.want)
+		}
+	}
+
+	for _, s := range []string{"", "nan:0x0", "nan:0x800000", "0x1p128", "1e", ".5", "0xp1", "1.0.0"} {
+		if _, err := parseFloat(s, 32); err == nil {
+			t.Errorf("parseFloat(%q, 32): expected an error", s)
+		}
+	}
+}
+
+func TestWriteModule(t *testing.T) {
+	// printing a module and parsing it back gives the same module, except
+	// for custom sections
+	var files []string
+	for _, dir := range []string{"../wasm/testdata", "../exec/testdata"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := wasm.ReadModule(bytes.NewReader(raw), nil, wasm.AllFeatures)
+		if err != nil {
+			// modules with imports
+			continue
+		}
+		m.Customs = nil
+		want := new(bytes.Buffer)
+		if err := wasm.WriteModule(want, m); err != nil {
+			t.Fatal(err)
+		}
+
+		text := new(bytes.Buffer)
+		if err := WriteModule(text, m); err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		got, err := Assemble(text.Bytes())
+		if err != nil {
+			t.Errorf("%s: %v\n%s", file, err, text)
+			continue
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("%s: module differs after printing:\n%s", file, text)
+		}
+	}
+}
+
+func TestWriteModuleNames(t *testing.T) {
+	f, err := os.Open("../wasm/testdata/names.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := wasm.ReadModule(f, nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `(module $names
+  (type (;0;) (func (param i32 i32) (result i32)))
+  (type (;1;) (func))
+  (func $add (type 0) (param $a i32) (param $b i32) (result i32)
+    get_local $a
+    get_local $b
+    i32.add)
+  (func $nop (type 1)
+    nop)
+  (export "add" (func $add)))
+`
+	buf := new(bytes.Buffer)
+	if err := WriteModule(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteInstrs(t *testing.T) {
+	const src = `(module
+  (memory 1)
+  (func (param f32) (result f32)
+    (if (result f32) (i32.load8_u offset=4 align=1 (i32.const 0))
+      (then (f32.const -nan:0x7))
+      (else (block $b (br_if $b (f32.eq (get_local 0) (f32.const 0.5)))) (f32.const 1e30)))))`
+	m, err := ReadModule(strings.NewReader(src), nil, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := disasm.Disassemble(m.FunctionIndexSpace[0], m, wasm.MVP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `i32.const 0
+i32.load8_u offset=4
+if (result f32)
+  f32.const -nan:0x7
+else
+  block
+    get_local 0
+    f32.const 0.5
+    f32.eq
+    br_if 0
+  end
+  f32.const 1e+30
+end
+`
+	buf := new(bytes.Buffer)
+	if err := WriteInstrs(buf, d.Code); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	for _, tc := range []struct {
+		file     string
+		features wasm.Features
+		results  int
+	}{
+		{"script.wast", wasm.MVP, 33},
+		{"multi-value.wast", wasm.FeatureMultiValue, 18},
+		{"validate.wast", wasm.MVP, 23},
+		{"control.wast", wasm.MVP, 27},
+		{"sign-extension.wast", wasm.FeatureSignExtension, 17},
+		{"saturating-conv.wast", wasm.FeatureSaturatingConv, 33},
+	} {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := RunScript(src, tc.features)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != tc.results {
+			t.Errorf("%s: unexpected number of results: got=%d, want=%d", tc.file, len(results), tc.results)
+		}
+		for _, r := range results {
+			if !r.Passed() {
+				t.Errorf("%s: %v", tc.file, r)
+			}
+		}
+	}
+}
+
+func TestSpecScripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "spec", "*.wast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no spec scripts")
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := RunScript(src, wasm.MVP)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if len(results) == 0 {
+			t.Errorf("%s: no results", file)
+		}
+		for _, r := range results {
+			if !r.Passed() {
+				t.Errorf("%s: %v", file, r)
+			}
+		}
+	}
+}
+
+func TestRunScriptFailures(t *testing.T) {
+	const module = `(module (func (export "f") (param i32) (result i32) (get_local 0)))`
+	for _, tc := range []struct {
+		cmd  string
+		want string
+	}{
+		{`(assert_return (invoke "f" (i32.const 1)) (i32.const 2))`, "2:1: assert_return: FAIL: got (i32.const 1), want (i32.const 2)"},
+		{`(assert_return (invoke "f" (i32.const 1)) (f32.const nan:canonical))`, "2:1: assert_return: FAIL: got (i32.const 1), want (f32.const nan:canonical)"},
+		{`(assert_return (invoke "f" (i32.const 1)))`, "2:1: assert_return: FAIL: got 1 results, want 0"},
+		{`(assert_return (invoke "g"))`, `2:1: assert_return: FAIL: exec: no function or global exported with name "g"`},
+		{`(assert_trap (invoke "f" (i32.const 1)) "unreachable")`, `2:1: assert_trap: FAIL: no trap, want "unreachable"`},
+		{`(assert_invalid (module (func)) "type mismatch")`, `2:1: assert_invalid: FAIL: module was accepted, want "type mismatch"`},
+		{`(assert_trap (invoke "f" (i32.const 1)) "bad trap")`, `2:1: assert_trap: FAIL: unknown trap "bad trap"`},
+		{`(assert_malformed (module (global i32 (i32.const 0)) (func (set_global 0 (i32.const 1)))) "global is immutable")`, `2:1: assert_malformed: FAIL: invalid module: error while validating function 0 at offset 2: set_global: global 0 is immutable, want assert_malformed "global is immutable"`},
+		{`(assert_invalid (module (import "spectest" "g" (func))) "unknown import")`, `2:1: assert_invalid: FAIL: unlinkable module: wasm: couldn't find export with name g in module spectest, want assert_invalid "unknown import"`},
+		{`(invoke $M "f")`, "2:1: invoke: FAIL: wast: 2:9: unknown module $M"},
+		{`(assert_exhaustion (invoke "f" (i32.const 1)) "call stack exhausted")`, `2:1: assert_exhaustion: FAIL: no trap, want "call stack exhausted"`},
+		{`(foo)`, "2:1: foo: FAIL: wast: 2:1: unknown command foo"},
+	} {
+		results, err := RunScript([]byte(module+"\n"+tc.cmd), wasm.MVP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 || !results[0].Passed() {
+			t.Fatalf("%s: unexpected results: %v", tc.cmd, results)
+		}
+		if got := results[1].String(); got != tc.want {
+			t.Errorf("unexpected result:\ngot:  %s\nwant: %s", got, tc.want)
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	// the operators of the bulk memory proposal can't be assembled:
+	// i32.eqz is replaced by memory.fill in the binary encoding.
+	b, err := Assemble([]byte(`(module (memory 1) (func (i32.eqz (i32.const 0)) (drop)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulkMemory := bytes.Replace(b, []byte{0x41, 0, 0x45}, []byte{0x41, 0, 0xfc, 0x0b}, 1)
+	bulkMemory[len(bulkMemory)-10]++ // size of the code section
+	bulkMemory[len(bulkMemory)-8]++  // size of the function body
+	b, err = Assemble([]byte(`(module)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataCount := append(b, byte(wasm.SectionIDDataCount), 1, 0)
+
+	for _, tc := range []struct {
+		src       string
+		binary    []byte
+		feature   wasm.Features
+		supported bool
+	}{
+		{src: `(module (func (result i32 i32) (i32.const 0) (i32.const 0)))`, feature: wasm.FeatureMultiValue, supported: true},
+		{src: `(module (func (i32.const 0) (block (param i32) (drop))))`, feature: wasm.FeatureMultiValue, supported: true},
+		{src: `(module (global (export "g") (mut i32) (i32.const 0)))`, feature: wasm.FeatureMutableGlobals, supported: true},
+		{src: `(module (import "env" "g" (global (mut i32))))`, feature: wasm.FeatureMutableGlobals, supported: true},
+		{src: `(module (func (result i32) (i32.extend8_s (i32.const 0))))`, feature: wasm.FeatureSignExtension, supported: true},
+		{src: `(module (func (result i32) (i32.trunc_s:sat/f32 (f32.const 0))))`, feature: wasm.FeatureSaturatingConv, supported: true},
+		{binary: bulkMemory, feature: wasm.FeatureBulkMemory},
+		{binary: dataCount, feature: wasm.FeatureBulkMemory},
+	} {
+		read := func(features wasm.Features) error {
+			resolve := func(string) (*wasm.Module, error) {
+				return ReadModule(strings.NewReader(`(module (global (export "g") (mut i32) (i32.const 0)))`), nil, features)
+			}
+			var m *wasm.Module
+			var err error
+			if tc.binary != nil {
+				m, err = wasm.ReadModule(bytes.NewReader(tc.binary), resolve, features)
+			} else {
+				m, err = ReadModule(strings.NewReader(tc.src), resolve, features)
+			}
+			if err != nil {
+				return err
+			}
+			if err := validate.VerifyModule(m, features); err != nil {
+				if e, ok := err.(validate.Error); ok {
+					return e.Err
+				}
+				return err
+			}
+			for _, fn := range m.FunctionIndexSpace {
+				if _, err := disasm.Disassemble(fn, m, features); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if err := read(wasm.MVP); err != wasm.FeatureDisabledError(tc.feature) {
+			t.Errorf("%s%x: got %v, want %v", tc.src, tc.binary, err, wasm.FeatureDisabledError(tc.feature))
+		}
+		var want error
+		if !tc.supported {
+			want = wasm.FeatureUnsupportedError(tc.feature)
+		}
+		if err := read(tc.feature); err != want {
+			t.Errorf("%s%x: unexpected error with %v enabled: got %v, want %v", tc.src, tc.binary, tc.feature, err, want)
+		}
+	}
+}